@@ -0,0 +1,88 @@
+package modbusone
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// AdaptiveTimeout configures a client to learn the processing time of each
+// slave from the observed reply latency, and to use it as the ServerProcessingTime
+// of the slave, so that healthy slaves fail fast and slow slaves stop timing out.
+// The zero value disables learning.
+type AdaptiveTimeout struct {
+	_ struct{} // enforces keyed literals
+
+	// Window is the number of most recent latency samples kept per slave,
+	// 0 disables learning.
+	Window int
+	// MinSamples is the number of samples needed before the learned time is used,
+	// until then the configured ServerProcessingTime is used. 0 for 1.
+	MinSamples int
+	// Percentile of the samples to learn, in (0, 1]. 0 for 0.95.
+	Percentile float64
+	// Multiplier is applied to the learned percentile to give the processing time,
+	// leaving room for jitter. 0 for 2.
+	Multiplier float64
+	// MinProcessingTime is the lower bound of the learned processing time.
+	MinProcessingTime time.Duration
+	// MaxProcessingTime is the upper bound of the learned processing time,
+	// 0 for 4 times the configured ServerProcessingTime of the slave.
+	MaxProcessingTime time.Duration
+}
+
+// latencyWindow is a ring buffer of latency samples, with the percentile of
+// the samples updated on add.
+type latencyWindow struct {
+	samples    []time.Duration
+	sorted     []time.Duration // samples in increasing order
+	next       int
+	percentile time.Duration
+}
+
+// add adds sample d to a window of size, and updates the p percentile.
+func (w *latencyWindow) add(d time.Duration, size int, p float64) {
+	if len(w.samples) < size {
+		w.samples = append(w.samples, d)
+	} else {
+		old := w.samples[w.next]
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % size
+		i := sort.Search(len(w.sorted), func(i int) bool { return w.sorted[i] >= old })
+		w.sorted = append(w.sorted[:i], w.sorted[i+1:]...)
+	}
+	i := sort.Search(len(w.sorted), func(i int) bool { return w.sorted[i] >= d })
+	w.sorted = append(w.sorted, 0)
+	copy(w.sorted[i+1:], w.sorted[i:])
+	w.sorted[i] = d
+	i = int(math.Ceil(p*float64(len(w.sorted)))) - 1
+	w.percentile = w.sorted[max(0, min(i, len(w.sorted)-1))]
+}
+
+func (a *AdaptiveTimeout) percentile() float64 {
+	if a.Percentile <= 0 || a.Percentile > 1 {
+		return 0.95
+	}
+	return a.Percentile
+}
+
+func (a *AdaptiveTimeout) multiplier() float64 {
+	if a.Multiplier <= 0 {
+		return 2
+	}
+	return a.Multiplier
+}
+
+// learned returns the processing time learned from w, bounded by a and configured,
+// or configured if there are not enough samples.
+func (a *AdaptiveTimeout) learned(w *latencyWindow, configured time.Duration) time.Duration {
+	if a.Window <= 0 || w == nil || len(w.samples) < max(1, a.MinSamples) {
+		return configured
+	}
+	t := time.Duration(float64(w.percentile) * a.multiplier())
+	upper := a.MaxProcessingTime
+	if upper == 0 {
+		upper = 4 * configured
+	}
+	return max(a.MinProcessingTime, min(t, upper))
+}
@@ -0,0 +1,103 @@
+package modbusone_test
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestAdaptiveTimeout(t *testing.T) {
+	slaveID := byte(0x11)
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	dw := &dropWriter{Writer: w2}
+
+	cc := newMockSerial(t, "c", r2, w1, w1)
+	sc := newMockSerial(t, "s", r1, dw, w2)
+	client := NewRTUClient(cc, slaveID)
+	client.SetServerProcessingTime(time.Second)
+	defer client.Close()
+	server := NewRTUServer(sc, slaveID)
+	defer server.Close()
+
+	h := &SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			time.Sleep(time.Millisecond * 10)
+			return make([]uint16, quantity), nil
+		},
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			return nil
+		},
+	}
+	go client.Serve(h)
+	go server.Serve(h)
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+
+	// not learning by default
+	require.NoError(t, client.DoTransaction(read))
+	_, samples := client.GetLearnedLatency(slaveID)
+	require.Equal(t, 0, samples)
+
+	client.SetAdaptiveTimeout(AdaptiveTimeout{
+		Window:            10,
+		MinSamples:        3,
+		MinProcessingTime: time.Millisecond * 5,
+		MaxProcessingTime: time.Second * 2,
+	})
+	for i := 0; i < 2; i++ {
+		require.NoError(t, client.DoTransaction(read))
+	}
+	require.Equal(t, time.Second, client.GetSlaveProfile(slaveID).ServerProcessingTime, "not enough samples")
+	require.NoError(t, client.DoTransaction(read))
+	latency, samples := client.GetLearnedLatency(slaveID)
+	require.Equal(t, 3, samples)
+	require.GreaterOrEqual(t, latency, time.Millisecond*10)
+	learned := client.GetSlaveProfile(slaveID).ServerProcessingTime
+	require.Less(t, learned, time.Millisecond*500, "fail fast")
+	require.Equal(t, time.Second, client.GetSlaveProfile(slaveID+1).ServerProcessingTime, "per slave")
+	require.Zero(t, testing.AllocsPerRun(100, func() { client.GetSlaveProfile(slaveID) }), "learned once per sample")
+
+	// timeouts extend the learned time
+	atomic.StoreInt32(&dw.n, 1)
+	err = client.DoTransaction(read)
+	require.True(t, errors.Is(err, ErrServerTimeOut), err)
+	require.Greater(t, client.GetSlaveProfile(slaveID).ServerProcessingTime, learned)
+}
+
+func TestAdaptiveTimeoutSlowSlave(t *testing.T) {
+	slaveID := byte(0x11)
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	client := NewRTUClient(newMockSerial(t, "c", r2, w1, w1), slaveID)
+	client.SetServerProcessingTime(time.Millisecond * 20)
+	client.SetAdaptiveTimeout(AdaptiveTimeout{Window: 10})
+	defer client.Close()
+	server := NewRTUServer(newMockSerial(t, "s", r1, w2, w2), slaveID)
+	defer server.Close()
+	go client.Serve(NewMemoryHandler(10))
+	go server.Serve(&SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			time.Sleep(time.Millisecond * 30)
+			return make([]uint16, quantity), nil
+		},
+	})
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	err = client.DoTransaction(read)
+	require.True(t, errors.Is(err, ErrServerTimeOut), err)
+	latency, samples := client.GetLearnedLatency(slaveID)
+	require.Equal(t, 1, samples)
+	require.GreaterOrEqual(t, latency, time.Millisecond*20, "the time waited is learned")
+	require.Greater(t, client.GetSlaveProfile(slaveID).ServerProcessingTime, time.Millisecond*30,
+		"learned above the configured time")
+	time.Sleep(time.Millisecond * 50) // drop the late reply
+	require.NoError(t, client.DoTransaction(read), "slow slave stops timing out")
+}
@@ -0,0 +1,129 @@
+package modbusone_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestAppendAllocs(t *testing.T) {
+	registers := make([]uint16, FcReadHoldingRegisters.MaxPerPacket())
+	bools := make([]bool, FcReadCoils.MaxPerPacket())
+	data := make([]byte, 0, MaxPDUSize)
+	values := make([]uint16, 0, len(registers))
+	results := make([]bool, 0, len(bools))
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, uint16(len(registers)))
+	require.NoError(t, err)
+	rtu := make([]byte, 0, MaxRTUSize)
+
+	tests := []struct {
+		name string
+		f    func()
+	}{
+		{"AppendRegistersToData", func() { data = AppendRegistersToData(data[:0], registers) }},
+		{"AppendDataToRegisters", func() { values, _ = AppendDataToRegisters(values[:0], data) }},
+		{"AppendBoolsToData", func() { data, _ = AppendBoolsToData(data[:0], bools, FcReadCoils) }},
+		{"AppendDataToBools", func() { results, _ = AppendDataToBools(results[:0], data, uint16(len(bools)), FcReadCoils) }},
+		{"AppendRTU", func() { rtu = AppendRTU(rtu[:0], 1, req) }},
+		{"AppendReadReply", func() { rtu = req.AppendReadReply(rtu[:0], data[:2*len(registers)]) }},
+	}
+	data = AppendRegistersToData(data, registers)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Zero(t, testing.AllocsPerRun(100, tt.f))
+		})
+	}
+}
+
+func BenchmarkRegistersToData(b *testing.B) {
+	registers := make([]uint16, 100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		RegistersToData(registers)
+	}
+}
+
+func BenchmarkAppendRegistersToData(b *testing.B) {
+	registers := make([]uint16, 100)
+	data := make([]byte, 0, 200)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data = AppendRegistersToData(data[:0], registers)
+	}
+}
+
+func BenchmarkDataToRegisters(b *testing.B) {
+	data := make([]byte, 200)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DataToRegisters(data)
+	}
+}
+
+func BenchmarkAppendDataToRegisters(b *testing.B) {
+	data := make([]byte, 200)
+	values := make([]uint16, 0, 100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		values, _ = AppendDataToRegisters(values[:0], data)
+	}
+}
+
+func BenchmarkMakeRTU(b *testing.B) {
+	p, _ := FcReadHoldingRegisters.MakeRequestHeader(0, 100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		MakeRTU(1, p)
+	}
+}
+
+func BenchmarkAppendRTU(b *testing.B) {
+	p, _ := FcReadHoldingRegisters.MakeRequestHeader(0, 100)
+	rtu := make([]byte, 0, MaxRTUSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rtu = AppendRTU(rtu[:0], 1, p)
+	}
+}
+
+func BenchmarkMemoryHandlerOnRead(b *testing.B) {
+	h := NewMemoryHandler(1000)
+	req, _ := FcReadHoldingRegisters.MakeRequestHeader(0, 100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.OnRead(req)
+	}
+}
+
+func BenchmarkMemoryHandlerOnWrite(b *testing.B) {
+	h := NewMemoryHandler(1000)
+	req, _ := FcWriteMultipleRegisters.MakeRequestHeader(0, 100)
+	data := make([]byte, 200)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.OnWrite(req, data)
+	}
+}
+
+// BenchmarkRTUPoll polls a server with a client, the time per operation is
+// mostly the delays of the serial line.
+func BenchmarkRTUPoll(b *testing.B) {
+	clientSerial, serverSerial := newInternalSerial()
+	client := NewRTUClient(NewSerialContext(clientSerial, 1000000), 1)
+	server := NewRTUServer(NewSerialContext(serverSerial, 1000000), 1)
+	defer client.Close()
+	defer server.Close()
+	go client.Serve(NewMemoryHandler(1000))
+	go server.Serve(NewMemoryHandler(1000))
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 100)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.DoTransaction(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package modbusone
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrReplyWithoutRequest is the Transaction.Err of replies observed by a
+// BusMonitor without a matching request.
+var ErrReplyWithoutRequest = errors.New("reply without request")
+
+// Transaction is a request and its reply observed by a BusMonitor.
+type Transaction struct {
+	_ struct{} // enforces keyed literals
+
+	RequestTime time.Time // time the request is read, zero if there is no request
+	ReplyTime   time.Time // time the reply is read, zero if there is no reply
+	SlaveID     byte
+	Request     PDU  // nil if there is no known request
+	Reply       PDU  // nil for broadcasts and unanswered requests
+	Broadcast   bool // request to slave ID 0, which is never answered
+	Unanswered  bool // no reply before BusMonitor.ReplyTimeout or the next request
+
+	FunctionCode FunctionCode  // without the error bit
+	Address      uint16        // starting address of the request
+	Quantity     uint16        // number of values of the request
+	Bools        []bool        // values read or written, for bool function codes
+	Registers    []uint16      // values read or written, for uint16 function codes
+	Exception    ExceptionCode // 0 if not an exception reply
+	Err          error         // decoding error, such as a crc error or ErrReplyWithoutRequest
+}
+
+// BusMonitor passively listens to a bus, such as RS-485, and decodes the
+// transactions between clients and servers.
+type BusMonitor struct {
+	com    SerialContext
+	reader PacketReader
+
+	// ReplyTimeout is the time after a request to wait for a reply, in addition
+	// to the time needed for data transmission, before the request is marked
+	// as unanswered. Default 1 second. Set before calling Run.
+	ReplyTimeout time.Duration
+}
+
+// NewBusMonitor creates a BusMonitor reading from com, which should not be
+// written to.
+func NewBusMonitor(com SerialContext) *BusMonitor {
+	return &BusMonitor{
+		com:          com,
+		reader:       NewRTUBidirectionalPacketReader(com),
+		ReplyTimeout: time.Second,
+	}
+}
+
+// monitorFrame is a frame read by a BusMonitor.
+type monitorFrame struct {
+	t   time.Time
+	rtu RTU
+}
+
+// Run sends the observed transactions to out, until an error is read from the
+// SerialContext, which is returned. Run closes out before returning.
+func (m *BusMonitor) Run(out chan<- Transaction) error {
+	defer close(out)
+	frames := make(chan monitorFrame)
+	var readErr error
+	go func() {
+		defer close(frames)
+		for {
+			rb := make([]byte, MaxRTUSize)
+			n, err := m.reader.Read(rb)
+			if err != nil {
+				readErr = err
+				return
+			}
+			frames <- monitorFrame{t: time.Now(), rtu: rb[:n]}
+		}
+	}()
+
+	var pending *Transaction
+	var timeout <-chan time.Time
+	flush := func() {
+		if pending != nil {
+			pending.Unanswered = true
+			out <- *pending
+			pending = nil
+			timeout = nil
+		}
+	}
+	for {
+		select {
+		case <-timeout:
+			flush()
+		case f, ok := <-frames:
+			if !ok {
+				flush()
+				return readErr
+			}
+			pdu, err := f.rtu.GetPDU()
+			if err != nil {
+				out <- Transaction{RequestTime: f.t, SlaveID: f.rtu[0], Err: err}
+				continue
+			}
+			if pending != nil && f.rtu[0] == pending.SlaveID && isMonitoredReply(pending.Request, pdu) {
+				pending.ReplyTime = f.t
+				pending.Reply = pdu
+				pending.decode()
+				out <- *pending
+				pending = nil
+				timeout = nil
+				continue
+			}
+			flush()
+			if pdu.ValidateRequest() != nil || GetPDUSizeFromHeader(pdu, false) != len(pdu) {
+				tx := Transaction{ReplyTime: f.t, SlaveID: f.rtu[0], Reply: pdu, Err: ErrReplyWithoutRequest}
+				_, tx.FunctionCode = pdu.GetFunctionCode().SeparateError()
+				out <- tx
+				continue
+			}
+			tx := Transaction{RequestTime: f.t, SlaveID: f.rtu[0], Request: pdu}
+			if tx.SlaveID == 0 {
+				tx.Broadcast = true
+				tx.decode()
+				out <- tx
+				continue
+			}
+			tx.decode() // request only, until a reply is found
+			pending = &tx
+			timeout = time.After(m.ReplyTimeout + m.com.BytesDelay(len(f.rtu)+MaxRTUSize))
+		}
+	}
+}
+
+// Close closes the SerialContext, which stops Run.
+func (m *BusMonitor) Close() error {
+	return m.com.Close()
+}
+
+// isMonitoredReply returns true if rep is a normal or exception reply to req.
+func isMonitoredReply(req, rep PDU) bool {
+	if hasErr, fc := rep.GetFunctionCode().SeparateError(); hasErr {
+		return fc == req.GetFunctionCode() && len(rep) == 2
+	}
+	return IsRequestReply(req, rep)
+}
+
+// decode fills in the fields decoded from Request and Reply.
+func (t *Transaction) decode() {
+	req := t.Request
+	fc := req.GetFunctionCode()
+	t.FunctionCode = fc
+	t.Address = req.GetAddress()
+	t.Quantity, t.Err = req.GetRequestCount()
+	t.Bools, t.Registers, t.Exception = nil, nil, 0
+	if t.Err != nil {
+		return
+	}
+	if len(t.Reply) > 1 && t.Reply.GetFunctionCode() != fc {
+		t.Exception = ExceptionCode(t.Reply[1])
+		if !fc.IsWriteToServer() {
+			return
+		}
+	}
+	var data []byte
+	switch {
+	case fc.IsWriteToServer():
+		data, t.Err = req.GetRequestValues()
+	case t.Reply != nil:
+		data, t.Err = t.Reply.GetReplyValues()
+	default:
+		return // no values yet
+	}
+	if t.Err != nil {
+		return
+	}
+	if fc.IsBool() {
+		t.Bools, t.Err = DataToBools(data, t.Quantity, fc)
+	} else {
+		t.Registers, t.Err = DataToRegisters(data)
+	}
+}
@@ -0,0 +1,101 @@
+package modbusone_test
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestBusMonitor(t *testing.T) {
+	slaveID := byte(0x11)
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	rt, wt := io.Pipe() // tap of the bus for the monitor
+	// write to the tap first, so the monitor sees requests before replies
+	dw := &dropWriter{Writer: io.MultiWriter(wt, w2)}
+
+	client := NewRTUClient(newMockSerial(t, "c", r2, io.MultiWriter(wt, w1), w1), slaveID)
+	client.SetServerProcessingTime(time.Second / 20)
+	defer client.Close()
+	server := NewRTUServer(newMockSerial(t, "s", r1, dw, w2), slaveID)
+	defer server.Close()
+	monitor := NewBusMonitor(newMockSerial(t, "m", rt, io.Discard, wt))
+	monitor.ReplyTimeout = time.Second / 20
+
+	h := &SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			if address == 100 {
+				return nil, EcIllegalDataAddress
+			}
+			return []uint16{address, address + 1}[:quantity], nil
+		},
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			return nil
+		},
+		ReadCoils: func(address, quantity uint16) ([]bool, error) {
+			return []bool{true, false, true}[:quantity], nil
+		},
+		WriteCoils: func(address uint16, values []bool) error {
+			return nil
+		},
+	}
+	go client.ServeRTU(MultiIDHandler{slaveID: h, 0: h})
+	go server.Serve(h)
+	transactions := make(chan Transaction, 10)
+	done := make(chan error)
+	go func() { done <- monitor.Run(transactions) }()
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(5, 2)
+	require.NoError(t, err)
+	require.NoError(t, client.DoTransaction(read))
+	tx := <-transactions
+	require.Equal(t, slaveID, tx.SlaveID)
+	require.Equal(t, read, tx.Request)
+	require.NotNil(t, tx.Reply)
+	require.False(t, tx.RequestTime.IsZero())
+	require.False(t, tx.ReplyTime.Before(tx.RequestTime))
+	require.Equal(t, FcReadHoldingRegisters, tx.FunctionCode)
+	require.Equal(t, uint16(5), tx.Address)
+	require.Equal(t, uint16(2), tx.Quantity)
+	require.Equal(t, []uint16{5, 6}, tx.Registers)
+	require.NoError(t, tx.Err)
+
+	coils, err := FcReadCoils.MakeRequestHeader(0, 3)
+	require.NoError(t, err)
+	require.NoError(t, client.DoTransaction(coils))
+	tx = <-transactions
+	require.Equal(t, []bool{true, false, true}, tx.Bools)
+
+	bad, err := FcReadHoldingRegisters.MakeRequestHeader(100, 1)
+	require.NoError(t, err)
+	require.Error(t, client.DoTransaction(bad))
+	tx = <-transactions
+	require.Equal(t, EcIllegalDataAddress, tx.Exception)
+	require.Equal(t, FcReadHoldingRegisters, tx.FunctionCode)
+
+	write, err := FcWriteMultipleCoils.MakeRequestHeader(0, 3)
+	require.NoError(t, err)
+	errChan := make(chan error)
+	client.StartTransactionToServer(0, write, errChan)
+	require.NoError(t, <-errChan)
+	tx = <-transactions
+	require.True(t, tx.Broadcast)
+	require.Nil(t, tx.Reply)
+	require.Equal(t, []bool{true, false, true}, tx.Bools, "values of writes from the request")
+
+	atomic.StoreInt32(&dw.n, 1)
+	require.Error(t, client.DoTransaction(read))
+	tx = <-transactions
+	require.True(t, tx.Unanswered)
+	require.Equal(t, read, tx.Request)
+	require.Nil(t, tx.Reply)
+
+	monitor.Close()
+	require.Error(t, <-done)
+	_, ok := <-transactions
+	require.False(t, ok, "closed by Run")
+}
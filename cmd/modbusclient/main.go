@@ -0,0 +1,173 @@
+// Command modbusclient reads and writes the coils and registers of a Modbus
+// server over TCP, RTU over TCP, or a serial port.
+//
+// Read 4 holding registers from address 100 over TCP:
+//
+//	modbusclient -t tcp -a 192.168.1.10:502 -table holding -addr 100 -n 4
+//
+// Read a float32 in word swapped order every second, from register 40101 of
+// slave 3 on a serial port, as CSV:
+//
+//	modbusclient -t serial -a /dev/ttyUSB0 -r 9600 -id 3 -notation modicon -addr 40101 \
+//	    -type float32 -order CDAB -interval 1s -o csv
+//
+// With -interval, failed transactions are reported to stderr and polling
+// continues.
+//
+// Write three coils starting from address 0:
+//
+//	modbusclient -t tcp -a localhost -table coils -addr 0 -w 1,0,1
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarm/serial"
+	"github.com/xiegeo/modbusone"
+)
+
+var (
+	transport = flag.String("t", "tcp", "transport: tcp, rtutcp (RTU over TCP), or serial")
+	location  = flag.String("a", "", "required server location, host[:port] for tcp and rtutcp, "+
+		"or the device such as: /dev/ttyS0 in linux or com1 in windows for serial")
+	baudRate = flag.Int("r", 19200, "baud rate, for serial and rtutcp timing")
+	parity   = flag.String("p", "E", "parity: N - None, E - Even, O - Odd")
+	stopBits = flag.Int("s", 1, "stop bits: 1 or 2")
+	slaveID  = flag.Uint64("id", 1, "the slaveId of the server, 0 for multicast writes")
+
+	tableName = flag.String("table", "", "data table: coils, discrete, input or holding, "+
+		"can be omitted with modicon notation")
+	addr     = flag.String("addr", "0", "the start address, see -notation")
+	notation = flag.String("notation", "protocol", "address notation: protocol for 0 based addresses "+
+		"(decimal or 0x hex), one for 1 based addresses, or modicon for 5 or 6 digit references "+
+		"such as 40001 where the first digit is the table")
+	count     = flag.Int("n", 1, "the number of values to read")
+	typeName  = flag.String("type", "", "data type of registers: "+strings.Join(dataTypeNames(), ", ")+", default uint16")
+	byteOrder = flag.String("order", "ABCD", "byte order of multi register values: "+
+		"ABCD (big endian), DCBA (little endian), BADC (byte swapped) or CDAB (word swapped)")
+	writeValues = flag.String("w", "", "comma separated values to write instead of reading, "+
+		"such as 1,0,1 for coils")
+
+	interval = flag.Duration("interval", 0, "repeat every interval, 0 to run once")
+	repeat   = flag.Int("count", 0, "number of times to run with -interval, 0 for no limit")
+	output   = flag.String("o", "table", "output format: table, json or csv")
+	timeout  = flag.Duration("timeout", time.Second, "time to wait for the server")
+
+	verbose = flag.Bool("v", false, "prints debugging information")
+)
+
+func main() {
+	flag.Parse()
+	if *verbose {
+		modbusone.SetDebugOut(os.Stderr)
+	}
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	req, err := parseRequest()
+	if err != nil {
+		return err
+	}
+	id, err := modbusone.Uint64ToSlaveID(*slaveID)
+	if err != nil {
+		return fmt.Errorf("set slaveID error: %w", err)
+	}
+	if id == 0 && req.write == nil {
+		return fmt.Errorf("slaveID 0 (multicast) can only be used to write")
+	}
+	w, err := newWriter(*output, os.Stdout, req)
+	if err != nil {
+		return err
+	}
+	client, err := dial(id)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	h := modbusone.NewMemoryHandler(0x10000)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- client.Serve(h) }()
+
+	for i := 1; ; i++ {
+		start := time.Now()
+		if err := req.do(client, id, h, w); err != nil {
+			if *interval <= 0 {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", err) // keep polling
+		}
+		if *interval <= 0 || (*repeat > 0 && i >= *repeat) {
+			return w.Flush()
+		}
+		select {
+		case err := <-serveErr:
+			return fmt.Errorf("serve error: %w", err)
+		case <-time.After(time.Until(start.Add(*interval))):
+		}
+	}
+}
+
+// dial connects to the server as selected by flags.
+func dial(id byte) (modbusone.Client, error) {
+	switch *transport {
+	case "tcp", "rtutcp":
+		host := *location
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, strconv.Itoa(modbusone.TCPPort))
+		}
+		conn, err := net.DialTimeout("tcp", host, *timeout)
+		if err != nil {
+			return nil, fmt.Errorf("dial error: %w", err)
+		}
+		if *transport == "tcp" {
+			return &tcpClient{TCPClient: modbusone.NewTCPClient(conn, id), conn: conn}, nil
+		}
+		return newRTUClient(modbusone.NewSerialContext(conn, int64(*baudRate)), id), nil
+	case "serial":
+		config := serial.Config{
+			Name:     *location,
+			Baud:     *baudRate,
+			StopBits: serial.StopBits(*stopBits),
+		}
+		if len(*parity) > 0 {
+			config.Parity = serial.Parity((*parity)[0])
+		}
+		s, err := serial.OpenPort(&config)
+		if err != nil {
+			return nil, fmt.Errorf("open serial error: %w", err)
+		}
+		return newRTUClient(modbusone.NewSerialContext(s, int64(*baudRate)), id), nil
+	}
+	return nil, fmt.Errorf("unknown transport %q", *transport)
+}
+
+func newRTUClient(com modbusone.SerialContext, id byte) modbusone.Client {
+	c := modbusone.NewRTUClient(com, id)
+	c.SetServerProcessingTime(*timeout)
+	return c
+}
+
+// tcpClient sets a deadline for each transaction, since TCPClient waits for
+// replies without a timeout.
+type tcpClient struct {
+	*modbusone.TCPClient
+	conn net.Conn
+}
+
+func (c *tcpClient) StartTransactionToServer(slaveID byte, req modbusone.PDU, errChan chan error) {
+	if err := c.conn.SetDeadline(time.Now().Add(*timeout)); err != nil {
+		go func() { errChan <- err }()
+		return
+	}
+	c.TCPClient.StartTransactionToServer(slaveID, req, errChan)
+}
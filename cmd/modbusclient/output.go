@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// writer writes samples in an output format.
+type writer interface {
+	Write(s sample) error
+	Flush() error
+}
+
+func newWriter(format string, w io.Writer, r *request) (writer, error) {
+	switch format {
+	case "table":
+		return &tableWriter{w: w}, nil
+	case "json":
+		return &jsonWriter{enc: json.NewEncoder(w), table: r.table.String()}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+// formatValue formats floats in the shortest form, and other values as %v.
+func formatValue(v interface{}) string {
+	switch f := v.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(f), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// isFinite returns false for NaN and infinite floats.
+func isFinite(v interface{}) bool {
+	switch f := v.(type) {
+	case float32:
+		return !math.IsNaN(float64(f)) && !math.IsInf(float64(f), 0)
+	case float64:
+		return !math.IsNaN(f) && !math.IsInf(f, 0)
+	}
+	return true
+}
+
+// tableWriter writes aligned address and value columns, with the time before
+// each sample after the first.
+type tableWriter struct {
+	w       io.Writer
+	samples int
+}
+
+func (t *tableWriter) Write(s sample) error {
+	if t.samples > 0 {
+		fmt.Fprintf(t.w, "\n%v\n", s.Time.Format(time.RFC3339Nano))
+	}
+	t.samples++
+	tw := tabwriter.NewWriter(t.w, 0, 0, 2, ' ', 0)
+	for i, v := range s.Values {
+		fmt.Fprintf(tw, "%v\t%v\n", s.Address[i], formatValue(v))
+	}
+	return tw.Flush()
+}
+
+func (t *tableWriter) Flush() error { return nil }
+
+// jsonWriter writes a JSON object per sample.
+type jsonWriter struct {
+	enc   *json.Encoder
+	table string
+}
+
+type jsonValue struct {
+	Address string      `json:"address"`
+	Value   interface{} `json:"value"`
+}
+
+func (j *jsonWriter) Write(s sample) error {
+	values := make([]jsonValue, len(s.Values))
+	for i, v := range s.Values {
+		if !isFinite(v) {
+			v = formatValue(v) // not supported by JSON numbers
+		}
+		values[i] = jsonValue{Address: s.Address[i], Value: v}
+	}
+	return j.enc.Encode(struct {
+		Time   time.Time   `json:"time"`
+		Table  string      `json:"table"`
+		Values []jsonValue `json:"values"`
+	}{s.Time, j.table, values})
+}
+
+func (j *jsonWriter) Flush() error { return nil }
+
+// csvWriter writes a row per sample, after a header of addresses.
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvWriter) Write(s sample) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(append([]string{"time"}, s.Address...)); err != nil {
+			return err
+		}
+	}
+	row := []string{s.Time.Format(time.RFC3339Nano)}
+	for _, v := range s.Values {
+		row = append(row, formatValue(v))
+	}
+	if err := c.w.Write(row); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
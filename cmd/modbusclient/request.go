@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiegeo/modbusone"
+)
+
+// request is a read or write of consecutive values, as configured by flags.
+type request struct {
+	table    modbusone.Table
+	address  uint16
+	notation string
+	dataType dataType
+	order    string
+	count    int      // number of values of dataType
+	write    []string // values to write, nil for read
+}
+
+// quantity returns the number of coils or registers of the request.
+func (r *request) quantity() int {
+	if r.table.IsBool() {
+		return r.count
+	}
+	return r.count * r.dataType.words
+}
+
+// parseRequest makes a request from flags.
+func parseRequest() (*request, error) {
+	r := &request{notation: *notation, count: *count}
+	if *tableName != "" {
+		t, err := parseTable(*tableName)
+		if err != nil {
+			return nil, err
+		}
+		r.table = t
+	}
+	var err error
+	r.table, r.address, err = parseAddress(*addr, r.notation, r.table)
+	if err != nil {
+		return nil, err
+	}
+	if !r.table.Valid() {
+		return nil, fmt.Errorf("-table is required")
+	}
+	name := *typeName
+	if name == "" {
+		name = "uint16"
+		if r.table.IsBool() {
+			name = "bool"
+		}
+	}
+	dt, ok := dataTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown data type %q", name)
+	}
+	if (dt.words == 0) != r.table.IsBool() {
+		return nil, fmt.Errorf("data type %v can not be used with %v", name, r.table)
+	}
+	r.dataType = dt
+	r.order = strings.ToUpper(*byteOrder)
+	if _, _, err := parseByteOrder(r.order); err != nil {
+		return nil, err
+	}
+	if *writeValues != "" {
+		if r.table != modbusone.TableCoils && r.table != modbusone.TableHoldingRegisters {
+			return nil, fmt.Errorf("%v can not be written", r.table)
+		}
+		r.write = strings.Split(*writeValues, ",")
+		r.count = len(r.write)
+	}
+	if r.count < 1 || int(r.address)+r.quantity() > 0x10000 {
+		return nil, fmt.Errorf("%v values from address %v are out of range", r.count, r.address)
+	}
+	return r, nil
+}
+
+// parseTable parses a table name.
+func parseTable(s string) (modbusone.Table, error) {
+	switch strings.ToLower(s) {
+	case "coils", "coil", "c", "0x":
+		return modbusone.TableCoils, nil
+	case "discrete", "discreteinputs", "d", "1x":
+		return modbusone.TableDiscreteInputs, nil
+	case "input", "inputregisters", "i", "3x":
+		return modbusone.TableInputRegisters, nil
+	case "holding", "holdingregisters", "h", "4x":
+		return modbusone.TableHoldingRegisters, nil
+	}
+	return modbusone.TableNone, fmt.Errorf("unknown table %q", s)
+}
+
+// modiconPrefixes are the first digits of modicon references, by table.
+var modiconPrefixes = map[byte]modbusone.Table{
+	'0': modbusone.TableCoils,
+	'1': modbusone.TableDiscreteInputs,
+	'3': modbusone.TableInputRegisters,
+	'4': modbusone.TableHoldingRegisters,
+}
+
+// parseAddress parses s in notation, and returns the table (t if not part of
+// the notation) and 0 based protocol address.
+func parseAddress(s, notation string, t modbusone.Table) (modbusone.Table, uint16, error) {
+	switch notation {
+	case "protocol":
+		n, err := strconv.ParseUint(s, 0, 16)
+		if err != nil {
+			return t, 0, fmt.Errorf("address %v parse error: %w", s, err)
+		}
+		return t, uint16(n), nil
+	case "one":
+		n, err := strconv.ParseUint(s, 0, 32)
+		if err != nil || n < 1 || n > 0x10000 {
+			return t, 0, fmt.Errorf("address %v is not in 1 to 65536", s)
+		}
+		return t, uint16(n - 1), nil
+	case "modicon":
+		if len(s) != 5 && len(s) != 6 {
+			return t, 0, fmt.Errorf("modicon reference %v must be 5 or 6 digits", s)
+		}
+		mt, ok := modiconPrefixes[s[0]]
+		if !ok {
+			return t, 0, fmt.Errorf("modicon reference %v must start with 0, 1, 3, or 4", s)
+		}
+		if t != modbusone.TableNone && t != mt {
+			return t, 0, fmt.Errorf("modicon reference %v is not in %v", s, t)
+		}
+		n, err := strconv.ParseUint(s[1:], 10, 32)
+		if err != nil || n < 1 || n > 0x10000 {
+			return t, 0, fmt.Errorf("modicon reference %v is out of range", s)
+		}
+		return mt, uint16(n - 1), nil
+	}
+	return t, 0, fmt.Errorf("unknown address notation %q", notation)
+}
+
+// formatAddress formats address in notation.
+func formatAddress(address uint16, notation string, t modbusone.Table) string {
+	switch notation {
+	case "one":
+		return strconv.Itoa(int(address) + 1)
+	case "modicon":
+		for prefix, mt := range modiconPrefixes {
+			if mt == t {
+				if address < 9999 {
+					return fmt.Sprintf("%c%04d", prefix, int(address)+1)
+				}
+				return fmt.Sprintf("%c%05d", prefix, int(address)+1)
+			}
+		}
+	}
+	return strconv.Itoa(int(address))
+}
+
+// sample is the result of one read or write.
+type sample struct {
+	Time    time.Time
+	Address []string
+	Values  []interface{}
+}
+
+// do runs the request with client, using h to hold values, and writes the
+// values read or written to w.
+func (r *request) do(client modbusone.Client, id byte, h *modbusone.MemoryHandler, w writer) error {
+	quantity := uint16(r.quantity())
+	fc := r.table.ReadFunctionCode()
+	var values []interface{}
+	if r.write != nil {
+		var err error
+		values, err = r.prepareWrite(h)
+		if err != nil {
+			return err
+		}
+		fc = modbusone.FcWriteMultipleRegisters
+		if r.table.IsBool() {
+			fc = modbusone.FcWriteMultipleCoils
+		}
+	}
+	reqs, err := modbusone.MakePDURequestHeaders(fc, r.address, quantity, nil)
+	if err != nil {
+		return err
+	}
+	n, err := modbusone.DoTransactions(client, id, reqs)
+	if err != nil {
+		return fmt.Errorf("%w in request %v/%v: %v", err, n+1, len(reqs), reqs[n])
+	}
+	if r.write == nil {
+		values, err = r.readValues(h)
+		if err != nil {
+			return err
+		}
+	}
+	s := sample{Time: time.Now(), Values: values}
+	stride := 1
+	if r.dataType.words > 1 {
+		stride = r.dataType.words
+	}
+	for i := range values {
+		address := r.address + uint16(i*stride)
+		s.Address = append(s.Address, formatAddress(address, r.notation, r.table))
+	}
+	return w.Write(s)
+}
+
+// prepareWrite sets the values to write in h, and returns them.
+func (r *request) prepareWrite(h *modbusone.MemoryHandler) ([]interface{}, error) {
+	values := make([]interface{}, len(r.write))
+	if r.table.IsBool() {
+		bs := make([]bool, len(r.write))
+		for i, s := range r.write {
+			v, err := strconv.ParseBool(strings.TrimSpace(s))
+			if err != nil {
+				return nil, fmt.Errorf("value %v parse error: %w", s, err)
+			}
+			bs[i], values[i] = v, v
+		}
+		return values, h.WriteBools(r.table, r.address, bs)
+	}
+	var regs []uint16
+	for i, s := range r.write {
+		v, words, err := r.dataType.encode(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("value %v parse error: %w", s, err)
+		}
+		values[i] = v
+		regs = append(regs, orderWords(words, r.order)...)
+	}
+	return values, h.WriteRegisters(r.table, r.address, regs)
+}
+
+// readValues returns the values read into h.
+func (r *request) readValues(h *modbusone.MemoryHandler) ([]interface{}, error) {
+	values := make([]interface{}, r.count)
+	if r.table.IsBool() {
+		bs, err := h.ReadBools(r.table, r.address, uint16(r.count))
+		if err != nil {
+			return nil, err
+		}
+		for i, b := range bs {
+			values[i] = b
+		}
+		return values, nil
+	}
+	regs, err := h.ReadRegisters(r.table, r.address, uint16(r.quantity()))
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		words := regs[i*r.dataType.words : (i+1)*r.dataType.words]
+		values[i] = r.dataType.decode(orderWords(words, r.order))
+	}
+	return values, nil
+}
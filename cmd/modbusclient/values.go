@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dataType converts between values and registers in big endian (ABCD) order.
+type dataType struct {
+	words  int // number of registers per value, 0 for bools
+	decode func(words []uint16) interface{}
+	encode func(s string) (interface{}, []uint16, error)
+}
+
+var dataTypes = map[string]dataType{
+	"bool":    {},
+	"uint16":  intType(1, false),
+	"int16":   intType(1, true),
+	"uint32":  intType(2, false),
+	"int32":   intType(2, true),
+	"uint64":  intType(4, false),
+	"int64":   intType(4, true),
+	"float32": floatType(2),
+	"float64": floatType(4),
+	"hex":     hexType(),
+}
+
+func dataTypeNames() []string {
+	var names []string
+	for name := range dataTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// wordsToUint64 joins big endian words.
+func wordsToUint64(words []uint16) uint64 {
+	var v uint64
+	for _, w := range words {
+		v = v<<16 | uint64(w)
+	}
+	return v
+}
+
+// uint64ToWords splits v into n big endian words.
+func uint64ToWords(v uint64, n int) []uint16 {
+	words := make([]uint16, n)
+	for i := n - 1; i >= 0; i-- {
+		words[i] = uint16(v)
+		v >>= 16
+	}
+	return words
+}
+
+func intType(words int, signed bool) dataType {
+	bits := words * 16
+	return dataType{
+		words: words,
+		decode: func(ws []uint16) interface{} {
+			v := wordsToUint64(ws)
+			if signed {
+				return int64(v<<(64-bits)) >> (64 - bits) // sign extend
+			}
+			return v
+		},
+		encode: func(s string) (interface{}, []uint16, error) {
+			if signed {
+				v, err := strconv.ParseInt(s, 0, bits)
+				return v, uint64ToWords(uint64(v), words), err
+			}
+			v, err := strconv.ParseUint(s, 0, bits)
+			return v, uint64ToWords(v, words), err
+		},
+	}
+}
+
+func floatType(words int) dataType {
+	bits := words * 16
+	return dataType{
+		words: words,
+		decode: func(ws []uint16) interface{} {
+			if bits == 32 {
+				return math.Float32frombits(uint32(wordsToUint64(ws)))
+			}
+			return math.Float64frombits(wordsToUint64(ws))
+		},
+		encode: func(s string) (interface{}, []uint16, error) {
+			v, err := strconv.ParseFloat(s, bits)
+			if bits == 32 {
+				return v, uint64ToWords(uint64(math.Float32bits(float32(v))), words), err
+			}
+			return v, uint64ToWords(math.Float64bits(v), words), err
+		},
+	}
+}
+
+// hexType is a register shown in hex.
+func hexType() dataType {
+	return dataType{
+		words: 1,
+		decode: func(ws []uint16) interface{} {
+			return fmt.Sprintf("0x%04X", ws[0])
+		},
+		encode: func(s string) (interface{}, []uint16, error) {
+			s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+			v, err := strconv.ParseUint(s, 16, 16)
+			return fmt.Sprintf("0x%04X", v), []uint16{uint16(v)}, err
+		},
+	}
+}
+
+// parseByteOrder returns whether bytes in each word, and the order of the words,
+// are swapped from big endian (ABCD).
+func parseByteOrder(order string) (byteSwap, wordSwap bool, err error) {
+	switch order {
+	case "ABCD":
+		return false, false, nil
+	case "DCBA":
+		return true, true, nil
+	case "BADC":
+		return true, false, nil
+	case "CDAB":
+		return false, true, nil
+	}
+	return false, false, fmt.Errorf("unknown byte order %q", order)
+}
+
+// orderWords converts between big endian and order, it is its own inverse.
+func orderWords(words []uint16, order string) []uint16 {
+	byteSwap, wordSwap, _ := parseByteOrder(order)
+	out := make([]uint16, len(words))
+	for i, w := range words {
+		if byteSwap {
+			w = w<<8 | w>>8
+		}
+		if wordSwap {
+			out[len(words)-1-i] = w
+		} else {
+			out[i] = w
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xiegeo/modbusone"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		s, notation string
+		table       modbusone.Table
+		wantTable   modbusone.Table
+		want        uint16
+		wantErr     bool
+	}{
+		{s: "100", notation: "protocol", table: modbusone.TableCoils, wantTable: modbusone.TableCoils, want: 100},
+		{s: "0x10", notation: "protocol", wantTable: modbusone.TableNone, want: 16},
+		{s: "1", notation: "one", want: 0},
+		{s: "0", notation: "one", wantErr: true},
+		{s: "40001", notation: "modicon", wantTable: modbusone.TableHoldingRegisters, want: 0},
+		{s: "365536", notation: "modicon", wantTable: modbusone.TableInputRegisters, want: 65535},
+		{s: "00010", notation: "modicon", table: modbusone.TableCoils, wantTable: modbusone.TableCoils, want: 9},
+		{s: "40001", notation: "modicon", table: modbusone.TableCoils, wantErr: true},
+		{s: "20001", notation: "modicon", wantErr: true},
+		{s: "4001", notation: "modicon", wantErr: true},
+	}
+	for _, tt := range tests {
+		table, address, err := parseAddress(tt.s, tt.notation, tt.table)
+		if tt.wantErr {
+			require.Error(t, err, tt.s)
+			continue
+		}
+		require.NoError(t, err, tt.s)
+		require.Equal(t, tt.wantTable, table, tt.s)
+		require.Equal(t, tt.want, address, tt.s)
+		_, again, err := parseAddress(formatAddress(address, tt.notation, table), tt.notation, table)
+		require.NoError(t, err, "round trip")
+		require.Equal(t, address, again, "round trip")
+	}
+}
+
+func TestDataTypes(t *testing.T) {
+	tests := []struct {
+		typeName, order string
+		s               string
+		words           []uint16
+		want            interface{}
+	}{
+		{"int16", "ABCD", "-2", []uint16{0xFFFE}, int64(-2)},
+		{"uint32", "ABCD", "0x12345678", []uint16{0x1234, 0x5678}, uint64(0x12345678)},
+		{"uint32", "CDAB", "0x12345678", []uint16{0x5678, 0x1234}, uint64(0x12345678)},
+		{"uint32", "BADC", "0x12345678", []uint16{0x3412, 0x7856}, uint64(0x12345678)},
+		{"uint32", "DCBA", "0x12345678", []uint16{0x7856, 0x3412}, uint64(0x12345678)},
+		{"int64", "ABCD", "-1", []uint16{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF}, int64(-1)},
+		{"float32", "ABCD", "1.5", []uint16{0x3FC0, 0}, float32(1.5)},
+		{"float64", "CDAB", "1.5", []uint16{0, 0, 0, 0x3FF8}, 1.5},
+		{"hex", "ABCD", "beef", []uint16{0xBEEF}, "0xBEEF"},
+		{"hex", "ABCD", "0xBEEF", []uint16{0xBEEF}, "0xBEEF"},
+	}
+	for _, tt := range tests {
+		dt := dataTypes[tt.typeName]
+		_, words, err := dt.encode(tt.s)
+		require.NoError(t, err)
+		require.Equal(t, tt.words, orderWords(words, tt.order), "%v %v", tt.typeName, tt.order)
+		require.Equal(t, tt.want, dt.decode(orderWords(tt.words, tt.order)), "%v %v", tt.typeName, tt.order)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// generatorConfig configures a generator of values, by Type:
+//
+//   - constant: always Value.
+//   - ramp: from Min to Max by Step each interval, then back to Min.
+//   - sine: between Min and Max, repeating every Period.
+//   - random_walk: starts at Start, changes randomly by up to Step each
+//     interval, within Min and Max.
+//   - counter: starts at Start and increases by Step (default 1) each interval,
+//     wrapping as uint16.
+type generatorConfig struct {
+	Type   string   `json:"type"`
+	Value  float64  `json:"value"`
+	Min    float64  `json:"min"`
+	Max    float64  `json:"max"`
+	Step   float64  `json:"step"`
+	Start  float64  `json:"start"`
+	Period duration `json:"period"`
+}
+
+// generator returns the next value, given the time since the simulation started.
+type generator func(elapsed time.Duration) float64
+
+// new creates a generator, seed is used by random_walk.
+func (c *generatorConfig) new(seed int64) (generator, error) {
+	if c.Max < c.Min {
+		return nil, fmt.Errorf("generator %v: max %v is less than min %v", c.Type, c.Max, c.Min)
+	}
+	switch c.Type {
+	case "constant":
+		return func(time.Duration) float64 { return c.Value }, nil
+	case "ramp":
+		if c.Step <= 0 {
+			return nil, fmt.Errorf("generator ramp: step must be positive")
+		}
+		v := c.Min - c.Step
+		return func(time.Duration) float64 {
+			v += c.Step
+			if v > c.Max {
+				v = c.Min
+			}
+			return v
+		}, nil
+	case "sine":
+		if c.Period <= 0 {
+			return nil, fmt.Errorf("generator sine: period is required")
+		}
+		return func(elapsed time.Duration) float64 {
+			phase := 2 * math.Pi * float64(elapsed) / float64(c.Period)
+			return c.Min + (c.Max-c.Min)*(1+math.Sin(phase))/2
+		}, nil
+	case "random_walk":
+		r := rand.New(rand.NewSource(seed))
+		v := math.Min(math.Max(c.Start, c.Min), c.Max)
+		first := true
+		return func(time.Duration) float64 {
+			if first {
+				first = false
+				return v
+			}
+			v = math.Min(math.Max(v+(r.Float64()*2-1)*c.Step, c.Min), c.Max)
+			return v
+		}, nil
+	case "counter":
+		step := c.Step
+		if step == 0 {
+			step = 1
+		}
+		v := c.Start - step
+		return func(time.Duration) float64 {
+			v = math.Mod(v+step, 0x10000)
+			if v < 0 {
+				v += 0x10000
+			}
+			return v
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown generator type %q", c.Type)
+}
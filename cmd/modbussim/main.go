@@ -0,0 +1,240 @@
+// Command modbussim simulates Modbus server devices for integration tests.
+// Devices are loaded from a register map file, and served over TCP and/or a
+// serial port. Writes from clients are printed.
+//
+// The register map file is JSON, such as:
+//
+//	{
+//	  "interval": "500ms",
+//	  "devices": [
+//	    {
+//	      "slave_id": 1,
+//	      "fill": "am3",
+//	      "points": [
+//	        {"table": "holding", "address": 0, "values": [1, 2, -3]},
+//	        {"table": "coils", "address": 10, "values": [true, false]},
+//	        {"table": "input", "address": 20, "count": 2,
+//	          "generator": {"type": "sine", "min": 0, "max": 1000, "period": "10s"}}
+//	      ]
+//	    },
+//	    {"slave_id": 2, "tcp": ":5021", "size": 100}
+//	  ]
+//	}
+//
+// Tables are coils, discrete, input or holding. Generators update values
+// every interval, types are constant, ramp, sine, random_walk and counter,
+// see generatorConfig for their parameters.
+//
+// All devices share the serial port, each with its own slave ID. Modbus TCP
+// servers answer any unit ID, so each device is served on its own TCP
+// address: set by "tcp" in the file, or -tcp if only one device does not set it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/tarm/serial"
+	"github.com/xiegeo/modbusone"
+)
+
+var (
+	mapFile = flag.String("f", "", "required register map file")
+	tcpAddr = flag.String("tcp", "", "TCP address to listen on, such as :502, for the device without its own")
+
+	address  = flag.String("l", "", "serial device location, such as: /dev/ttyS0 in linux or com1 in windows")
+	baudRate = flag.Int("r", 19200, "baud rate")
+	parity   = flag.String("p", "E", "parity: N - None, E - Even, O - Odd")
+	stopBits = flag.Int("s", 1, "stop bits: 1 or 2")
+
+	seed    = flag.Int64("seed", 0, "random seed of random_walk generators, 0 for the current time")
+	verbose = flag.Bool("v", false, "prints debugging information")
+)
+
+func main() {
+	flag.Parse()
+	if *verbose {
+		modbusone.SetDebugOut(os.Stdout)
+	}
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// device is a simulated device.
+type device struct {
+	config     deviceConfig
+	handler    *modbusone.MemoryHandler
+	generators []pointGenerator
+}
+
+// pointGenerator updates the value of one address.
+type pointGenerator struct {
+	table   modbusone.Table
+	address uint16
+	next    generator
+}
+
+func newDevice(c deviceConfig, seed int64) (*device, error) {
+	d := &device{config: c, handler: modbusone.NewMemoryHandler(c.Size)}
+	if c.Fill == "am3" {
+		if err := fillAm3(d.handler, c.Size); err != nil {
+			return nil, err
+		}
+	}
+	for i, p := range c.Points {
+		t, _ := parseTable(p.Table)
+		if err := writeValues(d.handler, t, p.Address, p.Values); err != nil {
+			return nil, fmt.Errorf("device %v point %v: %w", c.SlaveID, i, err)
+		}
+		if p.Generator == nil {
+			continue
+		}
+		for j := 0; j < p.Count; j++ {
+			seed++
+			next, err := p.Generator.new(seed)
+			if err != nil {
+				return nil, err
+			}
+			d.generators = append(d.generators, pointGenerator{table: t, address: p.Address + uint16(j), next: next})
+		}
+	}
+	if err := d.update(0); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// update sets the values of generators.
+func (d *device) update(elapsed time.Duration) error {
+	for i, g := range d.generators {
+		v := g.next(elapsed)
+		if err := writeValues(d.handler, g.table, g.address, []interface{}{v}); err != nil {
+			return fmt.Errorf("device %v generator %v: %w", d.config.SlaveID, i, err)
+		}
+	}
+	return nil
+}
+
+// printWrites prints writes from clients until unsubscribed.
+func (d *device) printWrites(w io.Writer) (unsubscribe func()) {
+	printEvent := func(e modbusone.WriteEvent) {
+		if e.Table.IsBool() {
+			fmt.Fprintf(w, "slave %v write %v from %v: %v (was %v)\n", d.config.SlaveID, e.Table, e.Address, e.NewBools, e.OldBools)
+			return
+		}
+		fmt.Fprintf(w, "slave %v write %v from %v: %v (was %v)\n", d.config.SlaveID, e.Table, e.Address, e.NewRegisters, e.OldRegisters)
+	}
+	u1 := d.handler.Subscribe(modbusone.TableCoils, 0, 0xFFFF, printEvent)
+	u2 := d.handler.Subscribe(modbusone.TableHoldingRegisters, 0, 0xFFFF, printEvent)
+	return func() {
+		u1()
+		u2()
+	}
+}
+
+func run() error {
+	if *mapFile == "" {
+		return fmt.Errorf("-f register map file is required")
+	}
+	m, err := loadRegisterMap(*mapFile)
+	if err != nil {
+		return err
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	var devices []*device
+	for i, c := range m.Devices {
+		d, err := newDevice(c, *seed+int64(i)<<32)
+		if err != nil {
+			return err
+		}
+		defer d.printWrites(os.Stdout)()
+		devices = append(devices, d)
+	}
+
+	errs := make(chan error, 2*len(devices))
+	var closers []io.Closer
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
+	usedFlag := false
+	for _, d := range devices {
+		addr := d.config.TCP
+		if addr == "" {
+			if *tcpAddr == "" {
+				continue
+			}
+			if usedFlag {
+				return fmt.Errorf("-tcp can only be used by one device, set tcp in the register map file")
+			}
+			usedFlag = true
+			addr = *tcpAddr
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("slave %v listening on %v\n", d.config.SlaveID, l.Addr())
+		s := modbusone.NewTCPServer(l)
+		closers = append(closers, s)
+		go func(d *device) { errs <- s.Serve(d.handler) }(d)
+	}
+	if *address != "" {
+		port, err := openSerial()
+		if err != nil {
+			return err
+		}
+		closers = append(closers, port)
+		closers = append(closers, serveSerial(port, int64(*baudRate), devices, errs))
+		fmt.Printf("serving %v slaves on %v\n", len(devices), *address)
+	}
+	if len(closers) == 0 {
+		return fmt.Errorf("nothing to serve, set -tcp or -l")
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	start := time.Now()
+	ticker := time.NewTicker(time.Duration(m.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errs:
+			return fmt.Errorf("serve error: %w", err)
+		case <-interrupt:
+			return nil
+		case now := <-ticker.C:
+			for _, d := range devices {
+				if err := d.update(now.Sub(start)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func openSerial() (io.ReadWriteCloser, error) {
+	config := serial.Config{
+		Name:     *address,
+		Baud:     *baudRate,
+		StopBits: serial.StopBits(*stopBits),
+	}
+	if len(*parity) > 0 {
+		config.Parity = serial.Parity((*parity)[0])
+	}
+	s, err := serial.OpenPort(&config)
+	if err != nil {
+		return nil, fmt.Errorf("open serial error: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xiegeo/modbusone"
+)
+
+// registerMap is the content of a register map file.
+type registerMap struct {
+	// Interval is how often generators update, default 1s.
+	Interval duration       `json:"interval"`
+	Devices  []deviceConfig `json:"devices"`
+}
+
+// deviceConfig is a simulated device.
+type deviceConfig struct {
+	SlaveID byte `json:"slave_id"`
+	// TCP is the address to serve the device on, overriding -tcp.
+	TCP string `json:"tcp"`
+	// Size is the number of addresses of each table, default 65536.
+	Size int `json:"size"`
+	// Fill sets the initial values of all tables before Points: "" for zeros,
+	// or "am3", see fillAm3.
+	Fill   string        `json:"fill"`
+	Points []pointConfig `json:"points"`
+}
+
+// pointConfig sets values starting from an address.
+type pointConfig struct {
+	Table   string `json:"table"`
+	Address uint16 `json:"address"`
+	// Values are initial values, bools or numbers, negative numbers are int16.
+	Values []interface{} `json:"values"`
+	// Generator updates Count values (default 1) every interval.
+	Generator *generatorConfig `json:"generator"`
+	Count     int              `json:"count"`
+}
+
+// duration is a time.Duration in JSON as a string, such as "1.5s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = duration(v)
+	return err
+}
+
+// loadRegisterMap reads and validates a register map file.
+func loadRegisterMap(name string) (*registerMap, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return parseRegisterMap(b)
+}
+
+func parseRegisterMap(b []byte) (*registerMap, error) {
+	var m registerMap
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("register map parse error: %w", err)
+	}
+	if m.Interval <= 0 {
+		m.Interval = duration(time.Second)
+	}
+	if len(m.Devices) == 0 {
+		return nil, fmt.Errorf("register map has no devices")
+	}
+	ids := make(map[byte]bool)
+	for i := range m.Devices {
+		d := &m.Devices[i]
+		if _, err := modbusone.Uint64ToSlaveID(uint64(d.SlaveID)); err != nil || d.SlaveID == 0 {
+			return nil, fmt.Errorf("device %v: slave_id %v is not in 1 to 247", i, d.SlaveID)
+		}
+		if ids[d.SlaveID] {
+			return nil, fmt.Errorf("device %v: slave_id %v is used more than once", i, d.SlaveID)
+		}
+		ids[d.SlaveID] = true
+		if d.Size <= 0 || d.Size > 0x10000 {
+			d.Size = 0x10000
+		}
+		if d.Fill != "" && d.Fill != "am3" {
+			return nil, fmt.Errorf("device %v: unknown fill %q", d.SlaveID, d.Fill)
+		}
+		for j := range d.Points {
+			p := &d.Points[j]
+			if _, err := parseTable(p.Table); err != nil {
+				return nil, fmt.Errorf("device %v point %v: %w", d.SlaveID, j, err)
+			}
+			if p.Count <= 0 {
+				p.Count = 1
+			}
+			if p.Generator != nil {
+				if _, err := p.Generator.new(0); err != nil {
+					return nil, fmt.Errorf("device %v point %v: %w", d.SlaveID, j, err)
+				}
+			}
+		}
+	}
+	return &m, nil
+}
+
+// parseTable parses a table name.
+func parseTable(s string) (modbusone.Table, error) {
+	switch strings.ToLower(s) {
+	case "coils":
+		return modbusone.TableCoils, nil
+	case "discrete":
+		return modbusone.TableDiscreteInputs, nil
+	case "input":
+		return modbusone.TableInputRegisters, nil
+	case "holding":
+		return modbusone.TableHoldingRegisters, nil
+	}
+	return modbusone.TableNone, fmt.Errorf("unknown table %q, use coils, discrete, input or holding", s)
+}
+
+// toBool converts a value in the register map to a bool.
+func toBool(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case float64:
+		return v != 0, nil
+	}
+	return false, fmt.Errorf("value %v is not a bool or number", v)
+}
+
+// toRegister converts a value in the register map to a register, negative
+// numbers are stored as int16.
+func toRegister(v interface{}) (uint16, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("value %v is not a number", v)
+	}
+	f = math.Round(f)
+	if f < math.MinInt16 || f > math.MaxUint16 {
+		return 0, fmt.Errorf("value %v is out of range", v)
+	}
+	return uint16(int32(f)), nil
+}
+
+// writeValues writes values of table t to h, starting from address.
+func writeValues(h *modbusone.MemoryHandler, t modbusone.Table, address uint16, values []interface{}) error {
+	if t.IsBool() {
+		bs := make([]bool, len(values))
+		for i, v := range values {
+			var err error
+			if bs[i], err = toBool(v); err != nil {
+				return err
+			}
+		}
+		return h.WriteBools(t, address, bs)
+	}
+	rs := make([]uint16, len(values))
+	for i, v := range values {
+		var err error
+		if rs[i], err = toRegister(v); err != nil {
+			return err
+		}
+	}
+	return h.WriteRegisters(t, address, rs)
+}
+
+// fillAm3 fills h with the am3 pattern: discrete inputs are true every third
+// address, and coils inversely, input registers are three times the address,
+// and holding registers are 0xFFFF minus the address.
+func fillAm3(h *modbusone.MemoryHandler, size int) error {
+	discreteInputs := make([]bool, size)
+	coils := make([]bool, size)
+	inputRegisters := make([]uint16, size)
+	holdingRegisters := make([]uint16, size)
+	for i := 0; i < size; i++ {
+		discreteInputs[i] = i%3 == 0
+		coils[i] = i%3 != 0
+		inputRegisters[i] = uint16(i * 3)
+		holdingRegisters[i] = uint16(0xFFFF - i)
+	}
+	if err := h.WriteBools(modbusone.TableDiscreteInputs, 0, discreteInputs); err != nil {
+		return err
+	}
+	if err := h.WriteBools(modbusone.TableCoils, 0, coils); err != nil {
+		return err
+	}
+	if err := h.WriteRegisters(modbusone.TableInputRegisters, 0, inputRegisters); err != nil {
+		return err
+	}
+	return h.WriteRegisters(modbusone.TableHoldingRegisters, 0, holdingRegisters)
+}
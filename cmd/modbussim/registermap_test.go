@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xiegeo/modbusone"
+)
+
+func TestRegisterMap(t *testing.T) {
+	b, err := os.ReadFile("example.json")
+	require.NoError(t, err)
+	m, err := parseRegisterMap(b)
+	require.NoError(t, err)
+	require.Equal(t, duration(time.Second/2), m.Interval)
+	require.Len(t, m.Devices, 2)
+	require.Equal(t, 0x10000, m.Devices[0].Size, "default size")
+
+	d, err := newDevice(m.Devices[0], 1)
+	require.NoError(t, err)
+	regs, err := d.handler.ReadRegisters(modbusone.TableHoldingRegisters, 0, 4)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{1, 2, 0xFFFD, 0xFFFF - 3}, regs, "points over am3")
+	regs, err = d.handler.ReadRegisters(modbusone.TableInputRegisters, 20, 3)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{500, 500, 66}, regs, "generators start at time 0")
+	regs, err = d.handler.ReadRegisters(modbusone.TableInputRegisters, 30, 3)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0, 50, 0}, regs)
+
+	require.NoError(t, d.update(time.Second*10/4))
+	regs, err = d.handler.ReadRegisters(modbusone.TableInputRegisters, 20, 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{1000}, regs, "sine at max after a quarter period")
+	regs, err = d.handler.ReadRegisters(modbusone.TableInputRegisters, 30, 3)
+	require.NoError(t, err)
+	require.Equal(t, uint16(10), regs[0], "ramp")
+	require.InDelta(t, 50, int(regs[1]), 5, "random walk")
+	require.Equal(t, uint16(1), regs[2], "counter")
+
+	for _, bad := range []string{
+		`{}`,
+		`{"devices": [{"slave_id": 0}]}`,
+		`{"devices": [{"slave_id": 1}, {"slave_id": 1}]}`,
+		`{"devices": [{"slave_id": 1, "fill": "x"}]}`,
+		`{"devices": [{"slave_id": 1, "points": [{"table": "x"}]}]}`,
+		`{"devices": [{"slave_id": 1, "points": [{"table": "input", "generator": {"type": "ramp"}}]}]}`,
+	} {
+		_, err := parseRegisterMap([]byte(bad))
+		require.Error(t, err, bad)
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	next := func(c generatorConfig) generator {
+		g, err := c.new(1)
+		require.NoError(t, err)
+		return g
+	}
+	ramp := next(generatorConfig{Type: "ramp", Min: 1, Max: 3, Step: 1})
+	var got []float64
+	for i := 0; i < 4; i++ {
+		got = append(got, ramp(0))
+	}
+	require.Equal(t, []float64{1, 2, 3, 1}, got)
+
+	counter := next(generatorConfig{Type: "counter", Start: 0xFFFF})
+	require.Equal(t, float64(0xFFFF), counter(0))
+	require.Equal(t, float64(0), counter(0), "wraps as uint16")
+
+	walk := next(generatorConfig{Type: "random_walk", Min: 0, Max: 1, Step: 10})
+	for i := 0; i < 10; i++ {
+		v := walk(0)
+		require.True(t, v >= 0 && v <= 1, v)
+	}
+}
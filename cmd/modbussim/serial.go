@@ -0,0 +1,21 @@
+package main
+
+import (
+	"io"
+
+	"github.com/xiegeo/modbusone"
+)
+
+// serveSerial serves devices with their slave IDs on port, and sends errors
+// from serving to errs.
+func serveSerial(port io.ReadWriteCloser, baudRate int64, devices []*device, errs chan<- error) *modbusone.RTUServer {
+	handler := modbusone.MultiIDHandler{}
+	var slaveIDs []byte
+	for _, d := range devices {
+		handler[d.config.SlaveID] = d.handler
+		slaveIDs = append(slaveIDs, d.config.SlaveID)
+	}
+	s := modbusone.NewMultiIDRTUServer(modbusone.NewSerialContext(port, baudRate), slaveIDs...)
+	go func() { errs <- s.ServeRTU(handler) }()
+	return s
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xiegeo/modbusone"
+)
+
+// pipePort connects a reader and a writer of different pipes.
+type pipePort struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func TestServeSerial(t *testing.T) {
+	r1, w1 := io.Pipe() // client to servers
+	r2, w2 := io.Pipe() // servers to client
+	var devices []*device
+	for id := byte(1); id <= 2; id++ {
+		d, err := newDevice(deviceConfig{SlaveID: id, Size: 10, Points: []pointConfig{
+			{Table: "holding", Address: 0, Values: []interface{}{float64(id)}},
+		}}, 0)
+		require.NoError(t, err)
+		devices = append(devices, d)
+	}
+	errs := make(chan error, 2)
+	server := serveSerial(pipePort{Reader: r1, WriteCloser: w2}, 19200, devices, errs)
+	defer server.Close()
+
+	client := modbusone.NewRTUClient(modbusone.NewSerialContext(pipePort{Reader: r2, WriteCloser: w1}, 19200), 1)
+	defer client.Close()
+	h := modbusone.NewMemoryHandler(10)
+	go client.ServeRTU(modbusone.MultiIDHandler{1: h, 2: h})
+	for id := byte(1); id <= 2; id++ {
+		req, err := modbusone.FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+		require.NoError(t, err)
+		require.NoError(t, modbusone.DoRTUTransaction(client, modbusone.RTUHeader{SlaveID: id, PDU: req}))
+		regs, err := h.ReadRegisters(modbusone.TableHoldingRegisters, 0, 1)
+		require.NoError(t, err)
+		require.Equal(t, []uint16{uint16(id)}, regs)
+	}
+}
@@ -0,0 +1,88 @@
+package modbusone
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CoalesceOption configures MakeCoalescedRequestHeaders.
+type CoalesceOption struct {
+	_ struct{} // enforces keyed literals
+
+	// MaxPerPacket limits the values per request, 0 for FunctionCode.MaxPerPacket.
+	// Use FunctionCode.MaxPerPacketSized for constrained devices.
+	MaxPerPacket uint16
+	// MaxGap is the largest number of unwanted values to read through, in
+	// order to merge two ranges into one request.
+	MaxGap uint16
+	// Forbidden addresses are never read, such as addresses known to return errors.
+	Forbidden []uint16
+}
+
+// PointLocation is the place of a value in the replies of coalesced requests.
+type PointLocation struct {
+	Request int    // index of the request PDU, including appended to requests
+	Offset  uint16 // number of values before it in the request
+}
+
+// MakeCoalescedRequestHeaders generates the fewest read request headers that
+// cover all points (addresses), by merging points that are adjacent or within
+// option.MaxGap of each other, up to option.MaxPerPacket values per request.
+// Gaps are never read through option.Forbidden addresses.
+//
+// The returned map gives the location of each point in the replies.
+// Returns an error if fc is not a read or a point is forbidden.
+func MakeCoalescedRequestHeaders(fc FunctionCode, points []uint16, option CoalesceOption, appendTO []PDU) ([]PDU, map[uint16]PointLocation, error) {
+	if !fc.IsReadToServer() || fc.MaxPerPacket() == 0 {
+		return nil, nil, fmt.Errorf("%w function %v is not a supported read", EcIllegalFunction, fc)
+	}
+	maxPerPacket := option.MaxPerPacket
+	if maxPerPacket == 0 || maxPerPacket > fc.MaxPerPacket() {
+		maxPerPacket = fc.MaxPerPacket()
+	}
+	forbidden := append([]uint16(nil), option.Forbidden...)
+	sort.Slice(forbidden, func(i, j int) bool { return forbidden[i] < forbidden[j] })
+	// hasForbidden returns true if any address from a to b inclusive is forbidden.
+	hasForbidden := func(a, b uint16) bool {
+		i := sort.Search(len(forbidden), func(i int) bool { return forbidden[i] >= a })
+		return i < len(forbidden) && forbidden[i] <= b
+	}
+
+	sorted := append([]uint16(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	locations := make(map[uint16]PointLocation, len(sorted))
+	var start, last uint16
+	open := false
+	flush := func() error {
+		var err error
+		appendTO, err = MakePDURequestHeadersSized(fc, start, last-start+1, maxPerPacket, appendTO)
+		return err
+	}
+	for i, p := range sorted {
+		if i > 0 && p == sorted[i-1] {
+			continue
+		}
+		if hasForbidden(p, p) {
+			return nil, nil, fmt.Errorf("%w point %v is forbidden", EcIllegalDataAddress, p)
+		}
+		if open && (uint32(p)-uint32(start) >= uint32(maxPerPacket) ||
+			p-last-1 > option.MaxGap || hasForbidden(last, p)) {
+			if err := flush(); err != nil {
+				return nil, nil, err
+			}
+			open = false
+		}
+		if !open {
+			start, open = p, true
+		}
+		last = p
+		locations[p] = PointLocation{Request: len(appendTO), Offset: p - start}
+	}
+	if open {
+		if err := flush(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return appendTO, locations, nil
+}
@@ -0,0 +1,97 @@
+package modbusone_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestMakeCoalescedRequestHeaders(t *testing.T) {
+	header := func(fc FunctionCode, address, quantity uint16) PDU {
+		p, err := fc.MakeRequestHeader(address, quantity)
+		require.NoError(t, err)
+		return p
+	}
+	tests := []struct {
+		name      string
+		fc        FunctionCode
+		points    []uint16
+		option    CoalesceOption
+		want      []PDU
+		locations map[uint16]PointLocation
+	}{
+		{
+			name:   "adjacent",
+			fc:     FcReadHoldingRegisters,
+			points: []uint16{3, 1, 2, 2},
+			want:   []PDU{header(FcReadHoldingRegisters, 1, 3)},
+			locations: map[uint16]PointLocation{
+				1: {Request: 0, Offset: 0}, 2: {Request: 0, Offset: 1}, 3: {Request: 0, Offset: 2},
+			},
+		},
+		{
+			name:   "gaps",
+			fc:     FcReadInputRegisters,
+			points: []uint16{0, 5, 20},
+			option: CoalesceOption{MaxGap: 4},
+			want:   []PDU{header(FcReadInputRegisters, 0, 6), header(FcReadInputRegisters, 20, 1)},
+			locations: map[uint16]PointLocation{
+				0: {Request: 0, Offset: 0}, 5: {Request: 0, Offset: 5}, 20: {Request: 1, Offset: 0},
+			},
+		},
+		{
+			name:   "forbidden",
+			fc:     FcReadHoldingRegisters,
+			points: []uint16{0, 5},
+			option: CoalesceOption{MaxGap: 10, Forbidden: []uint16{3}},
+			want:   []PDU{header(FcReadHoldingRegisters, 0, 1), header(FcReadHoldingRegisters, 5, 1)},
+			locations: map[uint16]PointLocation{
+				0: {Request: 0, Offset: 0}, 5: {Request: 1, Offset: 0},
+			},
+		},
+		{
+			name:   "max per packet",
+			fc:     FcReadCoils,
+			points: []uint16{0, 7, 8, 15},
+			option: CoalesceOption{MaxPerPacket: 8, MaxGap: 7},
+			want:   []PDU{header(FcReadCoils, 0, 8), header(FcReadCoils, 8, 8)},
+			locations: map[uint16]PointLocation{
+				0: {Request: 0, Offset: 0}, 7: {Request: 0, Offset: 7},
+				8: {Request: 1, Offset: 0}, 15: {Request: 1, Offset: 7},
+			},
+		},
+		{
+			name:   "sized for constrained devices",
+			fc:     FcReadHoldingRegisters,
+			points: []uint16{0, 10},
+			option: CoalesceOption{MaxPerPacket: FcReadHoldingRegisters.MaxPerPacketSized(12), MaxGap: 20},
+			want:   []PDU{header(FcReadHoldingRegisters, 0, 1), header(FcReadHoldingRegisters, 10, 1)},
+			locations: map[uint16]PointLocation{
+				0: {Request: 0, Offset: 0}, 10: {Request: 1, Offset: 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, locations, err := MakeCoalescedRequestHeaders(tt.fc, tt.points, tt.option, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.locations, locations)
+		})
+	}
+
+	t.Run("append", func(t *testing.T) {
+		first := header(FcReadCoils, 100, 1)
+		got, locations, err := MakeCoalescedRequestHeaders(FcReadCoils, []uint16{1}, CoalesceOption{}, []PDU{first})
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		require.Equal(t, PointLocation{Request: 1, Offset: 0}, locations[1])
+	})
+	t.Run("errors", func(t *testing.T) {
+		_, _, err := MakeCoalescedRequestHeaders(FcWriteMultipleRegisters, []uint16{1}, CoalesceOption{}, nil)
+		require.Error(t, err)
+		_, _, err = MakeCoalescedRequestHeaders(FcReadCoils, []uint16{1}, CoalesceOption{Forbidden: []uint16{1}}, nil)
+		require.Error(t, err)
+	})
+}
@@ -7,6 +7,14 @@ import (
 
 // DataToBools translates the data part of PDU to []bool dependent on FunctionCode.
 func DataToBools(data []byte, count uint16, fc FunctionCode) ([]bool, error) {
+	if fc == FcWriteSingleCoil {
+		count = 1
+	}
+	return AppendDataToBools(make([]bool, 0, count), data, count, fc)
+}
+
+// AppendDataToBools is DataToBools, with the values appended to dst.
+func AppendDataToBools(dst []bool, data []byte, count uint16, fc FunctionCode) ([]bool, error) {
 	if fc == FcWriteSingleCoil {
 		if len(data) != 2 {
 			debugf("WriteSingleCoil need 2 bytes data\n")
@@ -17,10 +25,10 @@ func DataToBools(data []byte, count uint16, fc FunctionCode) ([]bool, error) {
 			return nil, EcIllegalDataValue
 		}
 		if data[0] == 0 {
-			return []bool{false}, nil
+			return append(dst, false), nil
 		}
 		if data[0] == 0xff {
-			return []bool{true}, nil
+			return append(dst, true), nil
 		}
 		debugf("WriteSingleCoil unexpected %v %v", data[0], data[1])
 		return nil, EcIllegalDataValue
@@ -31,77 +39,72 @@ func DataToBools(data []byte, count uint16, fc FunctionCode) ([]bool, error) {
 		debugf("unexpected size: bools %v, bytes %v", count, byteCount)
 		return nil, EcIllegalDataValue
 	}
-	r := make([]bool, byteCount*8)
-	for i := 0; i < byteCount; i++ {
-		for j := 0; j < 8; j++ {
-			r[i*8+j] = bool((int(data[i]) & (1 << uint(j))) > 0)
-		}
+	for i := 0; i < int(count); i++ {
+		dst = append(dst, data[i/8]&(1<<uint(i%8)) != 0)
 	}
-	return r[:count], nil
+	return dst, nil
 }
 
 // BoolsToData translates []bool to the data part of PDU dependent on FunctionCode.
 func BoolsToData(values []bool, fc FunctionCode) ([]byte, error) {
+	n := (len(values) + 7) / 8
+	if fc == FcWriteSingleCoil {
+		n = 2
+	}
+	return AppendBoolsToData(make([]byte, 0, n), values, fc)
+}
+
+// AppendBoolsToData is BoolsToData, with the data appended to dst.
+func AppendBoolsToData(dst []byte, values []bool, fc FunctionCode) ([]byte, error) {
 	if fc == FcWriteSingleCoil {
 		if len(values) != 1 {
 			return nil, fmt.Errorf("FcWriteSingleCoil can not write %v coils", len(values))
 		}
 		if values[0] {
-			return []byte{0xff, 0x00}, nil
+			return append(dst, 0xff, 0x00), nil
 		}
-		return []byte{0x00, 0x00}, nil
+		return append(dst, 0x00, 0x00), nil
 	}
 
-	count := len(values)
-	byteCount := (count + 7) / 8
-	data := make([]byte, byteCount)
-
-	byteNr := 0
-	bitNr := uint8(0)
-	byteVal := uint8(0)
-
-	for v := 0; v < count; v++ {
-		if values[v] {
-			// set bit in byte to true
-			byteVal |= 1 << bitNr
+	var byteVal byte
+	for i, v := range values {
+		if v {
+			byteVal |= 1 << uint(i%8)
 		}
-		switch {
-		case bitNr == 7:
-			// last bit in byte, set data value and go to the next byte
-			data[byteNr] = byteVal
+		if i%8 == 7 || i == len(values)-1 {
+			dst = append(dst, byteVal)
 			byteVal = 0
-			bitNr = 0
-			byteNr++
-		case v+1 == count:
-			// last bit, set byte value and exit loop
-			data[byteNr] = byteVal
-		default:
-			bitNr++
 		}
 	}
-
-	return data, nil
+	return dst, nil
 }
 
 // DataToRegisters translates the data part of PDU to []uint16.
 func DataToRegisters(data []byte) ([]uint16, error) {
+	return AppendDataToRegisters(make([]uint16, 0, len(data)/2), data)
+}
+
+// AppendDataToRegisters is DataToRegisters, with the values appended to dst.
+func AppendDataToRegisters(dst []uint16, data []byte) ([]uint16, error) {
 	if len(data) < 2 || len(data)%2 != 0 {
 		debugf("unexpected odd number of bytes %v", len(data))
 		return nil, EcIllegalDataValue
 	}
-	count := len(data) / 2
-	values := make([]uint16, count)
-	for i := range values {
-		values[i] = binary.BigEndian.Uint16(data[2*i:])
+	for i := 0; i < len(data); i += 2 {
+		dst = append(dst, binary.BigEndian.Uint16(data[i:]))
 	}
-	return values, nil
+	return dst, nil
 }
 
 // RegistersToData translates []uint16 to the data part of PDU.
 func RegistersToData(values []uint16) ([]byte, error) {
-	data := make([]byte, 2*len(values))
-	for i, v := range values {
-		binary.BigEndian.PutUint16(data[i*2:], v)
+	return AppendRegistersToData(make([]byte, 0, 2*len(values)), values), nil
+}
+
+// AppendRegistersToData is RegistersToData, with the data appended to dst.
+func AppendRegistersToData(dst []byte, values []uint16) []byte {
+	for _, v := range values {
+		dst = binary.BigEndian.AppendUint16(dst, v)
 	}
-	return data, nil
+	return dst
 }
@@ -21,8 +21,6 @@ var (
 
 	isClient = flag.Bool("c", false, "true for client, false (default) for server. The client is interactive.")
 	slaveID  = flag.Uint64("id", 1, "the slaveId of the server for serial communication, 0 for multicast only")
-	fillData = flag.String("d", "am3", "data to start with, am3 starts memory "+
-		"with bools as address (mod 3) == 0, and registers as address * 3 (mod uint16)")
 
 	writeSizeLimit = flag.Int("wsl", modbusone.MaxRTUSize, "client only, the max size in bytes of a write to server to send")
 	readSizeLimit  = flag.Int("rsl", modbusone.MaxRTUSize, "client only, the max size in bytes of a read from server to request")
@@ -32,8 +30,8 @@ var (
 
 // main configures the Modbus RTU serial connection and runs the program as a
 // client or server over the selected slave ID. It serves requests using a
-// memory space, and optionally pre-fills that memory with none-zero data, or
-// prints serial statistics on shutdown or interrupt.
+// memory space, and prints serial statistics on shutdown or interrupt.
+// To serve pre-filled or changing data, use the cmd/modbussim simulator.
 func main() {
 	flag.Parse()
 	if *verbose {
@@ -72,9 +70,6 @@ func main() {
 		fmt.Fprintf(os.Stderr, "set slaveID error: %v\n", err)
 		os.Exit(1)
 	}
-	if *fillData == "am3" {
-		fillAm3()
-	}
 	var device modbusone.ServerCloser
 	if *isClient {
 		if *writeSizeLimit > modbusone.MaxRTUSize || *readSizeLimit > modbusone.MaxRTUSize {
@@ -168,22 +163,3 @@ var (
 	// Memory: 65536 * 2 bytes ≈ 128 KB
 	holdingRegisters [size]uint16
 )
-
-// fillAm3 initializes the in-memory Modbus data with deterministic sample values.
-// It sets discrete inputs to true every third address, and coils to true inversely,
-// input registers to three times the address, and holding registers to 0xFFFF minus
-// the address.
-func fillAm3() {
-	for i := range discreteInputs {
-		discreteInputs[i] = i%3 == 0
-	}
-	for i := range coils {
-		coils[i] = i%3 != 0
-	}
-	for i := range inputRegisters {
-		inputRegisters[i] = uint16(i * 3)
-	}
-	for i := range holdingRegisters {
-		holdingRegisters[i] = uint16(0xFFFF - i)
-	}
-}
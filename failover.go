@@ -50,6 +50,8 @@ type FailoverSerialConn struct {
 	// default 2 for primary, 4 for failover
 	MissesMax int32
 	misses    int32
+
+	logger instanceLogger
 }
 
 // FailoverSerialConn should implement SerialContextV3
@@ -113,13 +115,13 @@ func (s *FailoverSerialConn) serverRead(b []byte) (int, error) {
 		if !s.isFailover {
 			if !s.isActive {
 				if s.startTime.Add(s.PrimaryForceBackDelay).Before(time.Now()) {
-					debugf("force active of primary/n")
+					s.logger.log(levelInfo, "FailoverSerialConn force active of primary")
 					s.isActive = true
 				}
 			}
 			if s.isActive {
 				if s.lastRead.Add(s.PrimaryDisconnectDelay).Before(time.Now()) {
-					debugf("primary was disconnected for too long/n")
+					s.logger.log(levelInfo, "FailoverSerialConn primary was disconnected for too long")
 					s.isActive = false
 					s.startTime = time.Now()
 				} else {
@@ -131,9 +133,9 @@ func (s *FailoverSerialConn) serverRead(b []byte) (int, error) {
 		rtu := RTU(b[:n])
 		pdu, err := rtu.GetPDU()
 		if err != nil {
-			debugf("failover serverRead internal GetPDU error : %v", err)
+			s.logger.logErr(levelWarn, "FailoverSerialConn serverRead internal GetPDU error", err, "bytes", hexBytes(rtu))
 			s.misses = 0
-			debugf("reset misses\n")
+			s.logger.debug("FailoverSerialConn reset misses")
 			continue // throw away and read again
 		}
 		if rtu[0] == 0 {
@@ -155,8 +157,8 @@ func (s *FailoverSerialConn) serverRead(b []byte) (int, error) {
 			s.isActive = false
 			s.misses = 0
 			s.resetRequestTime()
-			debugf("primary found, going from active to passive\n")
-			debugf("reset misses\n")
+			s.logger.log(levelInfo, "FailoverSerialConn primary found, going from active to passive")
+			s.logger.debug("FailoverSerialConn reset misses")
 			continue // throw away and read again
 		} else {
 			// we are passive here
@@ -167,7 +169,7 @@ func (s *FailoverSerialConn) serverRead(b []byte) (int, error) {
 			}
 			incMisses := func() {
 				s.misses++
-				debugf("%v misses\n", s.misses)
+				s.logger.debug("FailoverSerialConn miss", "misses", s.misses)
 				if s.misses > s.MissesMax {
 					s.isActive = true
 				} else {
@@ -179,14 +181,14 @@ func (s *FailoverSerialConn) serverRead(b []byte) (int, error) {
 				return n, nil
 			}
 
-			if IsRequestReply(s.reqPacket.Bytes(), pdu) {
+			if defaultOverSize().isRequestReply(&s.logger, s.reqPacket.Bytes(), pdu) {
 				s.resetRequestTime()
-				debugf("ignore read of reply from the other server")
+				s.logger.debug("FailoverSerialConn ignore read of reply from the other server")
 				s.misses = 0
-				debugf("reset misses (server passive read)\n")
+				s.logger.debug("FailoverSerialConn reset misses (server passive read)")
 				continue
 			}
-			debugf("switch around request and reply pairs")
+			s.logger.debug("FailoverSerialConn switch around request and reply pairs")
 			s.setLastReqTime(pdu, now)
 			incMisses()
 			return n, nil
@@ -240,22 +242,22 @@ func (s *FailoverSerialConn) clientRead(b []byte) (int, error) {
 	s.lock.Lock()
 	defer func() {
 		s.misses = 0
-		debugf("reset misses (client read)\n")
+		s.logger.debug("FailoverSerialConn reset misses (client read)")
 		s.lock.Unlock()
 	}()
 
 	if err != nil {
-		debugf("failover clientRead internal GetPDU error : %v", err)
+		s.logger.logErr(levelWarn, "FailoverSerialConn clientRead internal GetPDU error", err, "bytes", hexBytes(rtu))
 		return n, err // bubbles formate up errors
 	}
 
-	isReply := now.Sub(s.requestTime) < s.MissDelay+s.BytesDelay(n) && IsRequestReply(s.reqPacket.Bytes(), pdu)
+	isReply := now.Sub(s.requestTime) < s.MissDelay+s.BytesDelay(n) && defaultOverSize().isRequestReply(&s.logger, s.reqPacket.Bytes(), pdu)
 
 	if !isReply {
-		debugf("got request from other client")
+		s.logger.debug("FailoverSerialConn got request from other client", "slave_id", rtu[0], "fc", pdu.GetFunctionCode())
 		s.setLastReqTime(pdu, now)
 		if s.isFailover && s.isActive {
-			debugf("deactivates failover client")
+			s.logger.log(levelInfo, "FailoverSerialConn deactivates failover client")
 			s.isActive = false
 		}
 		return n, nil // give requests so caller can match with replies
@@ -278,7 +280,7 @@ func (s *FailoverSerialConn) Read(b []byte) (int, error) {
 }
 
 func (s *FailoverSerialConn) Write(b []byte) (int, error) {
-	debugf("start write %v\n", s.describe())
+	s.logger.debug("FailoverSerialConn start write", "state", s.describe())
 	s.lock.Lock()
 	locked := true
 	defer func() {
@@ -291,12 +293,12 @@ func (s *FailoverSerialConn) Write(b []byte) (int, error) {
 		if !s.isFailover {
 			if s.isActive {
 				if s.lastRead.Add(s.PrimaryDisconnectDelay).Before(now) {
-					debugf("primary was disconnected for too long for write to be safe\n")
+					s.logger.log(levelInfo, "FailoverSerialConn primary was disconnected for too long for write to be safe")
 					s.isActive = false
 				}
 			}
 			if !s.isActive && s.startTime.Add(s.PrimaryForceBackDelay).Before(now) {
-				debugf("active server after PrimaryForceBackDelay passed\n")
+				s.logger.log(levelInfo, "FailoverSerialConn active server after PrimaryForceBackDelay passed")
 				s.isActive = true
 				s.startTime = now // push back the next force back
 			}
@@ -304,11 +306,11 @@ func (s *FailoverSerialConn) Write(b []byte) (int, error) {
 
 		if !s.isActive {
 			if s.misses >= s.MissesMax {
-				debugf("activities client with %v misses\n", s.misses)
+				s.logger.log(levelInfo, "FailoverSerialConn activates client", "misses", s.misses)
 				s.isActive = true
 			} else {
 				s.misses++
-				debugf("%v misses\n", s.misses)
+				s.logger.debug("FailoverSerialConn miss", "misses", s.misses)
 			}
 		}
 
@@ -336,7 +338,7 @@ func (s *FailoverSerialConn) Write(b []byte) (int, error) {
 		return s.SerialContext.Write(b)
 	}
 endActive:
-	debugf("FailoverSerialConn ignore Write:%x\n", b)
+	s.logger.debug("FailoverSerialConn ignore write", "bytes", hexBytes(b))
 	return len(b), nil
 }
 
@@ -353,23 +355,29 @@ func (s *FailoverSerialConn) setLastReqTime(pdu PDU, now time.Time) {
 
 // IsRequestReply test if PDUs are a request reply pair, useful for listening to transactions passively.
 func IsRequestReply(r, a PDU) bool {
-	match := func() bool {
+	return defaultOverSize().isRequestReply(&debugLogger, r, a)
+}
+
+// isRequestReply is IsRequestReply, where over sized replies are sized by the
+// request if o.Support, and mismatches are logged to l.
+func (o OverSize) isRequestReply(l *instanceLogger, r, a PDU) bool {
+	match, reason := func() (bool, []interface{}) {
 		if r.GetFunctionCode() != a.GetFunctionCode() {
-			debugf("diff fc\n")
-			return false
+			return false, []interface{}{"reason", "diff fc"}
 		}
-		if GetPDUSizeFromHeader(r, false) != len(r) {
-			debugf("r size not req %v, %x\n", GetPDUSizeFromHeader(r, true), r)
-			return false
+		if n := o.pduSizeFromHeader(r, false); n != len(r) {
+			return false, []interface{}{"reason", "request size", "expected", n}
 		}
-		if GetPDUSizeFromHeader(a, true) != len(a) {
-			debugf("a size not rep %v, %x\n", GetPDUSizeFromHeader(a, false), a)
-			return false
+		replySize := o.pduSizeFromHeader(a, true)
+		if n := overSizedReplySize(r); n > 0 && o.Support {
+			replySize = n
+		}
+		if replySize != len(a) {
+			return false, []interface{}{"reason", "reply size", "expected", replySize}
 		}
 		c, err := r.GetRequestCount()
 		if err != nil {
-			debugf("GetRequestCount error %v\n", err)
-			return false
+			return false, []interface{}{"reason", "request count", "error", err}
 		}
 		eq := false
 		switch r.GetFunctionCode() {
@@ -382,10 +390,12 @@ func IsRequestReply(r, a PDU) bool {
 			eq = bytes.Equal(r[:5], a[:5])
 		}
 		if !eq {
-			debugf("header mismatch\n")
+			return false, []interface{}{"reason", "header mismatch"}
 		}
-		return eq
+		return true, nil
 	}()
-	debugf("IsRequestReply %x %x %v\n", r, a, match)
+	if l.enabled() {
+		l.debug("IsRequestReply", append([]interface{}{"request", hexBytes(r), "reply", hexBytes(a), "match", match}, reason...)...)
+	}
 	return match
 }
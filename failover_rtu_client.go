@@ -12,11 +12,11 @@ import (
 // FailoverRTUClient implements Client/Master side logic for RTU over a SerialContext to
 // be used by a ProtocolHandler with failover function.
 type FailoverRTUClient struct {
-	com                  *FailoverSerialConn
-	packetReader         PacketReader
-	SlaveID              byte
-	serverProcessingTime time.Duration
-	actions              chan rtuAction
+	com          *FailoverSerialConn
+	packetReader PacketReader
+	SlaveID      byte
+	profiles     slaveProfiles
+	actions      chan rtuAction
 }
 
 // FailoverRTUClient is also a ServerCloser.
@@ -35,27 +35,49 @@ func NewFailoverRTUClient(com SerialContext, isFailover bool, slaveID byte) *Fai
 		panic("A SerialContext was provided with conflicting settings.")
 	}
 	r := FailoverRTUClient{
-		com:                  pr,
-		packetReader:         pr,
-		SlaveID:              slaveID,
-		serverProcessingTime: time.Second,
-		actions:              make(chan rtuAction),
+		com:          pr,
+		packetReader: pr,
+		SlaveID:      slaveID,
+		profiles:     newSlaveProfiles(),
+		actions:      make(chan rtuAction),
 	}
 	return &r
 }
 
 // SetServerProcessingTime sets the time to wait for a server response, the total
 // wait time also includes the time needed for data transmission.
+// It is the default for slaves without a ServerProcessingTime in their SlaveProfile.
 func (c *FailoverRTUClient) SetServerProcessingTime(t time.Duration) {
-	c.serverProcessingTime = t
+	c.profiles.setServerProcessingTime(t)
+}
+
+// SetSlaveProfile sets the timing and size settings used for transactions with slaveID.
+func (c *FailoverRTUClient) SetSlaveProfile(slaveID byte, p SlaveProfile) {
+	c.profiles.set(slaveID, p)
+}
+
+// GetSlaveProfile returns the settings used for slaveID, with defaults filled in.
+func (c *FailoverRTUClient) GetSlaveProfile(slaveID byte) SlaveProfile {
+	return c.profiles.get(slaveID, c.com)
 }
 
 // GetTransactionTimeOut returns the total time to wait for a transaction
-// (server response) to time out, given the expected length of RTU packets.
-// This function is also used internally to calculate timeout.
+// (server response) to time out, given the expected length of RTU packets,
+// with the default SlaveID.
 func (c *FailoverRTUClient) GetTransactionTimeOut(reqLen, ansLen int) time.Duration {
-	l := reqLen + ansLen
-	return c.com.BytesDelay(l) + c.serverProcessingTime
+	return c.GetSlaveTransactionTimeOut(c.SlaveID, reqLen, ansLen)
+}
+
+// GetSlaveTransactionTimeOut is GetTransactionTimeOut for slaveID.
+// This function is also used internally to calculate timeout.
+func (c *FailoverRTUClient) GetSlaveTransactionTimeOut(slaveID byte, reqLen, ansLen int) time.Duration {
+	return c.profiles.transactionTimeOut(slaveID, c.com, reqLen, ansLen)
+}
+
+// MakePDURequestHeaders is like the MakePDURequestHeaders function, but splits
+// quantity to fit the MaxPDUSize of slaveID.
+func (c *FailoverRTUClient) MakePDURequestHeaders(slaveID byte, fc FunctionCode, address, quantity uint16, appendTO []PDU) ([]PDU, error) {
+	return c.profiles.makePDURequestHeaders(slaveID, c.com, fc, address, quantity, appendTO)
 }
 
 // Serve serves FailoverRTUClient side handlers,
@@ -154,7 +176,8 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 			act.data = MakeRTU(act.data[0], ap.MakeWriteRequest(data))
 			ap = act.data.fastGetPDU()
 		}
-		time.Sleep(c.com.MinDelay())
+		profile := c.GetSlaveProfile(act.data[0])
+		time.Sleep(profile.InterFrameDelay)
 		_, err := c.com.Write(act.data)
 		if err != nil {
 			act.errChan <- err
@@ -165,12 +188,12 @@ func (c *FailoverRTUClient) Serve(handler ProtocolHandler) error {
 		c.com.lock.Unlock()
 		if act.data[0] == 0 || !active {
 			debugf("FailoverRTUClient skip action:%v\n", act)
-			time.Sleep(c.com.BytesDelay(len(act.data)) + c.serverProcessingTime)
+			time.Sleep(c.com.BytesDelay(len(act.data)) + profile.ServerProcessingTime)
 			act.errChan <- nil // always success
 			continue           // do not wait for read on multicast or when not active
 		}
 
-		timeOutChan := time.After(c.GetTransactionTimeOut(len(act.data), MaxRTUSize))
+		timeOutChan := time.After(c.GetSlaveTransactionTimeOut(act.data[0], len(act.data), profile.MaxPDUSize+3))
 
 	READ_LOOP:
 		for {
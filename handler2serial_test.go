@@ -401,6 +401,9 @@ func FuzzHandler(f *testing.F) {
 		pdu, err := fc.MakeRequestHeader(address, quantity)
 		if err != nil { // force production of bad requests to exercise more error checking code paths
 			errorCode_ = byte(ToExceptionCode(err))
+			if fc.Valid() && (quantity == 0 || quantity > fc.MaxPerPacket()) {
+				errorCode_ = byte(EcIllegalDataValue) // servers check quantity before address
+			}
 			t.Logf("make bad request: %v, %v, %v, %v", fc, address, quantity, err)
 			header := []byte{byte(fc), byte(address >> 8), byte(address)}
 			if fc.IsSingle() {
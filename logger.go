@@ -0,0 +1,151 @@
+package modbusone
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// logLevel is the severity of a log record, using the values of slog.Level.
+type logLevel int
+
+const (
+	levelDebug logLevel = -4
+	levelInfo  logLevel = 0
+	levelWarn  logLevel = 4
+	levelError logLevel = 8
+)
+
+// logHandler handles a structured log record, args are alternating keys and
+// values, as in slog.Logger.Log.
+type logHandler func(level logLevel, msg string, args ...interface{})
+
+// instanceLogger is the logger of a client, server, or packet reader.
+// The zero value logs to the debug output set by SetDebugOut.
+type instanceLogger struct {
+	handler atomic.Pointer[logHandler]
+}
+
+// debugLogger logs to the debug output, for functions without an instance.
+var debugLogger instanceLogger
+
+// set sets the handler of l, nil to log to the debug output.
+func (l *instanceLogger) set(h logHandler) {
+	if h == nil {
+		l.handler.Store(nil)
+		return
+	}
+	l.handler.Store(&h)
+}
+
+func (l *instanceLogger) log(level logLevel, msg string, args ...interface{}) {
+	if h := l.handler.Load(); h != nil {
+		(*h)(level, msg, args...)
+		return
+	}
+	if debugWriter() == nil {
+		return
+	}
+	debugf("%s%s", msg, formatLogArgs(args))
+}
+
+// enabled returns false if nothing is logged, so that hot paths can skip
+// building args.
+func (l *instanceLogger) enabled() bool {
+	return l.handler.Load() != nil || debugWriter() != nil
+}
+
+func (l *instanceLogger) debug(msg string, args ...interface{}) {
+	l.log(levelDebug, msg, args...)
+}
+
+// logErr logs msg with err and its class added to args.
+func (l *instanceLogger) logErr(level logLevel, msg string, err error, args ...interface{}) {
+	l.log(level, msg, append(args, "error", err, "error_class", errorClass(err))...)
+}
+
+// requestLogArgs returns the log args describing a request to slaveID.
+func requestLogArgs(slaveID byte, p PDU) []interface{} {
+	args := []interface{}{"slave_id", slaveID, "fc", p.GetFunctionCode()}
+	if len(p) >= 3 {
+		args = append(args, "address", p.GetAddress())
+	}
+	if quantity, err := p.GetRequestCount(); err == nil {
+		args = append(args, "quantity", quantity)
+	}
+	return args
+}
+
+// formatLogArgs formats key value pairs for the debug output.
+func formatLogArgs(args []interface{}) string {
+	var sb strings.Builder
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&sb, " %v=%v", args[i], args[i+1])
+	}
+	return sb.String()
+}
+
+// errorClass groups errors for logging: "timeout", "crc", "exception", or "other".
+func errorClass(err error) string {
+	var ec ExceptionCode
+	switch {
+	case errors.Is(err, ErrServerTimeOut):
+		return "timeout"
+	case errors.Is(err, ErrorCrc):
+		return "crc"
+	case errors.As(err, &ec):
+		return "exception"
+	}
+	return "other"
+}
+
+// hexBytes logs as hex.
+type hexBytes []byte
+
+func (b hexBytes) String() string {
+	return hex.EncodeToString(b)
+}
+
+// MarshalText is used by slog handlers.
+func (b hexBytes) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// frameLog logs a PDU or RTU as hex for structured loggers, and with the
+// verbose description for the debug output.
+type frameLog struct {
+	b       []byte
+	isRTU   bool
+	request bool
+}
+
+// rtuLog returns the log value of a RTU, request is false for replies.
+func rtuLog(r RTU, request bool) frameLog {
+	return frameLog{b: r, isRTU: true, request: request}
+}
+
+// pduLog returns the log value of a PDU, request is false for replies.
+func pduLog(p PDU, request bool) frameLog {
+	return frameLog{b: p, request: request}
+}
+
+func (f frameLog) String() string {
+	dir := asReply
+	if f.request {
+		dir = asRequest
+	}
+	var d pduDescription
+	if f.isRTU {
+		d = describeRTU(f.b, dir)
+	} else {
+		d = describePDU(f.b, dir)
+	}
+	return hexBytes(f.b).String() + " " + d.verbose()
+}
+
+// MarshalText is used by slog handlers.
+func (f frameLog) MarshalText() ([]byte, error) {
+	return hexBytes(f.b).MarshalText()
+}
@@ -0,0 +1,91 @@
+//go:build go1.21
+
+package modbusone
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogHandler returns a logHandler that logs to l, or nil if l is nil.
+func slogHandler(l *slog.Logger) logHandler {
+	if l == nil {
+		return nil
+	}
+	return func(level logLevel, msg string, args ...interface{}) {
+		l.Log(context.Background(), slog.Level(level), msg, args...)
+	}
+}
+
+// SetPacketReaderLogger sets the structured logger of a PacketReader created by
+// this package, set to nil to log to SetDebugOut. Returns false if r is not
+// created by this package.
+//
+// Packets are logged at debug level, and dropped or malformed packets at warn
+// level.
+func SetPacketReaderLogger(r PacketReader, l *slog.Logger) bool {
+	switch pr := r.(type) {
+	case *rtuPacketReader:
+		pr.logger.set(slogHandler(l))
+		return true
+	case *FailoverSerialConn:
+		return SetPacketReaderLogger(pr.PacketReader, l)
+	}
+	return false
+}
+
+// SetLogger sets the structured logger of the client and its packet reader,
+// set to nil to log to SetDebugOut.
+//
+// Packets are logged at debug level, retries at info level, failed transactions
+// at warn level, and io errors at error level.
+func (c *RTUClient) SetLogger(l *slog.Logger) {
+	c.logger.set(slogHandler(l))
+	SetPacketReaderLogger(c.packetReader, l)
+}
+
+// SetLogger sets the structured logger of the server and its packet reader,
+// set to nil to log to SetDebugOut.
+//
+// Packets are logged at debug level, dropped packets and failed requests at
+// warn level, and io errors at error level.
+func (s *RTUServer) SetLogger(l *slog.Logger) {
+	s.logger.set(slogHandler(l))
+	SetPacketReaderLogger(s.packetReader, l)
+}
+
+// SetLogger sets the structured logger of the client, set to nil to log to
+// SetDebugOut.
+//
+// Packets are logged at debug level, exception replies at warn level, and
+// errors that end the client at error level.
+func (c *TCPClient) SetLogger(l *slog.Logger) {
+	c.logger.set(slogHandler(l))
+}
+
+// SetLogger sets the structured logger of the server, set to nil to log to
+// SetDebugOut.
+//
+// Packets and closed connections are logged at debug level, and failed requests
+// at warn level.
+func (s *TCPServer) SetLogger(l *slog.Logger) {
+	s.logger.set(slogHandler(l))
+}
+
+// SetLogger sets the structured logger of the poller, set to nil to log to
+// SetDebugOut.
+//
+// Failed requests are logged at warn level, results are reported to
+// PollGroup.OnResult as usual.
+func (p *Poller) SetLogger(l *slog.Logger) {
+	p.logger.set(slogHandler(l))
+}
+
+// SetLogger sets the structured logger of the connection and its packet reader,
+// set to nil to log to SetDebugOut.
+//
+// Changes between active and passive are logged at info level.
+func (s *FailoverSerialConn) SetLogger(l *slog.Logger) {
+	s.logger.set(slogHandler(l))
+	SetPacketReaderLogger(s.PacketReader, l)
+}
@@ -0,0 +1,124 @@
+//go:build go1.21
+
+package modbusone_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the decoded JSON log records.
+func (b *syncBuffer) records(t *testing.T) []map[string]interface{} {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var rs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		r := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &r), line)
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func TestSetLogger(t *testing.T) {
+	slaveID := byte(0x11)
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+
+	cc := newMockSerial(t, "c", r2, w1, w1)
+	sc := newMockSerial(t, "s", r1, w2, w2)
+	client := NewRTUClient(cc, slaveID)
+	defer client.Close()
+	server := NewRTUServer(sc, slaveID)
+	defer server.Close()
+
+	var clientLog, serverLog syncBuffer
+	client.SetLogger(slog.New(slog.NewJSONHandler(&clientLog, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	server.SetLogger(slog.New(slog.NewJSONHandler(&serverLog, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	h := &SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			return nil, EcIllegalDataAddress
+		},
+	}
+	go client.Serve(h)
+	go server.Serve(h)
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(100, 2)
+	require.NoError(t, err)
+	err = client.DoTransaction(read)
+	require.True(t, errors.Is(err, EcIllegalDataAddress), err)
+
+	find := func(rs []map[string]interface{}, msg string) map[string]interface{} {
+		for _, r := range rs {
+			if r["msg"] == msg {
+				return r
+			}
+		}
+		require.Fail(t, "log message not found", msg)
+		return nil
+	}
+	rs := clientLog.records(t)
+	find(rs, "RTUClient write packet")
+	r := find(rs, "RTUClient transaction failed")
+	require.Equal(t, "WARN", r["level"])
+	require.Equal(t, float64(slaveID), r["slave_id"])
+	require.Equal(t, float64(FcReadHoldingRegisters), r["fc"])
+	require.Equal(t, float64(100), r["address"])
+	require.Equal(t, float64(2), r["quantity"])
+	require.Equal(t, "exception", r["error_class"])
+
+	rs = serverLog.records(t)
+	r = find(rs, "RTUServer handler.OnOutput error")
+	require.Equal(t, "exception", r["error_class"])
+	for _, r := range rs {
+		require.NotEqual(t, "DEBUG", r["level"], "filtered by the server logger level")
+	}
+}
+
+func TestPollerSetLogger(t *testing.T) {
+	p := NewPoller(&recordingStarter{})
+	var log syncBuffer
+	p.SetLogger(slog.New(slog.NewJSONHandler(&log, nil)))
+	results := make(chan PollResult, 1)
+	_, err := p.Add(PollGroup{SlaveID: 9, Table: TableInputRegisters, Address: 5, Quantity: 1,
+		Interval: time.Second, OnResult: func(r PollResult) { results <- r }})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+	require.ErrorIs(t, (<-results).Err, ErrServerTimeOut)
+
+	rs := log.records(t)
+	require.Len(t, rs, 1)
+	r := rs[0]
+	require.Equal(t, "Poller request failed", r["msg"])
+	require.Equal(t, "WARN", r["level"])
+	require.Equal(t, float64(9), r["slave_id"])
+	require.Equal(t, float64(5), r["address"])
+	require.Equal(t, "timeout", r["error_class"])
+}
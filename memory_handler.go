@@ -0,0 +1,356 @@
+package modbusone
+
+import (
+	"fmt"
+	"sync"
+)
+
+var _ ProtocolHandler = &MemoryHandler{} // Asserts MemoryHandler implements ProtocolHandler.
+
+// MemoryHandler implements ProtocolHandler by keeping all four data tables in
+// memory. Application code reads and writes the same memory with ReadBools,
+// WriteBools, ReadRegisters and WriteRegisters, and can Subscribe to be notified
+// of values written by the other side.
+//
+// MemoryHandler is safe for concurrent use.
+type MemoryHandler struct {
+	handler SimpleHandler
+
+	lock             sync.RWMutex
+	discreteInputs   []bool
+	coils            []bool
+	inputRegisters   []uint16
+	holdingRegisters []uint16
+
+	subsLock sync.Mutex
+	subs     []*subscription
+}
+
+// NewMemoryHandler creates a MemoryHandler with size addresses in each table,
+// starting from address 0. Size is limited to 0x10000, accesses beyond size
+// returns EcIllegalDataAddress.
+func NewMemoryHandler(size int) *MemoryHandler {
+	size = min(max(size, 0), 0x10000)
+	h := &MemoryHandler{
+		discreteInputs:   make([]bool, size),
+		coils:            make([]bool, size),
+		inputRegisters:   make([]uint16, size),
+		holdingRegisters: make([]uint16, size),
+	}
+	h.handler = SimpleHandler{
+		ReadDiscreteInputs: func(address, quantity uint16) ([]bool, error) {
+			return h.ReadBools(TableDiscreteInputs, address, quantity)
+		},
+		WriteDiscreteInputs: func(address uint16, values []bool) error {
+			return h.onWriteBools(TableDiscreteInputs, address, values)
+		},
+		ReadCoils: func(address, quantity uint16) ([]bool, error) {
+			return h.ReadBools(TableCoils, address, quantity)
+		},
+		WriteCoils: func(address uint16, values []bool) error {
+			return h.onWriteBools(TableCoils, address, values)
+		},
+		ReadInputRegisters: func(address, quantity uint16) ([]uint16, error) {
+			return h.ReadRegisters(TableInputRegisters, address, quantity)
+		},
+		WriteInputRegisters: func(address uint16, values []uint16) error {
+			return h.onWriteRegisters(TableInputRegisters, address, values)
+		},
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			return h.ReadRegisters(TableHoldingRegisters, address, quantity)
+		},
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			return h.onWriteRegisters(TableHoldingRegisters, address, values)
+		},
+	}
+	return h
+}
+
+// OnRead implements ProtocolHandler.
+func (h *MemoryHandler) OnRead(req PDU) ([]byte, error) {
+	return h.handler.OnRead(req)
+}
+
+// OnWrite implements ProtocolHandler, subscribers are notified after the write.
+func (h *MemoryHandler) OnWrite(req PDU, data []byte) error {
+	return h.handler.OnWrite(req, data)
+}
+
+// OnError implements ProtocolHandler, errors are ignored.
+func (h *MemoryHandler) OnError(req PDU, errRep PDU) {}
+
+func (h *MemoryHandler) bools(t Table) ([]bool, error) {
+	switch t {
+	case TableDiscreteInputs:
+		return h.discreteInputs, nil
+	case TableCoils:
+		return h.coils, nil
+	}
+	return nil, fmt.Errorf("%v does not hold bools", t)
+}
+
+func (h *MemoryHandler) registers(t Table) ([]uint16, error) {
+	switch t {
+	case TableInputRegisters:
+		return h.inputRegisters, nil
+	case TableHoldingRegisters:
+		return h.holdingRegisters, nil
+	}
+	return nil, fmt.Errorf("%v does not hold registers", t)
+}
+
+func checkRange(size int, address uint16, quantity int) error {
+	if int(address)+quantity > size {
+		return fmt.Errorf("%w %v + %v is beyond memory size %v", EcIllegalDataAddress, address, quantity, size)
+	}
+	return nil
+}
+
+// ReadBools returns a copy of quantity values from a bool Table.
+func (h *MemoryHandler) ReadBools(t Table, address, quantity uint16) ([]bool, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	mem, err := h.bools(t)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkRange(len(mem), address, int(quantity)); err != nil {
+		return nil, err
+	}
+	return append([]bool(nil), mem[int(address):int(address)+int(quantity)]...), nil
+}
+
+// WriteBools sets values to a bool Table, subscribers are not notified.
+func (h *MemoryHandler) WriteBools(t Table, address uint16, values []bool) error {
+	_, err := h.writeBools(t, address, values)
+	return err
+}
+
+// ReadRegisters returns a copy of quantity values from a register Table.
+func (h *MemoryHandler) ReadRegisters(t Table, address, quantity uint16) ([]uint16, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	mem, err := h.registers(t)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkRange(len(mem), address, int(quantity)); err != nil {
+		return nil, err
+	}
+	return append([]uint16(nil), mem[int(address):int(address)+int(quantity)]...), nil
+}
+
+// WriteRegisters sets values to a register Table, subscribers are not notified.
+func (h *MemoryHandler) WriteRegisters(t Table, address uint16, values []uint16) error {
+	_, err := h.writeRegisters(t, address, values)
+	return err
+}
+
+// writeBools writes values and returns the values they replaced.
+func (h *MemoryHandler) writeBools(t Table, address uint16, values []bool) ([]bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	mem, err := h.bools(t)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkRange(len(mem), address, len(values)); err != nil {
+		return nil, err
+	}
+	old := append([]bool(nil), mem[int(address):int(address)+len(values)]...)
+	copy(mem[address:], values)
+	return old, nil
+}
+
+// writeRegisters writes values and returns the values they replaced.
+func (h *MemoryHandler) writeRegisters(t Table, address uint16, values []uint16) ([]uint16, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	mem, err := h.registers(t)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkRange(len(mem), address, len(values)); err != nil {
+		return nil, err
+	}
+	old := append([]uint16(nil), mem[int(address):int(address)+len(values)]...)
+	copy(mem[address:], values)
+	return old, nil
+}
+
+func (h *MemoryHandler) onWriteBools(t Table, address uint16, values []bool) error {
+	old, err := h.writeBools(t, address, values)
+	if err != nil {
+		return err
+	}
+	h.publish(WriteEvent{
+		Table:    t,
+		Address:  address,
+		OldBools: old,
+		NewBools: append([]bool(nil), values...),
+	})
+	return nil
+}
+
+func (h *MemoryHandler) onWriteRegisters(t Table, address uint16, values []uint16) error {
+	old, err := h.writeRegisters(t, address, values)
+	if err != nil {
+		return err
+	}
+	h.publish(WriteEvent{
+		Table:        t,
+		Address:      address,
+		OldRegisters: old,
+		NewRegisters: append([]uint16(nil), values...),
+	})
+	return nil
+}
+
+// WriteEvent describes values changed by an OnWrite call on a MemoryHandler.
+// Only one of the Bools or Registers pairs is set, depending on Table.
+type WriteEvent struct {
+	Table        Table
+	Address      uint16 // address of the first value
+	OldBools     []bool
+	NewBools     []bool
+	OldRegisters []uint16
+	NewRegisters []uint16
+}
+
+// Quantity returns the number of values in the event.
+func (e WriteEvent) Quantity() int {
+	if e.Table.IsBool() {
+		return len(e.NewBools)
+	}
+	return len(e.NewRegisters)
+}
+
+// clip returns the part of the event between start and end inclusive.
+func (e WriteEvent) clip(start, end uint16) (WriteEvent, bool) {
+	first := max(int(e.Address), int(start))
+	last := min(int(e.Address)+e.Quantity()-1, int(end))
+	if first > last {
+		return WriteEvent{}, false
+	}
+	from, to := first-int(e.Address), last-int(e.Address)+1
+	c := WriteEvent{Table: e.Table, Address: uint16(first)}
+	if e.Table.IsBool() {
+		c.OldBools, c.NewBools = e.OldBools[from:to], e.NewBools[from:to]
+	} else {
+		c.OldRegisters, c.NewRegisters = e.OldRegisters[from:to], e.NewRegisters[from:to]
+	}
+	return c, true
+}
+
+// subscription delivers events in order from its own goroutine, so that
+// publishing never blocks the caller of OnWrite.
+type subscription struct {
+	table      Table
+	start, end uint16
+	f          func(WriteEvent)
+
+	lock  sync.Mutex
+	queue []WriteEvent
+	ready chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (s *subscription) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ready:
+		}
+		s.lock.Lock()
+		events := s.queue
+		s.queue = nil
+		s.lock.Unlock()
+		for _, e := range events {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.f(e)
+		}
+	}
+}
+
+func (s *subscription) push(e WriteEvent) {
+	s.lock.Lock()
+	s.queue = append(s.queue, e)
+	s.lock.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default: // run is already signaled
+	}
+}
+
+func (h *MemoryHandler) publish(e WriteEvent) {
+	h.subsLock.Lock()
+	defer h.subsLock.Unlock()
+	for _, s := range h.subs {
+		if s.table != e.Table {
+			continue
+		}
+		if c, ok := e.clip(s.start, s.end); ok {
+			s.push(c)
+		}
+	}
+}
+
+// Subscribe calls f with every WriteEvent from OnWrite that overlaps the address
+// range from start to end inclusive of Table t, with the event clipped to the range.
+//
+// f is called in order on a goroutine owned by the subscription, so a slow f
+// delays only later events of the same subscription and never the server.
+// Call the returned function to unsubscribe, which also discards events that
+// are not yet delivered.
+func (h *MemoryHandler) Subscribe(t Table, start, end uint16, f func(WriteEvent)) (unsubscribe func()) {
+	s := &subscription{
+		table: t,
+		start: start,
+		end:   end,
+		f:     f,
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	h.subsLock.Lock()
+	h.subs = append(h.subs, s)
+	h.subsLock.Unlock()
+	go s.run()
+	return func() {
+		s.once.Do(func() {
+			h.subsLock.Lock()
+			for i, o := range h.subs {
+				if o == s {
+					h.subs = append(h.subs[:i:i], h.subs[i+1:]...)
+					break
+				}
+			}
+			h.subsLock.Unlock()
+			close(s.done)
+		})
+	}
+}
+
+// SubscribeChan is like Subscribe, but sends events to c instead. Pending
+// events are discarded on unsubscribe.
+func (h *MemoryHandler) SubscribeChan(t Table, start, end uint16, c chan<- WriteEvent) (unsubscribe func()) {
+	done := make(chan struct{})
+	stop := h.Subscribe(t, start, end, func(e WriteEvent) {
+		select {
+		case c <- e:
+		case <-done:
+		}
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			stop()
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package modbusone_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestMemoryHandler(t *testing.T) {
+	h := NewMemoryHandler(100)
+	require.NoError(t, h.WriteRegisters(TableHoldingRegisters, 10, []uint16{1, 2, 3}))
+
+	events := make(chan WriteEvent, 10)
+	unsubscribe := h.SubscribeChan(TableHoldingRegisters, 11, 20, events)
+	defer unsubscribe()
+	coilEvents := make(chan WriteEvent, 10)
+	defer h.SubscribeChan(TableCoils, 0, 99, coilEvents)()
+
+	// write registers 10 to 12 from the other side
+	req, err := FcWriteMultipleRegisters.MakeRequestHeader(10, 3)
+	require.NoError(t, err)
+	data, err := RegistersToData([]uint16{7, 8, 9})
+	require.NoError(t, err)
+	require.NoError(t, h.OnWrite(req, data))
+
+	select {
+	case e := <-events:
+		require.Equal(t, WriteEvent{
+			Table:        TableHoldingRegisters,
+			Address:      11,
+			OldRegisters: []uint16{2, 3},
+			NewRegisters: []uint16{8, 9},
+		}, e)
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+
+	// read back from the other side
+	req, err = FcReadHoldingRegisters.MakeRequestHeader(9, 4)
+	require.NoError(t, err)
+	data, err = h.OnRead(req)
+	require.NoError(t, err)
+	values, err := DataToRegisters(data)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0, 7, 8, 9}, values)
+
+	// write outside of the subscribed range
+	req, err = FcWriteSingleRegister.MakeRequestHeader(30, 1)
+	require.NoError(t, err)
+	require.NoError(t, h.OnWrite(req, []byte{0, 1}))
+
+	req, err = FcWriteSingleCoil.MakeRequestHeader(5, 1)
+	require.NoError(t, err)
+	require.NoError(t, h.OnWrite(req, []byte{0xff, 0}))
+	select {
+	case e := <-coilEvents:
+		require.Equal(t, []bool{false}, e.OldBools)
+		require.Equal(t, []bool{true}, e.NewBools)
+	case <-time.After(time.Second):
+		t.Fatal("no coil event received")
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %+v", e)
+	default:
+	}
+
+	// application writes are not notified
+	require.NoError(t, h.WriteRegisters(TableHoldingRegisters, 12, []uint16{5}))
+	// out of range
+	req, err = FcReadInputRegisters.MakeRequestHeader(99, 2)
+	require.NoError(t, err)
+	_, err = h.OnRead(req)
+	require.True(t, errors.Is(err, EcIllegalDataAddress), err)
+	_, err = h.ReadRegisters(TableCoils, 0, 1)
+	require.Error(t, err)
+
+	// no events after unsubscribe
+	unsubscribe()
+	req, err = FcWriteSingleRegister.MakeRequestHeader(12, 1)
+	require.NoError(t, err)
+	require.NoError(t, h.OnWrite(req, []byte{0, 1}))
+	time.Sleep(time.Second / 20)
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event after unsubscribe %+v", e)
+	default:
+	}
+}
+
+func TestMemoryHandlerSlowSubscriber(t *testing.T) {
+	h := NewMemoryHandler(10)
+	block := make(chan struct{})
+	got := make(chan uint16, 10)
+	defer h.Subscribe(TableHoldingRegisters, 0, 9, func(e WriteEvent) {
+		<-block
+		got <- e.NewRegisters[0]
+	})()
+
+	// OnWrite returns while the subscriber is blocked.
+	for i := uint16(0); i < 5; i++ {
+		req, err := FcWriteSingleRegister.MakeRequestHeader(i, 1)
+		require.NoError(t, err)
+		require.NoError(t, h.OnWrite(req, []byte{0, byte(i)}))
+	}
+	close(block)
+	for i := uint16(0); i < 5; i++ {
+		select {
+		case v := <-got:
+			require.Equal(t, i, v, "events are delivered in order")
+		case <-time.After(time.Second):
+			t.Fatal("missing event", i)
+		}
+	}
+}
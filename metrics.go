@@ -0,0 +1,289 @@
+package modbusone
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of latency histogram buckets used
+// by NewMetrics(nil) and NewTransactionStats(nil).
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second,
+}
+
+// Metrics collects transaction metrics from clients and servers, and serves them
+// with the Stats of SerialContexts in the Prometheus text exposition format.
+//
+// Use SetMetrics on clients and servers to report to a Metrics, one Metrics
+// can be shared by many clients and servers.
+type Metrics struct {
+	lock    sync.Mutex
+	buckets []time.Duration
+	stats   map[string]*Stats
+	client  roleMetrics
+	server  roleMetrics
+
+	clientStats *TransactionStats
+	serverStats *TransactionStats
+}
+
+// roleMetrics are the metrics of either clients or servers.
+type roleMetrics struct {
+	requests   map[FunctionCode]int64
+	exceptions map[FunctionCode]int64
+	timeouts   map[byte]int64
+	latency    latencyHistogram
+}
+
+// latencyHistogram counts durations in buckets.
+type latencyHistogram struct {
+	counts []int64 // count in each bucket, not cumulative, the last bucket is +Inf
+	sum    time.Duration
+	count  int64
+}
+
+func (h *latencyHistogram) observe(buckets []time.Duration, d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(buckets)+1)
+	}
+	h.counts[sort.Search(len(buckets), func(i int) bool { return d <= buckets[i] })]++
+	h.sum += d
+	h.count++
+}
+
+// NewMetrics creates a Metrics with latency histogram buckets, which must be in
+// increasing order, nil for DefaultLatencyBuckets.
+func NewMetrics(buckets []time.Duration) *Metrics {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	return &Metrics{
+		buckets:     append([]time.Duration(nil), buckets...),
+		stats:       make(map[string]*Stats),
+		clientStats: NewTransactionStats(buckets),
+		serverStats: NewTransactionStats(buckets),
+	}
+}
+
+// AddStats adds the Stats of a SerialContext to be exported with the label
+// serial="name". Adding to an existing name replaces it, nil removes it.
+func (m *Metrics) AddStats(name string, stats *Stats) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if stats == nil {
+		delete(m.stats, name)
+		return
+	}
+	m.stats[name] = stats
+}
+
+// ClientStats returns the TransactionStats of the clients reporting to m.
+func (m *Metrics) ClientStats() *TransactionStats {
+	return m.clientStats
+}
+
+// ServerStats returns the TransactionStats of the servers reporting to m.
+func (m *Metrics) ServerStats() *TransactionStats {
+	return m.serverStats
+}
+
+// observeClient records a client transaction attempt to slaveID with its round
+// trip time and result. Multicast latency is not recorded.
+func (m *Metrics) observeClient(slaveID byte, fc FunctionCode, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	m.client.observe(m.buckets, slaveID, fc, d, err)
+	m.lock.Unlock()
+	m.clientStats.observe(slaveID, fc, d, err)
+}
+
+// observeServer records a server request to slaveID with the time used by the
+// handler and its result.
+func (m *Metrics) observeServer(slaveID byte, fc FunctionCode, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	m.server.observe(m.buckets, slaveID, fc, d, err)
+	m.lock.Unlock()
+	m.serverStats.observe(slaveID, fc, d, err)
+}
+
+func (r *roleMetrics) observe(buckets []time.Duration, slaveID byte, fc FunctionCode, d time.Duration, err error) {
+	if r.requests == nil {
+		r.requests = make(map[FunctionCode]int64)
+		r.exceptions = make(map[FunctionCode]int64)
+		r.timeouts = make(map[byte]int64)
+	}
+	r.requests[fc]++
+	var ec ExceptionCode
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrServerTimeOut):
+		r.timeouts[slaveID]++
+		return // the time waited is not a latency
+	case errors.As(err, &ec):
+		r.exceptions[fc]++
+	}
+	if slaveID != 0 {
+		r.latency.observe(buckets, d)
+	}
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	m.writeStats(bw)
+	m.client.write(bw, "client", "round trip time of transactions", m.buckets)
+	m.server.write(bw, "server", "time used by handlers", m.buckets)
+	writeTransactionCounts(bw, "client", m.clientStats.Clone())
+	writeTransactionCounts(bw, "server", m.serverStats.Clone())
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// statsMetrics are the exported Stats fields.
+var statsMetrics = []struct {
+	name, help string
+	get        func(s *Stats) int64
+}{
+	{"read_packets", "packets read", func(s *Stats) int64 { return s.ReadPackets }},
+	{"crc_errors", "packets dropped for crc errors", func(s *Stats) int64 { return s.CrcErrors }},
+	{"remote_errors", "errors reported by remote", func(s *Stats) int64 { return s.RemoteErrors }},
+	{"other_errors", "other errors", func(s *Stats) int64 { return s.OtherErrors }},
+	{"long_read_warnings", "reads longer than expected", func(s *Stats) int64 { return s.LongReadWarnings }},
+	{"format_warnings", "packets with unexpected format", func(s *Stats) int64 { return s.FormateWarnings }},
+	{"id_drops", "packets dropped for other slave IDs", func(s *Stats) int64 { return s.IDDrops }},
+	{"other_drops", "packets dropped for other reasons", func(s *Stats) int64 { return s.OtherDrops }},
+	{"retries", "transactions resent by RetryPolicy", func(s *Stats) int64 { return s.Retries }},
+}
+
+func (m *Metrics) writeStats(w io.Writer) {
+	if len(m.stats) == 0 {
+		return
+	}
+	names := make([]string, 0, len(m.stats))
+	clones := make(map[string]*Stats, len(m.stats))
+	for name, s := range m.stats {
+		names = append(names, name)
+		clones[name] = s.Clone()
+	}
+	sort.Strings(names)
+	for _, sm := range statsMetrics {
+		metric := "modbus_serial_" + sm.name + "_total"
+		writeHeader(w, metric, "Total "+sm.help+".", "counter")
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{serial=%q} %d\n", metric, name, sm.get(clones[name]))
+		}
+	}
+}
+
+func (r *roleMetrics) write(w io.Writer, role, latencyHelp string, buckets []time.Duration) {
+	prefix := "modbus_" + role + "_"
+	writeHeader(w, prefix+"requests_total", "Total requests by function code.", "counter")
+	for _, fc := range sortedKeys(r.requests) {
+		fmt.Fprintf(w, "%srequests_total{function_code=\"%d\"} %d\n", prefix, fc, r.requests[fc])
+	}
+	writeHeader(w, prefix+"exceptions_total", "Total exception replies by function code.", "counter")
+	for _, fc := range sortedKeys(r.exceptions) {
+		fmt.Fprintf(w, "%sexceptions_total{function_code=\"%d\"} %d\n", prefix, fc, r.exceptions[fc])
+	}
+	if role == "client" {
+		writeHeader(w, prefix+"timeouts_total", "Total timeouts by slave ID.", "counter")
+		for _, id := range sortedKeys(r.timeouts) {
+			fmt.Fprintf(w, "%stimeouts_total{slave_id=\"%d\"} %d\n", prefix, id, r.timeouts[id])
+		}
+	}
+	r.latency.write(w, prefix+"latency_seconds", "Latency histogram of the "+latencyHelp+".", buckets)
+}
+
+// writeTransactionCounts writes the counts of s not already covered by roleMetrics.
+func writeTransactionCounts(w io.Writer, role string, s *TransactionStats) {
+	prefix := "modbus_" + role + "_"
+	fcs := sortedKeys(s.byFunctionCode)
+	writeHeader(w, prefix+"replies_total", "Total normal replies by function code.", "counter")
+	for _, fc := range fcs {
+		fmt.Fprintf(w, "%sreplies_total{function_code=\"%d\"} %d\n", prefix, fc, s.byFunctionCode[fc].Replies)
+	}
+	writeHeader(w, prefix+"other_errors_total", "Total requests failed for other reasons by function code.", "counter")
+	for _, fc := range fcs {
+		fmt.Fprintf(w, "%sother_errors_total{function_code=\"%d\"} %d\n", prefix, fc, s.byFunctionCode[fc].OtherErrors)
+	}
+	writeHeader(w, prefix+"exception_codes_total", "Total exception replies by function code and exception code.", "counter")
+	for _, fc := range fcs {
+		exceptions := s.byFunctionCode[fc].Exceptions
+		for _, ec := range sortedKeys(exceptions) {
+			fmt.Fprintf(w, "%sexception_codes_total{function_code=\"%d\",exception_code=\"%d\"} %d\n",
+				prefix, fc, ec, exceptions[ec])
+		}
+	}
+	writeHeader(w, prefix+"slave_requests_total", "Total requests by slave ID.", "counter")
+	for _, id := range sortedKeys(s.bySlave) {
+		fmt.Fprintf(w, "%sslave_requests_total{slave_id=\"%d\"} %d\n", prefix, id, s.bySlave[id].Requests)
+	}
+}
+
+func (h *latencyHistogram) write(w io.Writer, metric, help string, buckets []time.Duration) {
+	writeHeader(w, metric, help, "histogram")
+	cumulative := int64(0)
+	for i, b := range buckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", metric, formatSeconds(b), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", metric, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", metric, formatSeconds(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", metric, h.count)
+}
+
+func writeHeader(w io.Writer, metric, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric, help, metric, typ)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of m in increasing order.
+func sortedKeys[K FunctionCode | ExceptionCode | byte, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,93 @@
+package modbusone_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestMetrics(t *testing.T) {
+	slaveID := byte(0x11)
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	dw := &dropWriter{Writer: w2}
+
+	cc := newMockSerial(t, "c", r2, w1, w1)
+	sc := newMockSerial(t, "s", r1, dw, w2)
+	client := NewRTUClient(cc, slaveID)
+	client.SetServerProcessingTime(time.Second / 20)
+	defer client.Close()
+	server := NewRTUServer(sc, slaveID)
+	defer server.Close()
+
+	m := NewMetrics([]time.Duration{time.Millisecond, time.Second})
+	m.AddStats("c", cc.Stats())
+	client.SetMetrics(m)
+	server.SetMetrics(m)
+
+	h := &SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			if address == 100 {
+				return nil, EcIllegalDataAddress
+			}
+			return make([]uint16, quantity), nil
+		},
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			return nil
+		},
+	}
+	go client.Serve(h)
+	go server.Serve(h)
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	bad, err := FcReadHoldingRegisters.MakeRequestHeader(100, 1)
+	require.NoError(t, err)
+
+	require.NoError(t, client.DoTransaction(read))
+	require.True(t, errors.Is(client.DoTransaction(bad), EcIllegalDataAddress))
+	atomic.StoreInt32(&dw.n, 1)
+	require.True(t, errors.Is(client.DoTransaction(read), ErrServerTimeOut))
+
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	text := string(body)
+	for _, line := range []string{
+		`modbus_serial_other_errors_total{serial="c"} 1`,
+		"# TYPE modbus_client_requests_total counter",
+		`modbus_client_requests_total{function_code="3"} 3`,
+		`modbus_client_replies_total{function_code="3"} 1`,
+		`modbus_client_exceptions_total{function_code="3"} 1`,
+		`modbus_client_exception_codes_total{function_code="3",exception_code="2"} 1`,
+		`modbus_client_slave_requests_total{slave_id="17"} 3`,
+		`modbus_client_timeouts_total{slave_id="17"} 1`,
+		"# TYPE modbus_client_latency_seconds histogram",
+		`modbus_client_latency_seconds_bucket{le="+Inf"} 2`,
+		"modbus_client_latency_seconds_count 2",
+		`modbus_server_requests_total{function_code="3"} 3`,
+		`modbus_server_exceptions_total{function_code="3"} 1`,
+		`modbus_server_latency_seconds_bucket{le="1"} 3`,
+	} {
+		require.Contains(t, text, line+"\n")
+	}
+	require.NotContains(t, text, "modbus_server_timeouts_total")
+
+	counts := m.ClientStats().BySlave()[slaveID]
+	require.Equal(t, int64(3), counts.Requests)
+	require.Equal(t, int64(1), counts.Timeouts)
+	require.Equal(t, int64(1), counts.Exceptions[EcIllegalDataAddress])
+}
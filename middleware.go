@@ -0,0 +1,196 @@
+package modbusone
+
+import (
+	"fmt"
+	"time"
+)
+
+// Middleware wraps a ProtocolHandler to add behavior around it, such as logging
+// or access control. Since every Client and Server is served by a ProtocolHandler,
+// the same Middleware works for all of them.
+type Middleware func(ProtocolHandler) ProtocolHandler
+
+// Chain wraps handler in middlewares. The first middleware is the outermost,
+// and sees a request first.
+func Chain(handler ProtocolHandler, middlewares ...Middleware) ProtocolHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// middlewareHandler implements ProtocolHandler by calling the non nil funcs,
+// and next for the rest.
+type middlewareHandler struct {
+	next    ProtocolHandler
+	onRead  func(req PDU) ([]byte, error)
+	onWrite func(req PDU, data []byte) error
+	onError func(req PDU, errRep PDU)
+}
+
+func (h *middlewareHandler) OnRead(req PDU) ([]byte, error) {
+	if h.onRead == nil {
+		return h.next.OnRead(req)
+	}
+	return h.onRead(req)
+}
+
+func (h *middlewareHandler) OnWrite(req PDU, data []byte) error {
+	if h.onWrite == nil {
+		return h.next.OnWrite(req, data)
+	}
+	return h.onWrite(req, data)
+}
+
+func (h *middlewareHandler) OnError(req PDU, errRep PDU) {
+	if h.onError == nil {
+		h.next.OnError(req, errRep)
+		return
+	}
+	h.onError(req, errRep)
+}
+
+// describeRequest returns function code, address and quantity of req for logging.
+func describeRequest(req PDU) string {
+	if len(req) < 3 {
+		return fmt.Sprintf("fc:%v %x", req.GetFunctionCode(), []byte(req))
+	}
+	count, _ := req.GetRequestCount()
+	return fmt.Sprintf("fc:%v address:%v quantity:%v", req.GetFunctionCode(), req.GetAddress(), count)
+}
+
+// LogRequests logs every handler call and its outcome with logf, such as
+// log.Printf or testing.T.Logf.
+func LogRequests(logf func(format string, a ...interface{})) Middleware {
+	return func(next ProtocolHandler) ProtocolHandler {
+		return &middlewareHandler{
+			next: next,
+			onRead: func(req PDU) ([]byte, error) {
+				data, err := next.OnRead(req)
+				logf("OnRead %v bytes:%v err:%v", describeRequest(req), len(data), err)
+				return data, err
+			},
+			onWrite: func(req PDU, data []byte) error {
+				err := next.OnWrite(req, data)
+				logf("OnWrite %v bytes:%v err:%v", describeRequest(req), len(data), err)
+				return err
+			},
+			onError: func(req PDU, errRep PDU) {
+				logf("OnError %v reply:%x", describeRequest(req), []byte(errRep))
+				next.OnError(req, errRep)
+			},
+		}
+	}
+}
+
+// ProtectWrites rejects write requests from a client that touch the address
+// range from start to end inclusive of Table t, with EcIllegalDataAddress.
+// Only write function codes are rejected, so it is a no-op on the client side
+// where OnWrite is called for read replies.
+func ProtectWrites(t Table, start, end uint16) Middleware {
+	return func(next ProtocolHandler) ProtocolHandler {
+		return &middlewareHandler{
+			next: next,
+			onWrite: func(req PDU, data []byte) error {
+				fc := req.GetFunctionCode()
+				if fc.IsWriteToServer() && fc.Table() == t && len(req) >= 3 {
+					count, err := req.GetRequestCount()
+					if err != nil {
+						return err
+					}
+					first := int(req.GetAddress())
+					last := first + int(count) - 1
+					if first <= int(end) && last >= int(start) {
+						return fmt.Errorf("%w %v %v-%v is write protected", EcIllegalDataAddress, t, start, end)
+					}
+				}
+				return next.OnWrite(req, data)
+			},
+		}
+	}
+}
+
+// AllowFunctionCodes rejects requests for all FunctionCodes not listed with
+// ErrFcNotSupported, which replies EcIllegalFunction on servers.
+func AllowFunctionCodes(fcs ...FunctionCode) Middleware {
+	var allowed [256]bool
+	for _, fc := range fcs {
+		allowed[fc] = true
+	}
+	check := func(req PDU) error {
+		if !allowed[req.GetFunctionCode()] {
+			return fmt.Errorf("%w: %v is not allowed", ErrFcNotSupported, req.GetFunctionCode())
+		}
+		return nil
+	}
+	return func(next ProtocolHandler) ProtocolHandler {
+		return &middlewareHandler{
+			next: next,
+			onRead: func(req PDU) ([]byte, error) {
+				if err := check(req); err != nil {
+					return nil, err
+				}
+				return next.OnRead(req)
+			},
+			onWrite: func(req PDU, data []byte) error {
+				if err := check(req); err != nil {
+					return err
+				}
+				return next.OnWrite(req, data)
+			},
+		}
+	}
+}
+
+// RecoverPanics stops panics in handlers from terminating the Serve loop. A
+// panic in OnRead or OnWrite is returned as an error wrapping EcServerDeviceFailure,
+// and a panic in OnError is dropped.
+func RecoverPanics() Middleware {
+	recovered := func(err *error) {
+		if r := recover(); r != nil {
+			debugf("RecoverPanics: %v", r)
+			if err != nil {
+				*err = fmt.Errorf("%w: handler panic: %v", EcServerDeviceFailure, r)
+			}
+		}
+	}
+	return func(next ProtocolHandler) ProtocolHandler {
+		return &middlewareHandler{
+			next: next,
+			onRead: func(req PDU) (data []byte, err error) {
+				defer recovered(&err)
+				return next.OnRead(req)
+			},
+			onWrite: func(req PDU, data []byte) (err error) {
+				defer recovered(&err)
+				return next.OnWrite(req, data)
+			},
+			onError: func(req PDU, errRep PDU) {
+				defer recovered(nil)
+				next.OnError(req, errRep)
+			},
+		}
+	}
+}
+
+// MeasureLatency calls observe with the duration of every OnRead and OnWrite
+// call, and the error it returned.
+func MeasureLatency(observe func(req PDU, d time.Duration, err error)) Middleware {
+	return func(next ProtocolHandler) ProtocolHandler {
+		return &middlewareHandler{
+			next: next,
+			onRead: func(req PDU) ([]byte, error) {
+				start := time.Now()
+				data, err := next.OnRead(req)
+				observe(req, time.Since(start), err)
+				return data, err
+			},
+			onWrite: func(req PDU, data []byte) error {
+				start := time.Now()
+				err := next.OnWrite(req, data)
+				observe(req, time.Since(start), err)
+				return err
+			},
+		}
+	}
+}
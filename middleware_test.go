@@ -0,0 +1,72 @@
+package modbusone_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestMiddleware(t *testing.T) {
+	mem := NewMemoryHandler(100)
+	var logs []string
+	var latencies int
+	h := Chain(mem,
+		RecoverPanics(),
+		LogRequests(func(format string, a ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, a...))
+		}),
+		MeasureLatency(func(req PDU, d time.Duration, err error) {
+			latencies++
+		}),
+		AllowFunctionCodes(FcReadHoldingRegisters, FcWriteSingleRegister, FcWriteMultipleRegisters),
+		ProtectWrites(TableHoldingRegisters, 10, 19),
+	)
+
+	write := func(address uint16, values ...uint16) error {
+		req, err := FcWriteMultipleRegisters.MakeRequestHeader(address, uint16(len(values)))
+		require.NoError(t, err)
+		data, err := RegistersToData(values)
+		require.NoError(t, err)
+		return h.OnWrite(req.MakeWriteRequest(data), data)
+	}
+
+	require.NoError(t, write(0, 1, 2))
+	err := write(8, 1, 2, 3)
+	require.True(t, errors.Is(err, EcIllegalDataAddress), err)
+	require.NoError(t, write(20, 1))
+
+	req, err := FcReadInputRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	_, err = h.OnRead(req)
+	require.Equal(t, EcIllegalFunction, ToExceptionCode(err))
+
+	// read reply on the client side is not a protected write
+	req, err = FcReadHoldingRegisters.MakeRequestHeader(10, 1)
+	require.NoError(t, err)
+	require.NoError(t, h.OnWrite(req, []byte{0, 5}))
+
+	require.Len(t, logs, 5)
+	require.Equal(t, "OnWrite fc:16 address:0 quantity:2 bytes:4 err:<nil>", logs[0])
+	require.Equal(t, 5, latencies)
+}
+
+func TestRecoverPanics(t *testing.T) {
+	h := Chain(&SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			panic("bad handler")
+		},
+		OnErrorImp: func(req PDU, errRep PDU) {
+			panic("bad handler")
+		},
+	}, RecoverPanics())
+	req, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	_, err = h.OnRead(req)
+	require.Equal(t, EcServerDeviceFailure, ToExceptionCode(err))
+	require.True(t, errors.Is(err, EcServerDeviceFailure))
+	h.OnError(req, ExceptionReplyPacket(req, EcServerDeviceBusy))
+}
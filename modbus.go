@@ -113,9 +113,12 @@ func (f FunctionCode) MaxPerPacket() uint16 {
 // if we are to further limit PDU packet size from MaxRTUSize.
 // At least 1 (8 for bools) is returned if size is too small.
 func (f FunctionCode) MaxPerPacketSized(size int) uint16 {
-	if size > MaxPDUSize {
-		size = MaxPDUSize
-	}
+	return f.maxPerPacketSized(min(size, MaxPDUSize))
+}
+
+// maxPerPacketSized is MaxPerPacketSized, without limiting size to MaxPDUSize
+// for over sized packets.
+func (f FunctionCode) maxPerPacketSized(size int) uint16 {
 	if size < 10 {
 		debugf("warning: PDU packet size is only %v", size)
 	}
@@ -160,11 +163,17 @@ func (f FunctionCode) MaxPerPacketSized(size int) uint16 {
 // client side StartTransaction.
 // The inverse functions are PDU.GetFunctionCode(), .GetAddress(), and .GetRequestCount().
 func (f FunctionCode) MakeRequestHeader(address, quantity uint16) (PDU, error) {
+	return f.makeRequestHeader(address, quantity, false)
+}
+
+// makeRequestHeader is MakeRequestHeader, which does not limit quantity with
+// overSize.
+func (f FunctionCode) makeRequestHeader(address, quantity uint16, overSize bool) (PDU, error) {
 	if f.MaxPerPacket() == 0 {
 		return nil, fmt.Errorf("%w function %v is not supported by MakeRequestHeader", EcIllegalFunction, f)
 	} else if quantity == 0 {
 		return nil, fmt.Errorf("%w quantity is required for MakeRequestHeader", EcIllegalDataAddress)
-	} else if quantity > f.MaxPerPacket() {
+	} else if quantity > f.MaxPerPacket() && !overSize {
 		return nil, fmt.Errorf("%w %v can not pack %v at once", EcIllegalDataAddress, f, quantity)
 	} else if uint32(address)+uint32(quantity) > uint32(f.MaxRange()) {
 		return nil, fmt.Errorf("%w %v + %v out of range %v", EcIllegalDataAddress, address, quantity-1, f.MaxRange())
@@ -322,6 +331,32 @@ func (p PDU) ValidateRequest() error {
 	return nil
 }
 
+// validateServerRequest tests a received request as servers do before calling
+// the handler: the function code, the length of read requests, the quantity
+// of values, and the address range, in the order of the exception codes
+// required by the Modbus specification. The quantity is not limited with
+// overSize.
+func (p PDU) validateServerRequest(overSize bool) error {
+	fc := p.GetFunctionCode()
+	if !fc.Valid() {
+		return EcIllegalFunction
+	}
+	if len(p) < 5 || (fc.IsReadToServer() && len(p) != 5) {
+		return EcIllegalDataValue
+	}
+	count, err := p.GetRequestCount()
+	if err != nil {
+		return err
+	}
+	if count == 0 || (count > fc.MaxPerPacket() && !overSize) {
+		return EcIllegalDataValue
+	}
+	if int(p.GetAddress())+int(count) > int(fc.MaxRange())+1 {
+		return EcIllegalDataAddress
+	}
+	return nil
+}
+
 // GetFunctionCode returns the function code.
 func (p PDU) GetFunctionCode() FunctionCode {
 	if len(p) == 0 {
@@ -350,6 +385,12 @@ func (p PDU) GetRequestCount() (uint16, error) {
 
 // GetRequestValues returns the values in a write request.
 func (p PDU) GetRequestValues() ([]byte, error) {
+	return p.getRequestValues(IsOverSizeSupported())
+}
+
+// getRequestValues is GetRequestValues, which ignores the encoded number of
+// bytes with overSize.
+func (p PDU) getRequestValues(overSize bool) ([]byte, error) {
 	f := p.GetFunctionCode()
 	if f == 0 {
 		return nil, EcIllegalFunction
@@ -366,7 +407,7 @@ func (p PDU) GetRequestValues() ([]byte, error) {
 		debugf("fc %v got %v PDU bytes, expected > 6", p.GetFunctionCode(), len(p))
 		return nil, EcIllegalDataValue
 	}
-	if lb != int(p[5]) && !IsOverSizeSupported() {
+	if lb != int(p[5]) && !overSize {
 		debugf("declared %v bytes of data, but got %v bytes", p[5], lb)
 		return nil, EcIllegalDataValue
 	}
@@ -398,8 +439,14 @@ func (p PDU) GetRequestValues() ([]byte, error) {
 
 // GetReplyValues returns the values in a read reply.
 func (p PDU) GetReplyValues() ([]byte, error) {
+	return p.getReplyValues(false)
+}
+
+// getReplyValues is GetReplyValues, which ignores the encoded number of bytes
+// with overSize.
+func (p PDU) getReplyValues(overSize bool) ([]byte, error) {
 	l := len(p) - 2 // bytes of values
-	if l < 1 || l != int(p[1]) {
+	if l < 1 || (l != int(p[1]) && !overSize) {
 		return nil, fmt.Errorf("length mismatch with bytes")
 	}
 	return p[2:], nil
@@ -407,7 +454,12 @@ func (p PDU) GetReplyValues() ([]byte, error) {
 
 // MakeReadReply produces the reply PDU based on the request PDU and read data.
 func (p PDU) MakeReadReply(data []byte) PDU {
-	return PDU(append([]byte{byte(p.GetFunctionCode()), byte(len(data))}, data...))
+	return p.AppendReadReply(make([]byte, 0, len(data)+2), data)
+}
+
+// AppendReadReply is MakeReadReply, with the reply appended to dst.
+func (p PDU) AppendReadReply(dst []byte, data []byte) PDU {
+	return append(append(dst, byte(p.GetFunctionCode()), byte(len(data))), data...)
 }
 
 // MakeWriteRequest produces the request PDU based on the request PDU header and
@@ -0,0 +1,202 @@
+package modbustest
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiegeo/modbusone"
+)
+
+// Fault is a set of faults to inject into a frame.
+type Fault uint8
+
+// Faults that FaultySerial can inject into each frame written.
+const (
+	// FaultDrop drops the frame.
+	FaultDrop Fault = 1 << iota
+	// FaultCorrupt flips a random bit, so the frame fails its CRC check.
+	FaultCorrupt
+	// FaultSplit splits the frame in two writes, with a delay longer than
+	// PacketCutoffDuration between them.
+	FaultSplit
+	// FaultGarbage writes random bytes just before the frame.
+	FaultGarbage
+	// FaultDuplicate writes the frame again after MinDelay.
+	FaultDuplicate
+	// FaultLatency delays the frame by FaultConfig.Latency.
+	FaultLatency
+)
+
+// FaultConfig configures the faults injected by FaultySerial. Faults are
+// chosen for each frame by Schedule and by chance, and both are combined.
+type FaultConfig struct {
+	_ struct{} // enforces keyed literals
+
+	// Seed seeds the random numbers used to choose faults, and their details
+	// such as which bit to flip, so runs with the same Seed and Schedule inject
+	// the same faults.
+	Seed int64
+	// Schedule returns the faults to inject into frame n, counting from 0.
+	// Nil for no scheduled faults.
+	Schedule func(n int) Fault
+
+	// The probability from 0 to 1, of each fault for every frame.
+	DropRate      float64
+	CorruptRate   float64
+	SplitRate     float64
+	GarbageRate   float64
+	DuplicateRate float64
+	LatencyRate   float64
+
+	// GarbageBytes is the max number of bytes inserted by FaultGarbage, default 4.
+	GarbageBytes int
+	// Latency is the delay added by FaultLatency, up to an additional random
+	// LatencyJitter.
+	Latency       time.Duration
+	LatencyJitter time.Duration
+}
+
+// FaultCounts are the number of frames written, and of each fault injected.
+type FaultCounts struct {
+	Frames     int64
+	Dropped    int64
+	Corrupted  int64
+	Split      int64
+	Garbage    int64
+	Duplicated int64
+	Delayed    int64
+}
+
+// FaultySerial wraps a SerialContext and injects faults into frames written
+// to it, where each Write is a frame. To fault requests, wrap the SerialContext
+// of the client, to fault replies, wrap the server's.
+type FaultySerial struct {
+	modbusone.SerialContext
+	config FaultConfig
+	counts FaultCounts
+
+	lock   sync.Mutex // protects random and frames, and orders writes
+	random *rand.Rand
+	frames int
+}
+
+var _ modbusone.SerialContextV3 = &FaultySerial{}
+
+// NewFaultySerial wraps sc to inject faults as configured.
+func NewFaultySerial(sc modbusone.SerialContext, config FaultConfig) *FaultySerial {
+	if config.GarbageBytes <= 0 {
+		config.GarbageBytes = 4
+	}
+	return &FaultySerial{
+		SerialContext: sc,
+		config:        config,
+		random:        rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Counts returns the number of frames and faults so far.
+func (s *FaultySerial) Counts() FaultCounts {
+	return FaultCounts{
+		Frames:     atomic.LoadInt64(&s.counts.Frames),
+		Dropped:    atomic.LoadInt64(&s.counts.Dropped),
+		Corrupted:  atomic.LoadInt64(&s.counts.Corrupted),
+		Split:      atomic.LoadInt64(&s.counts.Split),
+		Garbage:    atomic.LoadInt64(&s.counts.Garbage),
+		Duplicated: atomic.LoadInt64(&s.counts.Duplicated),
+		Delayed:    atomic.LoadInt64(&s.counts.Delayed),
+	}
+}
+
+// chance returns true with probability rate.
+func (s *FaultySerial) chance(rate float64) bool {
+	return rate > 0 && s.random.Float64() < rate
+}
+
+// faults chooses the faults of the next frame.
+func (s *FaultySerial) faults() Fault {
+	var f Fault
+	if s.config.Schedule != nil {
+		f = s.config.Schedule(s.frames)
+	}
+	s.frames++
+	rates := []float64{s.config.DropRate, s.config.CorruptRate, s.config.SplitRate,
+		s.config.GarbageRate, s.config.DuplicateRate, s.config.LatencyRate}
+	for i, rate := range rates {
+		if s.chance(rate) {
+			f |= 1 << i
+		}
+	}
+	return f
+}
+
+// Write writes p as a frame, with faults injected.
+func (s *FaultySerial) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	atomic.AddInt64(&s.counts.Frames, 1)
+	f := s.faults()
+	if f&FaultLatency != 0 {
+		atomic.AddInt64(&s.counts.Delayed, 1)
+		d := s.config.Latency
+		if s.config.LatencyJitter > 0 {
+			d += time.Duration(s.random.Int63n(int64(s.config.LatencyJitter)))
+		}
+		time.Sleep(d)
+	}
+	if f&FaultDrop != 0 {
+		atomic.AddInt64(&s.counts.Dropped, 1)
+		return len(p), nil
+	}
+	data := append([]byte(nil), p...)
+	if f&FaultCorrupt != 0 && len(data) > 0 {
+		atomic.AddInt64(&s.counts.Corrupted, 1)
+		data[s.random.Intn(len(data))] ^= 1 << s.random.Intn(8)
+	}
+	if f&FaultGarbage != 0 {
+		atomic.AddInt64(&s.counts.Garbage, 1)
+		garbage := make([]byte, 1+s.random.Intn(s.config.GarbageBytes))
+		s.random.Read(garbage)
+		data = append(garbage, data...)
+	}
+	if err := s.writeFrame(data, f&FaultSplit != 0); err != nil {
+		return 0, err
+	}
+	if f&FaultDuplicate != 0 {
+		atomic.AddInt64(&s.counts.Duplicated, 1)
+		time.Sleep(s.BytesDelay(len(data)) + s.MinDelay())
+		if err := s.writeFrame(data, false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// writeFrame writes data, in two parts if split.
+func (s *FaultySerial) writeFrame(data []byte, split bool) error {
+	if split && len(data) > 1 {
+		atomic.AddInt64(&s.counts.Split, 1)
+		i := 1 + s.random.Intn(len(data)-1)
+		if _, err := s.SerialContext.Write(data[:i]); err != nil {
+			return err
+		}
+		time.Sleep(s.BytesDelay(i) + s.PacketCutoffDuration(len(data)-i) + s.MinDelay())
+		data = data[i:]
+	}
+	_, err := s.SerialContext.Write(data)
+	return err
+}
+
+// PacketCutoffDuration implements modbusone.SerialContextV2.
+func (s *FaultySerial) PacketCutoffDuration(n int) time.Duration {
+	return modbusone.GetPacketCutoffDurationFromSerialContext(s.SerialContext, n)
+}
+
+// GetOption implements modbusone.OptionContext.
+func (s *FaultySerial) GetOption() modbusone.Option {
+	if o, ok := s.SerialContext.(modbusone.OptionContext); ok {
+		return o.GetOption()
+	}
+	return modbusone.Option{}
+}
@@ -0,0 +1,78 @@
+package modbustest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xiegeo/modbusone"
+	"github.com/xiegeo/modbusone/modbustest"
+)
+
+func TestFaultySerialSchedule(t *testing.T) {
+	faults := []modbustest.Fault{0, modbustest.FaultDrop, modbustest.FaultCorrupt, modbustest.FaultSplit,
+		modbustest.FaultGarbage, modbustest.FaultDuplicate, modbustest.FaultLatency}
+	bus := modbustest.NewVirtualBus(19200, modbustest.BusOption{})
+	defer bus.Close()
+	clientNode := bus.Attach("client")
+	client := modbusone.NewRTUClient(clientNode, 1)
+	client.SetServerProcessingTime(time.Second / 20)
+	go client.Serve(modbusone.NewMemoryHandler(10))
+	// fault the replies of the server
+	faulty := modbustest.NewFaultySerial(bus.Attach("server"), modbustest.FaultConfig{
+		Seed: 1,
+		Schedule: func(n int) modbustest.Fault {
+			if n < len(faults) {
+				return faults[n]
+			}
+			return 0
+		},
+		Latency: time.Second * 3 / 10,
+	})
+	server := modbusone.NewRTUServer(faulty, 1)
+	go server.Serve(modbusone.NewMemoryHandler(10))
+
+	req, err := modbusone.FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	for i, f := range faults {
+		err := client.DoTransaction(req)
+		switch f {
+		case 0, modbustest.FaultDuplicate:
+			require.NoError(t, err, i)
+		case modbustest.FaultDrop, modbustest.FaultLatency:
+			require.True(t, errors.Is(err, modbusone.ErrServerTimeOut), "%v %v", i, err)
+		default:
+			require.Error(t, err, i)
+		}
+		time.Sleep(time.Second * 4 / 10) // let faulty frames finish
+	}
+	require.NoError(t, client.DoTransaction(req), "recovered")
+	require.Equal(t, modbustest.FaultCounts{Frames: 8, Dropped: 1, Corrupted: 1, Split: 1, Garbage: 1, Duplicated: 1, Delayed: 1},
+		faulty.Counts())
+	require.GreaterOrEqual(t, clientNode.Stats().CrcErrors, int64(1))
+}
+
+func TestFaultySerialRandom(t *testing.T) {
+	run := func(seed int64) modbustest.FaultCounts {
+		bus := modbustest.NewVirtualBus(115200, modbustest.BusOption{})
+		defer bus.Close()
+		faulty := modbustest.NewFaultySerial(bus.Attach("a"), modbustest.FaultConfig{
+			Seed:        seed,
+			DropRate:    0.5,
+			CorruptRate: 0.5,
+			GarbageRate: 0.25,
+		})
+		for i := 0; i < 100; i++ {
+			_, err := faulty.Write([]byte{1, 2, 3})
+			require.NoError(t, err)
+		}
+		return faulty.Counts()
+	}
+	counts := run(1)
+	require.Equal(t, int64(100), counts.Frames)
+	require.InDelta(t, 50, counts.Dropped, 20)
+	require.InDelta(t, 25, counts.Corrupted, 15, "corrupted if not dropped")
+	require.Zero(t, counts.Split)
+	require.Equal(t, counts, run(1), "same seed, same faults")
+}
@@ -0,0 +1,445 @@
+package modbustest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiegeo/modbusone"
+)
+
+// ErrNoReply is returned by a Transport when a request is not answered in time.
+var ErrNoReply = errors.New("no reply")
+
+// Transport sends requests to a server under test, as a client would.
+type Transport interface {
+	// Transact sends req to slaveID and returns the reply PDU, or ErrNoReply
+	// if there is no reply within timeout.
+	Transact(slaveID byte, req modbusone.PDU, timeout time.Duration) (modbusone.PDU, error)
+	// Close closes the connection to the server.
+	Close() error
+}
+
+// suiteMemorySize is the size of each table of the handler served by the
+// server under test, large enough for MaxPerPacket of every function code.
+const suiteMemorySize = 0x1000
+
+// ServerSuite is a conformance test suite for Modbus servers, such as
+// RTUServer, TCPServer, FailoverRTU servers, or a custom handler stack. It
+// sends requests through a Transport, and reports replies that violate the
+// Modbus specification as test failures.
+type ServerSuite struct {
+	_ struct{} // enforces keyed literals
+
+	// Start starts the server under test serving handler, and returns a
+	// Transport to it. Use t.Cleanup to stop the server.
+	Start func(t testing.TB, handler modbusone.ProtocolHandler) Transport
+	// SlaveID is the slave ID served, default 1.
+	SlaveID byte
+	// SerialLine tests the serial line behavior: broadcasts to slave ID 0 are
+	// handled without a reply, and requests to other slave IDs are ignored.
+	SerialLine bool
+	// Timeout is how long to wait for a reply, and to confirm there is none,
+	// default 1 second.
+	Timeout time.Duration
+}
+
+// Run runs the suite as subtests of t. The server is started once and the
+// Transport is closed when done.
+//
+// Requests with an unsupported function code, quantity or address must be
+// answered with the exception code in the order required by the
+// specification. A truncated request can only be answered if the server
+// frames requests by silence, so no reply is also accepted.
+func (s ServerSuite) Run(t *testing.T) {
+	if s.SlaveID == 0 {
+		s.SlaveID = 1
+	}
+	if s.Timeout == 0 {
+		s.Timeout = time.Second
+	}
+	h := newSuiteHandler()
+	r := &suiteRun{ServerSuite: s, handler: h, transport: s.Start(t, h)}
+	defer r.transport.Close()
+
+	t.Run("FunctionCodes", r.testFunctionCodes)
+	t.Run("Quantities", r.testQuantities)
+	t.Run("Addresses", r.testAddresses)
+	t.Run("Malformed", r.testMalformed)
+	t.Run("Exceptions", r.testExceptions)
+	if s.SerialLine {
+		t.Run("Broadcast", r.testBroadcast)
+		t.Run("OtherSlaveID", r.testOtherSlaveID)
+	}
+}
+
+// suiteHandler is a MemoryHandler that can fail all requests with an error.
+type suiteHandler struct {
+	*modbusone.MemoryHandler
+
+	lock  sync.Mutex
+	err   error // returned instead of handling requests, if not nil
+	calls int
+}
+
+func newSuiteHandler() *suiteHandler {
+	h := &suiteHandler{MemoryHandler: modbusone.NewMemoryHandler(suiteMemorySize)}
+	bools := make([]bool, suiteMemorySize)
+	registers := make([]uint16, suiteMemorySize)
+	for i := range bools {
+		bools[i] = i%3 == 0
+		registers[i] = uint16(i*7 + 1)
+	}
+	h.WriteBools(modbusone.TableCoils, 0, bools)
+	h.WriteRegisters(modbusone.TableHoldingRegisters, 0, registers)
+	for i := range bools {
+		bools[i] = i%5 == 1
+		registers[i] = uint16(i) ^ 0x8000
+	}
+	h.WriteBools(modbusone.TableDiscreteInputs, 0, bools)
+	h.WriteRegisters(modbusone.TableInputRegisters, 0, registers)
+	return h
+}
+
+// call counts a call, and returns the error to fail it with.
+func (h *suiteHandler) call() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.calls++
+	return h.err
+}
+
+// setError sets the error to fail requests with, nil to handle them.
+func (h *suiteHandler) setError(err error) {
+	h.lock.Lock()
+	h.err = err
+	h.lock.Unlock()
+}
+
+// callCount returns the number of requests handled.
+func (h *suiteHandler) callCount() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.calls
+}
+
+// OnRead implements ProtocolHandler.
+func (h *suiteHandler) OnRead(req modbusone.PDU) ([]byte, error) {
+	if err := h.call(); err != nil {
+		return nil, err
+	}
+	return h.MemoryHandler.OnRead(req)
+}
+
+// OnWrite implements ProtocolHandler.
+func (h *suiteHandler) OnWrite(req modbusone.PDU, data []byte) error {
+	if err := h.call(); err != nil {
+		return err
+	}
+	return h.MemoryHandler.OnWrite(req, data)
+}
+
+// suiteRun is the state of a running ServerSuite.
+type suiteRun struct {
+	ServerSuite
+	handler   *suiteHandler
+	transport Transport
+}
+
+// request makes a request PDU of fc, address and quantity, followed by data.
+// Single writes have no quantity, their value is in data.
+func request(fc modbusone.FunctionCode, address, quantity uint16, data ...byte) modbusone.PDU {
+	p := []byte{byte(fc), byte(address >> 8), byte(address)}
+	if !fc.IsSingle() {
+		p = append(p, byte(quantity>>8), byte(quantity))
+	}
+	return append(p, data...)
+}
+
+// writeRequest makes a valid write request of values, which are []bool or
+// []uint16.
+func writeRequest(t *testing.T, fc modbusone.FunctionCode, address uint16, values interface{}) modbusone.PDU {
+	t.Helper()
+	var data []byte
+	var quantity int
+	var err error
+	switch v := values.(type) {
+	case []bool:
+		quantity = len(v)
+		data, err = modbusone.BoolsToData(v, fc)
+	case []uint16:
+		quantity = len(v)
+		data, err = modbusone.RegistersToData(v)
+	}
+	if err != nil {
+		t.Fatalf("can not make request data: %v", err)
+	}
+	if fc.IsSingle() {
+		return request(fc, address, 0, data...)
+	}
+	return request(fc, address, uint16(quantity), append([]byte{byte(len(data))}, data...)...)
+}
+
+// transact sends req, and fails t if the reply is not a reply to req.
+func (r *suiteRun) transact(t *testing.T, slaveID byte, req modbusone.PDU) (modbusone.PDU, error) {
+	t.Helper()
+	rep, err := r.transport.Transact(slaveID, req, r.Timeout)
+	if err != nil {
+		if !errors.Is(err, ErrNoReply) {
+			t.Fatalf("request %v: %v", req, err)
+		}
+		return nil, err
+	}
+	if len(rep) == 0 || !modbusone.MatchPDU(req, rep) {
+		t.Errorf("request %v: reply %x has the wrong function code", req, []byte(rep))
+	} else if isException(rep) && len(rep) != 2 {
+		t.Errorf("request %v: exception reply %x is not 2 bytes", req, []byte(rep))
+	}
+	return rep, nil
+}
+
+func isException(p modbusone.PDU) bool {
+	ec, _ := p.GetFunctionCode().SeparateError()
+	return ec
+}
+
+// expectReply sends req, and fails t unless the reply is want.
+func (r *suiteRun) expectReply(t *testing.T, req, want modbusone.PDU) {
+	t.Helper()
+	rep, err := r.transact(t, r.SlaveID, req)
+	if err != nil {
+		t.Errorf("request %v: %v, want reply %v", req, err, want)
+		return
+	}
+	if string(rep) != string(want) {
+		t.Errorf("request %v: got reply %v, want %v", req, rep, want)
+	}
+}
+
+// expectException sends req, and fails t unless the reply is exception ec.
+// If truncated, no reply is also accepted.
+func (r *suiteRun) expectException(t *testing.T, req modbusone.PDU, ec modbusone.ExceptionCode, truncated bool) {
+	t.Helper()
+	rep, err := r.transact(t, r.SlaveID, req)
+	if err != nil {
+		if !truncated {
+			t.Errorf("request %v: %v, want exception %v", req, err, ec)
+		}
+		return
+	}
+	if !isException(rep) || len(rep) < 2 || modbusone.ExceptionCode(rep[1]) != ec {
+		t.Errorf("request %v: got reply %v, want exception %v", req, rep, ec)
+	}
+}
+
+// expectNoReply sends req to slaveID, and fails t if it is answered.
+func (r *suiteRun) expectNoReply(t *testing.T, slaveID byte, req modbusone.PDU) {
+	t.Helper()
+	rep, err := r.transact(t, slaveID, req)
+	if err == nil {
+		t.Errorf("request %v to slave %v: got reply %v, want none", req, slaveID, rep)
+	}
+}
+
+// expectValues fails t unless values are at address of table.
+func (r *suiteRun) expectValues(t *testing.T, table modbusone.Table, address uint16, values interface{}) {
+	t.Helper()
+	var got interface{}
+	var err error
+	switch v := values.(type) {
+	case []bool:
+		got, err = r.handler.ReadBools(table, address, uint16(len(v)))
+	case []uint16:
+		got, err = r.handler.ReadRegisters(table, address, uint16(len(v)))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(values) {
+		t.Errorf("%v from %v: got %v, want %v", table, address, got, values)
+	}
+}
+
+// readReply returns the expected reply to a read request of fc.
+func (r *suiteRun) readReply(t *testing.T, fc modbusone.FunctionCode, address, quantity uint16) modbusone.PDU {
+	t.Helper()
+	var data []byte
+	var err error
+	if fc.IsBool() {
+		var values []bool
+		values, err = r.handler.ReadBools(fc.Table(), address, quantity)
+		if err == nil {
+			data, err = modbusone.BoolsToData(values, fc)
+		}
+	} else {
+		var values []uint16
+		values, err = r.handler.ReadRegisters(fc.Table(), address, quantity)
+		if err == nil {
+			data, err = modbusone.RegistersToData(values)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request(fc, 0, 0).MakeReadReply(data)
+}
+
+var readFunctionCodes = []modbusone.FunctionCode{
+	modbusone.FcReadCoils, modbusone.FcReadDiscreteInputs,
+	modbusone.FcReadHoldingRegisters, modbusone.FcReadInputRegisters,
+}
+
+func (r *suiteRun) testFunctionCodes(t *testing.T) {
+	for _, fc := range readFunctionCodes {
+		req := request(fc, 10, 13)
+		r.expectReply(t, req, r.readReply(t, fc, 10, 13))
+	}
+
+	writes := []struct {
+		fc      modbusone.FunctionCode
+		address uint16
+		values  interface{}
+	}{
+		{modbusone.FcWriteSingleCoil, 20, []bool{true}},
+		{modbusone.FcWriteSingleCoil, 21, []bool{false}},
+		{modbusone.FcWriteSingleRegister, 30, []uint16{0xBEEF}},
+		{modbusone.FcWriteMultipleCoils, 40, []bool{true, true, false, true, false, false, true, true, true, false}},
+		{modbusone.FcWriteMultipleRegisters, 50, []uint16{1, 0xFFFF, 0x8000}},
+	}
+	for _, w := range writes {
+		req := writeRequest(t, w.fc, w.address, w.values)
+		r.expectReply(t, req, req[:5])
+		r.expectValues(t, w.fc.Table(), w.address, w.values)
+	}
+}
+
+func (r *suiteRun) testQuantities(t *testing.T) {
+	for _, fc := range readFunctionCodes {
+		max := fc.MaxPerPacket()
+		r.expectReply(t, request(fc, 0, 1), r.readReply(t, fc, 0, 1))
+		r.expectReply(t, request(fc, 0, max), r.readReply(t, fc, 0, max))
+		r.expectException(t, request(fc, 0, 0), modbusone.EcIllegalDataValue, false)
+		if !modbusone.IsOverSizeSupported() {
+			r.expectException(t, request(fc, 0, max+1), modbusone.EcIllegalDataValue, false)
+		}
+	}
+
+	coils := make([]bool, modbusone.FcWriteMultipleCoils.MaxPerPacket())
+	for i := range coils {
+		coils[i] = i%7 == 0
+	}
+	req := writeRequest(t, modbusone.FcWriteMultipleCoils, 100, coils)
+	r.expectReply(t, req, req[:5])
+	r.expectValues(t, modbusone.TableCoils, 100, coils)
+	registers := make([]uint16, modbusone.FcWriteMultipleRegisters.MaxPerPacket())
+	for i := range registers {
+		registers[i] = uint16(i * 3)
+	}
+	req = writeRequest(t, modbusone.FcWriteMultipleRegisters, 100, registers)
+	r.expectReply(t, req, req[:5])
+	r.expectValues(t, modbusone.TableHoldingRegisters, 100, registers)
+
+	for _, fc := range []modbusone.FunctionCode{modbusone.FcWriteMultipleCoils, modbusone.FcWriteMultipleRegisters} {
+		r.expectException(t, request(fc, 0, 0, 0), modbusone.EcIllegalDataValue, false)
+		if !modbusone.IsOverSizeSupported() {
+			// too many values do not fit in a PDU, so only two bytes are sent
+			r.expectException(t, request(fc, 0, fc.MaxPerPacket()+1, 2, 0, 0), modbusone.EcIllegalDataValue, false)
+		}
+	}
+}
+
+func (r *suiteRun) testAddresses(t *testing.T) {
+	end := uint16(suiteMemorySize)
+	for _, fc := range readFunctionCodes {
+		r.expectReply(t, request(fc, end-2, 2), r.readReply(t, fc, end-2, 2))
+		r.expectException(t, request(fc, end-1, 2), modbusone.EcIllegalDataAddress, false)
+		r.expectException(t, request(fc, 0xFFFF, 2), modbusone.EcIllegalDataAddress, false)
+	}
+	r.expectException(t, writeRequest(t, modbusone.FcWriteSingleCoil, end, []bool{true}), modbusone.EcIllegalDataAddress, false)
+	r.expectException(t, writeRequest(t, modbusone.FcWriteSingleRegister, end, []uint16{1}), modbusone.EcIllegalDataAddress, false)
+	r.expectException(t, writeRequest(t, modbusone.FcWriteMultipleCoils, end-1, []bool{true, true}), modbusone.EcIllegalDataAddress, false)
+	r.expectException(t, writeRequest(t, modbusone.FcWriteMultipleRegisters, 0xFFFF, []uint16{1, 2}), modbusone.EcIllegalDataAddress, false)
+}
+
+func (r *suiteRun) testMalformed(t *testing.T) {
+	unsupported := []modbusone.PDU{
+		{0x07},                         // read exception status
+		{0x08, 0x00, 0x00, 0x12, 0x34}, // diagnostics
+		{0x2B, 0x0E, 0x01, 0x00},       // read device identification
+		{0x41, 0x00, 0x00, 0x00, 0x01}, // user defined
+	}
+	for _, req := range unsupported {
+		r.expectException(t, req, modbusone.EcIllegalFunction, len(req) < 2)
+	}
+
+	truncated := []modbusone.PDU{
+		{byte(modbusone.FcReadHoldingRegisters), 0x00},
+		{byte(modbusone.FcReadCoils), 0x00, 0x00, 0x00},
+		{byte(modbusone.FcWriteSingleRegister), 0x00, 0x01, 0x00},
+		{byte(modbusone.FcWriteMultipleRegisters), 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x01},
+	}
+	for _, req := range truncated {
+		r.expectException(t, req, modbusone.EcIllegalDataValue, true)
+	}
+
+	invalid := []modbusone.PDU{
+		// byte count does not match quantity
+		request(modbusone.FcWriteMultipleRegisters, 0, 2, 2, 0x00, 0x01),
+		request(modbusone.FcWriteMultipleCoils, 0, 9, 1, 0xFF),
+		// coil value is not 0xFF00 or 0x0000
+		request(modbusone.FcWriteSingleCoil, 0, 0, 0x12, 0x34),
+	}
+	for _, req := range invalid {
+		r.expectException(t, req, modbusone.EcIllegalDataValue, false)
+	}
+
+	// the server still works
+	r.expectReply(t, request(modbusone.FcReadHoldingRegisters, 0, 1), r.readReply(t, modbusone.FcReadHoldingRegisters, 0, 1))
+}
+
+func (r *suiteRun) testExceptions(t *testing.T) {
+	defer r.handler.setError(nil)
+	errs := []error{
+		modbusone.EcIllegalFunction,
+		modbusone.EcIllegalDataAddress,
+		fmt.Errorf("wrapped: %w", modbusone.EcIllegalDataValue),
+		modbusone.EcServerDeviceFailure,
+		modbusone.EcServerDeviceBusy,
+		modbusone.ErrFcNotSupported,
+		errors.New("unknown handler error"),
+	}
+	reqs := []modbusone.PDU{
+		request(modbusone.FcReadHoldingRegisters, 0, 1),
+		writeRequest(t, modbusone.FcWriteSingleRegister, 0, []uint16{1}),
+		writeRequest(t, modbusone.FcWriteMultipleCoils, 0, []bool{true}),
+	}
+	for _, err := range errs {
+		r.handler.setError(err)
+		for _, req := range reqs {
+			r.expectException(t, req, modbusone.ToExceptionCode(err), false)
+		}
+	}
+}
+
+func (r *suiteRun) testBroadcast(t *testing.T) {
+	values := []uint16{0x1234, 0x5678}
+	r.expectNoReply(t, 0, writeRequest(t, modbusone.FcWriteMultipleRegisters, 200, values))
+	r.expectValues(t, modbusone.TableHoldingRegisters, 200, values)
+	r.expectNoReply(t, 0, writeRequest(t, modbusone.FcWriteSingleCoil, 200, []bool{true}))
+	r.expectValues(t, modbusone.TableCoils, 200, []bool{true})
+	r.expectNoReply(t, 0, request(modbusone.FcReadHoldingRegisters, 200, 2))
+	r.expectNoReply(t, 0, request(modbusone.FcReadHoldingRegisters, 0xFFFF, 2))
+}
+
+func (r *suiteRun) testOtherSlaveID(t *testing.T) {
+	other := r.SlaveID%247 + 1
+	calls := r.handler.callCount()
+	r.expectNoReply(t, other, request(modbusone.FcReadHoldingRegisters, 0, 1))
+	r.expectNoReply(t, other, writeRequest(t, modbusone.FcWriteSingleRegister, 300, []uint16{0xFFFF}))
+	r.expectValues(t, modbusone.TableHoldingRegisters, 300, []uint16{300*7 + 1})
+	if n := r.handler.callCount() - calls; n != 0 {
+		t.Errorf("handler called %v times for requests to slave %v", n, other)
+	}
+}
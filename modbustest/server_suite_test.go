@@ -0,0 +1,40 @@
+package modbustest_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xiegeo/modbusone"
+	"github.com/xiegeo/modbusone/modbustest"
+)
+
+func TestServerSuiteRTU(t *testing.T) {
+	modbustest.ServerSuite{
+		Start: func(t testing.TB, handler modbusone.ProtocolHandler) modbustest.Transport {
+			bus := modbustest.NewVirtualBus(115200, modbustest.BusOption{})
+			t.Cleanup(func() { bus.Close() })
+			server := modbusone.NewRTUServer(bus.Attach("server"), 7)
+			go server.Serve(handler)
+			return modbustest.NewRTUTransport(bus.Attach("client"))
+		},
+		SlaveID:    7,
+		SerialLine: true,
+		Timeout:    200 * time.Millisecond,
+	}.Run(t)
+}
+
+func TestServerSuiteTCP(t *testing.T) {
+	modbustest.ServerSuite{
+		Start: func(t testing.TB, handler modbusone.ProtocolHandler) modbustest.Transport {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			server := modbusone.NewTCPServer(l)
+			t.Cleanup(func() { server.Close() })
+			go server.Serve(handler)
+			return modbustest.NewTCPTransport(l.Addr().String())
+		},
+		Timeout: 200 * time.Millisecond,
+	}.Run(t)
+}
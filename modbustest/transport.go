@@ -0,0 +1,152 @@
+package modbustest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xiegeo/modbusone"
+)
+
+// rtuTransport is a Transport over a serial line.
+type rtuTransport struct {
+	sc     modbusone.SerialContext
+	once   sync.Once
+	frames chan []byte // closed on read error
+}
+
+// NewRTUTransport returns a Transport that sends requests as a client on sc,
+// such as a node of a VirtualBus.
+func NewRTUTransport(sc modbusone.SerialContext) Transport {
+	return &rtuTransport{sc: sc, frames: make(chan []byte, 16)}
+}
+
+// readFrames reads frames until sc returns an error.
+func (r *rtuTransport) readFrames() {
+	defer close(r.frames)
+	pr := modbusone.NewRTUPacketReader(r.sc, true)
+	b := make([]byte, modbusone.MaxRTUSize)
+	for {
+		n, err := pr.Read(b)
+		if err != nil {
+			return
+		}
+		r.frames <- append([]byte(nil), b[:n]...)
+	}
+}
+
+// Transact implements Transport.
+func (r *rtuTransport) Transact(slaveID byte, req modbusone.PDU, timeout time.Duration) (modbusone.PDU, error) {
+	r.once.Do(func() { go r.readFrames() })
+	for len(r.frames) > 0 {
+		<-r.frames // drops late replies of earlier requests
+	}
+	if _, err := r.sc.Write(modbusone.MakeRTU(slaveID, req)); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(r.sc.BytesDelay(len(req)+4) + timeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil, ErrNoReply
+	case b, ok := <-r.frames:
+		if !ok {
+			return nil, io.ErrClosedPipe
+		}
+		rtu := modbusone.RTU(b)
+		p, err := rtu.GetPDU()
+		if err != nil {
+			return nil, fmt.Errorf("bad reply %x: %w", b, err)
+		}
+		if id := rtu.GetSlaveID(); id != slaveID {
+			return p, fmt.Errorf("reply from slave %v to a request for slave %v", id, slaveID)
+		}
+		return p, nil
+	}
+}
+
+// Close implements Transport.
+func (r *rtuTransport) Close() error {
+	return r.sc.Close()
+}
+
+// tcpTransport is a Transport over Modbus TCP.
+type tcpTransport struct {
+	addr          string
+	conn          net.Conn
+	transactionID uint16
+}
+
+// NewTCPTransport returns a Transport that sends requests over Modbus TCP to
+// addr, with slave IDs as unit IDs. It connects again after the server closed
+// the connection.
+func NewTCPTransport(addr string) Transport {
+	return &tcpTransport{addr: addr}
+}
+
+// Transact implements Transport. A closed connection is returned as ErrNoReply.
+func (c *tcpTransport) Transact(slaveID byte, req modbusone.PDU, timeout time.Duration) (modbusone.PDU, error) {
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	}
+	c.transactionID++
+	b := make([]byte, modbusone.MBAPHeaderLength, modbusone.MBAPHeaderLength+len(req))
+	binary.BigEndian.PutUint16(b, c.transactionID)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(req)+1))
+	b[6] = slaveID
+	if _, err := c.conn.Write(append(b, req...)); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	p, err := c.readReply(slaveID)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// readReply reads a reply to the last request, read errors such as a timeout
+// or a closed connection are returned as ErrNoReply.
+func (c *tcpTransport) readReply(slaveID byte) (modbusone.PDU, error) {
+	header := make([]byte, modbusone.MBAPHeaderLength)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoReply, err)
+	}
+	if id := binary.BigEndian.Uint16(header); id != c.transactionID {
+		return nil, fmt.Errorf("reply of transaction %v to transaction %v", id, c.transactionID)
+	}
+	if protocol := binary.BigEndian.Uint16(header[2:]); protocol != 0 {
+		return nil, fmt.Errorf("reply of protocol %v", protocol)
+	}
+	if header[6] != slaveID {
+		return nil, fmt.Errorf("reply from unit %v to a request for unit %v", header[6], slaveID)
+	}
+	l := int(binary.BigEndian.Uint16(header[4:]))
+	if l < 2 || l > modbusone.MaxPDUSize+1 {
+		return nil, fmt.Errorf("reply length %v is out of range", l)
+	}
+	p := make([]byte, l-1)
+	if _, err := io.ReadFull(c.conn, p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoReply, err)
+	}
+	return p, nil
+}
+
+// Close implements Transport.
+func (c *tcpTransport) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
@@ -0,0 +1,294 @@
+// Package modbustest provides utilities for testing Modbus clients, servers,
+// and handlers in-process.
+package modbustest
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiegeo/modbusone"
+)
+
+// BusOption configures a VirtualBus.
+type BusOption struct {
+	_ struct{} // enforces keyed literals
+
+	// Echo delivers every write back to the node that wrote it, as on a 2 wire
+	// RS-485 bus where the receiver is not disabled while sending.
+	Echo bool
+	// TwoWire is returned in the modbusone.Option of nodes, for servers that
+	// see the replies of other servers.
+	TwoWire bool
+	// CPUHiccup is returned in the modbusone.Option of nodes, 0 for
+	// modbusone.DefaultCPUHiccup.
+	CPUHiccup time.Duration
+}
+
+// VirtualBus is a multi-drop serial bus, where any number of clients and
+// servers attach as nodes. Every write is delivered to all other nodes, with
+// each byte readable after the time it takes to send at the baud rate.
+//
+// Writes that start while another node is still sending collide: the
+// overlapping bytes of both are corrupted and Collisions is increased.
+type VirtualBus struct {
+	baudRate   int64
+	option     BusOption
+	collisions int64
+
+	lock      sync.Mutex
+	nodes     []*BusNode
+	busyUntil time.Time // end of the last transmission
+	sender    *BusNode  // the node of the last transmission
+}
+
+// NewVirtualBus creates a VirtualBus running at baudRate.
+func NewVirtualBus(baudRate int64, option BusOption) *VirtualBus {
+	return &VirtualBus{baudRate: baudRate, option: option}
+}
+
+// Collisions returns the number of writes that collided with another write.
+func (b *VirtualBus) Collisions() int64 {
+	return atomic.LoadInt64(&b.collisions)
+}
+
+// Attach adds a node to the bus, name is used for debugging.
+func (b *VirtualBus) Attach(name string) *BusNode {
+	n := &BusNode{
+		bus:    b,
+		name:   name,
+		notify: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	b.lock.Lock()
+	b.nodes = append(b.nodes, n)
+	b.lock.Unlock()
+	return n
+}
+
+// Close closes all nodes.
+func (b *VirtualBus) Close() error {
+	b.lock.Lock()
+	nodes := append([]*BusNode(nil), b.nodes...)
+	b.lock.Unlock()
+	for _, n := range nodes {
+		n.Close()
+	}
+	return nil
+}
+
+// transmit sends data from the node from.
+func (b *VirtualBus) transmit(from *BusNode, data []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	c := chunk{data: append([]byte(nil), data...), start: now, baudRate: b.baudRate}
+	end := c.readyAt(len(data) - 1)
+	var overlap time.Time // end of the collision, zero for none
+	if b.busyUntil.After(now) {
+		if b.sender == from {
+			c.start = b.busyUntil // queued after its own data
+			end = c.readyAt(len(data) - 1)
+		} else {
+			atomic.AddInt64(&b.collisions, 1)
+			overlap = b.busyUntil
+			if end.Before(overlap) {
+				overlap = end
+			}
+			c.corrupt(now, overlap)
+		}
+	}
+	if end.After(b.busyUntil) {
+		b.busyUntil, b.sender = end, from
+	}
+	for _, n := range b.nodes {
+		if n == from && !b.option.Echo {
+			continue
+		}
+		n.receive(c, now, overlap)
+	}
+}
+
+// detach removes n from the bus.
+func (b *VirtualBus) detach(n *BusNode) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i, o := range b.nodes {
+		if o == n {
+			b.nodes = append(b.nodes[:i:i], b.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// chunk is the data of one write, in transit to a node.
+type chunk struct {
+	data     []byte
+	start    time.Time
+	baudRate int64
+}
+
+// readyAt returns when byte i is received.
+func (c *chunk) readyAt(i int) time.Time {
+	return c.start.Add(modbusone.BytesDelay(c.baudRate, i+1))
+}
+
+// corrupt flips the bits of bytes that are sent between from and to.
+func (c *chunk) corrupt(from, to time.Time) {
+	for i := range c.data {
+		sentAt := c.start.Add(modbusone.BytesDelay(c.baudRate, i))
+		if sentAt.Before(to) && c.readyAt(i).After(from) {
+			c.data[i] ^= 0xFF
+		}
+	}
+}
+
+// BusNode is a SerialContext attached to a VirtualBus.
+type BusNode struct {
+	bus   *VirtualBus
+	name  string
+	stats modbusone.Stats
+
+	lock   sync.Mutex
+	queue  []chunk
+	notify chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+var _ modbusone.SerialContextV3 = &BusNode{}
+
+// Name returns the name of the node given to Attach.
+func (n *BusNode) Name() string {
+	return n.name
+}
+
+// receive queues c, and corrupts the bytes of queued chunks that are sent
+// from now to overlap, if overlap is not zero.
+func (n *BusNode) receive(c chunk, now, overlap time.Time) {
+	n.lock.Lock()
+	if !overlap.IsZero() {
+		for i := range n.queue {
+			n.queue[i].corrupt(now, overlap)
+		}
+	}
+	c.data = append([]byte(nil), c.data...)
+	n.queue = append(n.queue, c)
+	n.lock.Unlock()
+	select {
+	case n.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Read blocks until bytes are received, and returns all bytes received so far
+// that fit in p.
+func (n *BusNode) Read(p []byte) (int, error) {
+	for {
+		select {
+		case <-n.closed:
+			return 0, io.EOF
+		default:
+		}
+		read, wait := n.read(p)
+		if read > 0 || len(p) == 0 {
+			return read, nil
+		}
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-n.closed:
+		case <-n.notify:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// read copies received bytes to p, or returns the time to wait for the next
+// byte, 0 if none are in transit.
+func (n *BusNode) read(p []byte) (int, time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	now := time.Now()
+	read := 0
+	for read < len(p) && len(n.queue) > 0 {
+		c := &n.queue[0]
+		i := 0
+		for i < len(c.data) && read < len(p) && !c.readyAt(i).After(now) {
+			p[read] = c.data[i]
+			read++
+			i++
+		}
+		if read == 0 {
+			return 0, c.readyAt(0).Sub(now)
+		}
+		if i < len(c.data) {
+			c.data = c.data[i:]
+			c.start = c.readyAt(i - 1)
+			break
+		}
+		n.queue = n.queue[1:]
+	}
+	return read, 0
+}
+
+// Write sends p on the bus. It returns without waiting for the transmission
+// to end, as a serial port with a write buffer.
+func (n *BusNode) Write(p []byte) (int, error) {
+	select {
+	case <-n.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n.bus.transmit(n, p)
+	return len(p), nil
+}
+
+// Close detaches the node from the bus, and ends pending reads with io.EOF.
+func (n *BusNode) Close() error {
+	n.once.Do(func() {
+		n.bus.detach(n)
+		close(n.closed)
+	})
+	return nil
+}
+
+// MinDelay implements modbusone.SerialContext.
+func (n *BusNode) MinDelay() time.Duration {
+	return modbusone.MinDelay(n.bus.baudRate)
+}
+
+// BytesDelay implements modbusone.SerialContext.
+func (n *BusNode) BytesDelay(b int) time.Duration {
+	return modbusone.BytesDelay(n.bus.baudRate, b)
+}
+
+// Stats implements modbusone.SerialContext.
+func (n *BusNode) Stats() *modbusone.Stats {
+	return &n.stats
+}
+
+// PacketCutoffDuration implements modbusone.SerialContextV2.
+func (n *BusNode) PacketCutoffDuration(b int) time.Duration {
+	hiccup := n.bus.option.CPUHiccup
+	if hiccup == 0 {
+		hiccup = modbusone.DefaultCPUHiccup
+	}
+	return modbusone.PacketCutoffDuration(n.bus.baudRate, b, hiccup)
+}
+
+// GetOption implements modbusone.OptionContext.
+func (n *BusNode) GetOption() modbusone.Option {
+	return modbusone.Option{CPUHiccup: n.bus.option.CPUHiccup, TwoWire: n.bus.option.TwoWire}
+}
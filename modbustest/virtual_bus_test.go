@@ -0,0 +1,102 @@
+package modbustest_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xiegeo/modbusone"
+	"github.com/xiegeo/modbusone/modbustest"
+)
+
+// readFull reads n bytes from r.
+func readFull(t *testing.T, r io.Reader, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	require.NoError(t, err)
+	return b
+}
+
+func TestVirtualBusMultiServer(t *testing.T) {
+	bus := modbustest.NewVirtualBus(19200, modbustest.BusOption{TwoWire: true})
+	defer bus.Close()
+	client := modbusone.NewRTUClient(bus.Attach("client"), 1)
+	handler := modbusone.NewMemoryHandler(10)
+	go client.ServeRTU(modbusone.MultiIDHandler{1: handler, 2: handler, 3: handler})
+	for id := byte(1); id <= 3; id++ {
+		h := modbusone.NewMemoryHandler(10)
+		require.NoError(t, h.WriteRegisters(modbusone.TableHoldingRegisters, 0, []uint16{uint16(id)}))
+		server := modbusone.NewRTUServer(bus.Attach("server"), id)
+		go server.Serve(h)
+	}
+
+	req, err := modbusone.FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		for id := byte(1); id <= 3; id++ {
+			require.NoError(t, modbusone.DoRTUTransaction(client, modbusone.RTUHeader{SlaveID: id, PDU: req}))
+			regs, err := handler.ReadRegisters(modbusone.TableHoldingRegisters, 0, 1)
+			require.NoError(t, err)
+			require.Equal(t, []uint16{uint16(id)}, regs)
+		}
+	}
+	require.Equal(t, int64(0), bus.Collisions())
+}
+
+func TestVirtualBusTiming(t *testing.T) {
+	bus := modbustest.NewVirtualBus(9600, modbustest.BusOption{})
+	defer bus.Close()
+	a, b, c := bus.Attach("a"), bus.Attach("b"), bus.Attach("c")
+	data := []byte("0123456789")
+
+	start := time.Now()
+	_, err := a.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, data, readFull(t, b, len(data)))
+	require.GreaterOrEqual(t, time.Since(start), a.BytesDelay(len(data)), "baud rate timing")
+	require.Equal(t, data, readFull(t, c, len(data)), "delivered to all other nodes")
+
+	_, err = b.Write(data[:5])
+	require.NoError(t, err)
+	_, err = b.Write(data[5:])
+	require.NoError(t, err)
+	require.Equal(t, data, readFull(t, c, len(data)), "consecutive writes of one node do not collide")
+	require.Equal(t, int64(0), bus.Collisions())
+
+	require.NoError(t, c.Close())
+	_, err = c.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+	_, err = c.Write(data)
+	require.Error(t, err)
+}
+
+func TestVirtualBusCollision(t *testing.T) {
+	bus := modbustest.NewVirtualBus(9600, modbustest.BusOption{})
+	defer bus.Close()
+	a, b, c := bus.Attach("a"), bus.Attach("b"), bus.Attach("c")
+	data := []byte("0123456789")
+	_, err := a.Write(data)
+	require.NoError(t, err)
+	_, err = b.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), bus.Collisions())
+
+	got := readFull(t, c, 2*len(data))
+	require.False(t, bytes.Equal(data, got[:len(data)]), "first write corrupted")
+	require.False(t, bytes.Equal(data, got[len(data):]), "second write corrupted")
+}
+
+func TestVirtualBusEcho(t *testing.T) {
+	bus := modbustest.NewVirtualBus(115200, modbustest.BusOption{Echo: true, TwoWire: true})
+	defer bus.Close()
+	a, b := bus.Attach("a"), bus.Attach("b")
+	require.True(t, a.GetOption().TwoWire)
+	data := []byte("echo")
+	_, err := a.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, data, readFull(t, a, len(data)), "echo to the writer")
+	require.Equal(t, data, readFull(t, b, len(data)))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	. "github.com/xiegeo/modbusone"
@@ -107,3 +108,59 @@ func connectMock2wNodes(t *testing.T, slaves int) *mockNodes {
 		multiIDHandler: multiIDHandler,
 	}
 }
+
+func TestMultiIDServer(t *testing.T) {
+	// a 2 wire bus of a client, a server of slave 1 and 2, and a server of slave 3
+	rs := make([]*io.PipeReader, 3)
+	ws := make([]io.Writer, 3)
+	for i := range rs {
+		rs[i], ws[i] = io.Pipe()
+	}
+	wfs := make([]io.Writer, 3)
+	for i := range wfs {
+		wfs[i] = io.MultiWriter(append(append([]io.Writer{}, ws[:i]...), ws[i+1:]...)...)
+	}
+	newSerial := func(i int) *mockSerial {
+		s := newMockSerial(t, fmt.Sprintf("n%v", i), rs[i], wfs[i], rs[i])
+		s.TwoWire = true
+		return s
+	}
+	handlers := MultiIDHandler{1: NewMemoryHandler(10), 2: NewMemoryHandler(10), 3: NewMemoryHandler(10)}
+	multi := NewMultiIDRTUServer(newSerial(1), 1, 2)
+	single := NewRTUServer(newSerial(2), 3)
+	client := NewRTUClient(newSerial(0), 0)
+	client.SetServerProcessingTime(100 * time.Millisecond)
+	defer func() {
+		client.Close()
+		multi.Close()
+		single.Close()
+	}()
+	go multi.ServeRTU(MultiIDHandler{1: handlers[1], 2: handlers[2]})
+	go single.Serve(handlers[3])
+	ch := NewMemoryHandler(10)
+	go client.ServeRTU(MultiIDHandler{0: ch, 1: ch, 2: ch, 3: ch})
+
+	for id := byte(1); id <= 3; id++ {
+		require.NoError(t, handlers[id].(*MemoryHandler).WriteRegisters(TableHoldingRegisters, 0, []uint16{uint16(id)}))
+	}
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	for id := byte(1); id <= 3; id++ {
+		require.NoError(t, DoRTUTransaction(client, RTUHeader{SlaveID: id, PDU: read}), "slave %v", id)
+		got, err := ch.ReadRegisters(TableHoldingRegisters, 0, 1)
+		require.NoError(t, err)
+		require.Equal(t, []uint16{uint16(id)}, got)
+	}
+	require.Error(t, DoRTUTransaction(client, RTUHeader{SlaveID: 4, PDU: read}), "no server of slave 4")
+
+	write, err := FcWriteSingleRegister.MakeRequestHeader(5, 1)
+	require.NoError(t, err)
+	require.NoError(t, ch.WriteRegisters(TableHoldingRegisters, 5, []uint16{55}))
+	require.NoError(t, DoRTUTransaction(client, RTUHeader{SlaveID: 0, PDU: write}))
+	for id := byte(1); id <= 3; id++ {
+		require.Eventually(t, func() bool {
+			got, _ := handlers[id].(*MemoryHandler).ReadRegisters(TableHoldingRegisters, 5, 1)
+			return got[0] == 55
+		}, time.Second, time.Millisecond, "broadcast to slave %v", id)
+	}
+}
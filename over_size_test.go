@@ -3,10 +3,12 @@ package modbusone_test
 import (
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
 	. "github.com/xiegeo/modbusone"
 )
 
@@ -115,3 +117,85 @@ func TestOverSize(t *testing.T) {
 		t.Fatalf("should not time out")
 	}
 }
+
+func TestOverSizeInstance(t *testing.T) {
+	slaveID := byte(0x11)
+	overSize := &OverSize{Support: true, MaxRTU: 512}
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	client := NewRTUClient(newMockSerial(t, "c", r2, w1, w1), slaveID)
+	server := NewRTUServer(newMockSerial(t, "s", r1, w2, w2), slaveID)
+	defer client.Close()
+	client.SetOverSize(overSize)
+	server.SetOverSize(overSize)
+	client.SetSlaveProfile(slaveID, SlaveProfile{MaxPDUSize: 500})
+	ch := NewMemoryHandler(300)
+	sh := NewMemoryHandler(300)
+	go client.Serve(ch)
+	go server.Serve(sh)
+
+	values := make([]uint16, 200)
+	for i := range values {
+		values[i] = uint16(i * 3)
+	}
+	require.NoError(t, sh.WriteRegisters(TableHoldingRegisters, 0, values))
+	reqs, err := client.MakePDURequestHeaders(slaveID, FcReadHoldingRegisters, 0, 200, nil)
+	require.NoError(t, err)
+	require.Len(t, reqs, 1, "over sized to the slave profile")
+	require.NoError(t, client.DoTransaction(reqs[0]))
+	got, err := ch.ReadRegisters(TableHoldingRegisters, 0, 200)
+	require.NoError(t, err)
+	require.Equal(t, values, got)
+
+	require.NoError(t, ch.WriteRegisters(TableHoldingRegisters, 0, make([]uint16, 200)))
+	reqs, err = client.MakePDURequestHeaders(slaveID, FcWriteMultipleRegisters, 0, 200, nil)
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	require.NoError(t, client.DoTransaction(reqs[0]))
+	got, err = sh.ReadRegisters(TableHoldingRegisters, 0, 200)
+	require.NoError(t, err)
+	require.Equal(t, make([]uint16, 200), got)
+
+	require.False(t, IsOverSizeSupported(), "global settings are not changed")
+	client.SetSlaveProfile(slaveID, SlaveProfile{})
+	reqs, err = client.MakePDURequestHeaders(slaveID, FcReadHoldingRegisters, 0, 200, nil)
+	require.NoError(t, err)
+	require.Len(t, reqs, 2, "other slaves are not over sized")
+}
+
+func TestOverSizeSlaveProfileLimit(t *testing.T) {
+	c := NewRTUClient(NewSerialContext(nil, 9600), 1)
+	c.SetSlaveProfile(1, SlaveProfile{MaxPDUSize: 500})
+	require.Equal(t, MaxPDUSize, c.GetSlaveProfile(1).MaxPDUSize, "not over sized by default")
+	c.SetOverSize(&OverSize{Support: true, MaxRTU: 300})
+	require.Equal(t, 297, c.GetSlaveProfile(1).MaxPDUSize, "limited by the client")
+}
+
+func TestOverSizeTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := NewTCPServer(l)
+	defer server.Close()
+	overSize := &OverSize{Support: true, MaxRTU: 512}
+	server.SetOverSize(overSize)
+	sh := NewMemoryHandler(300)
+	go server.Serve(sh)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	client := NewTCPClient(conn, 1)
+	defer client.Close()
+	client.SetOverSize(overSize)
+	ch := NewMemoryHandler(300)
+	go client.Serve(ch)
+
+	values := make([]uint16, 200)
+	for i := range values {
+		values[i] = uint16(i)
+	}
+	require.NoError(t, sh.WriteRegisters(TableHoldingRegisters, 0, values))
+	pdu := PDU{byte(FcReadHoldingRegisters), 0, 0, 0, 200}
+	require.NoError(t, client.DoTransaction(pdu))
+	got, err := ch.ReadRegisters(TableHoldingRegisters, 0, 200)
+	require.NoError(t, err)
+	require.Equal(t, values, got)
+}
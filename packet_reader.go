@@ -20,12 +20,15 @@ type PacketReader interface {
 type rtuPacketReader struct {
 	r             SerialContext // the underlining reader
 	isClient      bool
-	slaveID       byte
+	slaveIDs      atomic.Pointer[slaveIDSet] // of the server, for TwoWire
 	bidirectional bool
 	option        Option
 	last          []byte
 	lastRTU       RTU
 	lastReadAt    time.Time
+	logger        instanceLogger
+	overSize      instanceOverSize
+	replySize     atomic.Int64 // size of the next over sized reply, 0 if unknown
 }
 
 // NewRTUPacketReader create a Reader that attempt to read full packets.
@@ -38,27 +41,59 @@ func NewRTUPacketReader(r SerialContext, isClient bool) PacketReader {
 	if o, ok := r.(OptionContext); ok {
 		option = o.GetOption()
 	}
-	return &rtuPacketReader{r: r, isClient: isClient, option: option}
+	pr := &rtuPacketReader{r: r, isClient: isClient, option: option}
+	pr.slaveIDs.Store(newSlaveIDSet(0))
+	pr.overSize.set(option.OverSize)
+	return pr
 }
 
 // NewRTUPacketReader2 create a Reader that attempt to read full packets.
 // Set TwoWire to true in r.Option for 2 wire and false for 4 wire.
 func NewRTUPacketReader2(r SerialContextV3, isClient bool, slaveID byte) PacketReader {
-	return &rtuPacketReader{r: r, isClient: isClient, slaveID: slaveID, option: r.GetOption()}
+	return newRTUPacketReaderIDs(r, isClient, newSlaveIDSet(slaveID))
+}
+
+// NewRTUMultiIDPacketReader is NewRTUPacketReader2 for a server of all slaveIDs.
+func NewRTUMultiIDPacketReader(r SerialContextV3, slaveIDs ...byte) PacketReader {
+	return newRTUPacketReaderIDs(r, false, newSlaveIDSet(slaveIDs...))
+}
+
+func newRTUPacketReaderIDs(r SerialContextV3, isClient bool, slaveIDs *slaveIDSet) *rtuPacketReader {
+	pr := &rtuPacketReader{r: r, isClient: isClient, option: r.GetOption()}
+	pr.slaveIDs.Store(slaveIDs)
+	pr.overSize.set(pr.option.OverSize)
+	return pr
 }
 
 // NewRTUBidirectionalPacketReader create a Reader that attempt to read full packets
 // that comes from either server or client.
 func NewRTUBidirectionalPacketReader(r SerialContext) PacketReader {
-	return &rtuPacketReader{r: r, bidirectional: true}
+	pr := &rtuPacketReader{r: r, bidirectional: true}
+	pr.slaveIDs.Store(newSlaveIDSet(0))
+	if o, ok := r.(OptionContext); ok {
+		pr.overSize.set(o.GetOption().OverSize)
+	}
+	return pr
 }
 
 // PacketReaderFace satisfies PacketReader
 func (s *rtuPacketReader) PacketReaderFace() {}
 
+// setOverSize sets the OverSize of the reader, nil for the global settings.
+func (s *rtuPacketReader) setOverSize(o *OverSize) {
+	s.overSize.set(o)
+}
+
+// expectReply sets the size of the next reply read by a client, for over
+// sized replies that do not encode their size. 0 to read by the header.
+func (s *rtuPacketReader) expectReply(size int) {
+	s.replySize.Store(int64(size))
+}
+
 func (s *rtuPacketReader) Read(p []byte) (int, error) {
 	for {
 		atomic.AddInt64(&s.r.Stats().ReadPackets, 1)
+		o := s.overSize.get()
 		expected := smallestRTUSize
 		read := 0
 		for read < expected {
@@ -76,7 +111,8 @@ func (s *rtuPacketReader) Read(p []byte) (int, error) {
 					cutoffDuration := GetPacketCutoffDurationFromSerialContext(s.r, n)
 					readDuration := now.Sub(s.lastReadAt)
 					if readDuration > cutoffDuration {
-						debugf("RTUPacketReader read %v bytes, took:%v > %v, reset packet", n, readDuration, cutoffDuration)
+						s.logger.log(levelWarn, "RTUPacketReader reset packet after cutoff", "size", n,
+							"took", readDuration, "cutoff", cutoffDuration, "bytes", hexBytes(p[:read]))
 						s.last = append(s.last[:0], p[read:read+n]...)
 						read = 0
 						expected = smallestRTUSize
@@ -88,8 +124,8 @@ func (s *rtuPacketReader) Read(p []byte) (int, error) {
 				if n > 0 {
 					s.lastReadAt = now
 				}
-				if n > 0 || err != nil {
-					debugf("RTUPacketReader read (%v+%v)/%v err:%v, expected %v", read, n, len(p), err, expected)
+				if (n > 0 || err != nil) && s.logger.enabled() {
+					s.logger.debug("RTUPacketReader read", "read", read, "size", n, "buffer", len(p), "expected", expected, "error", err)
 				}
 				read += n
 				if err != nil || read == len(p) {
@@ -104,21 +140,31 @@ func (s *rtuPacketReader) Read(p []byte) (int, error) {
 			}
 			// lets see if there is more to read
 			if s.bidirectional {
-				expected = GetRTUBidirectionalSizeFromHeader(p[:read])
-				debugf("GetRTUBidirectionalSizeFromHeader new expected size:%v data:%x", expected, p[:read])
+				expected = o.rtuBidirectionalSizeFromHeader(p[:read])
+				if s.logger.enabled() {
+					s.logger.debug("GetRTUBidirectionalSizeFromHeader new expected size", "expected", expected, "bytes", hexBytes(p[:read]))
+				}
 			} else if s.option.TwoWire {
-				expected = GetRTUSizeFromHeader2(p[:read], s.isClient, s.slaveID, s.lastRTU)
-				debugf("GetRTUSizeFromHeader2 new expected size:%v isClient:%v data:%x", expected, s.isClient, p[:read])
+				own := s.slaveIDs.Load().has(p[0])
+				expected = o.rtuSizeFromHeaderOwn(p[:read], s.isClient, own, s.lastRTU)
+				if s.logger.enabled() {
+					s.logger.debug("GetRTUSizeFromHeader2 new expected size", "expected", expected, "is_client", s.isClient, "bytes", hexBytes(p[:read]))
+				}
 			} else {
-				expected = GetRTUSizeFromHeader(p[:read], s.isClient)
-				debugf("GetRTUSizeFromHeader new expected size:%v isClient:%v data:%x", expected, s.isClient, p[:read])
+				expected = o.rtuSizeFromHeader(p[:read], s.isClient)
+				if s.logger.enabled() {
+					s.logger.debug("GetRTUSizeFromHeader new expected size", "expected", expected, "is_client", s.isClient, "bytes", hexBytes(p[:read]))
+				}
+			}
+			if s.isClient && read >= 2 && FunctionCode(p[1]).Valid() {
+				expected = max(expected, int(s.replySize.Load()))
 			}
 		}
 		if read > expected {
 			if crc.Validate(p[:expected]) {
 				atomic.AddInt64(&s.r.Stats().LongReadWarnings, 1)
 				s.last = append(s.last[:0], p[expected:read]...)
-				debugf("long read warning expected:%v read:%v data:%x", expected, read, p[:read])
+				s.logger.log(levelWarn, "RTUPacketReader long read", "expected", expected, "read", read, "bytes", hexBytes(p[:read]))
 				s.lastRTU = append(s.lastRTU[:0], p[:expected]...)
 				return expected, nil
 			}
@@ -126,13 +172,14 @@ func (s *rtuPacketReader) Read(p []byte) (int, error) {
 		if crc.Validate(p[:read]) {
 			if read != expected {
 				atomic.AddInt64(&s.r.Stats().FormateWarnings, 1)
-				debugf("formate warning expected:%v read:%v data:%x", expected, read, p[:read])
+				s.logger.log(levelWarn, "RTUPacketReader formate warning", "expected", expected, "read", read, "bytes", hexBytes(p[:read]))
 			}
 			s.lastRTU = append(s.lastRTU[:0], p[:read]...)
 			return read, nil
 		} else {
 			atomic.AddInt64(&s.r.Stats().CrcErrors, 1)
-			debugf("crc error expected:%v read:%v data:%x", expected, read, p[:read])
+			s.logger.log(levelWarn, "RTUPacketReader crc error", "expected", expected, "read", read,
+				"error_class", errorClass(ErrorCrc), "bytes", hexBytes(p[:read]))
 		}
 	}
 }
@@ -141,6 +188,10 @@ func (s *rtuPacketReader) Read(p []byte) (int, error) {
 // PDU header, if not enough info is in the header, then it returns the shortest possible.
 // isClient is true if a client/master is reading the packet.
 func GetPDUSizeFromHeader(header []byte, isClient bool) int {
+	return defaultOverSize().pduSizeFromHeader(header, isClient)
+}
+
+func (o OverSize) pduSizeFromHeader(header []byte, isClient bool) int {
 	if len(header) < 2 {
 		return 2
 	}
@@ -164,9 +215,7 @@ func GetPDUSizeFromHeader(header []byte, isClient bool) int {
 	if len(header) < 6 {
 		return 6
 	}
-	OverSizeLock.RLock()
-	defer OverSizeLock.RUnlock()
-	if OverSizeSupport {
+	if o.Support {
 		n := int(header[3])*256 + int(header[4])
 		var overSize int
 		if f.IsUint16() {
@@ -174,7 +223,7 @@ func GetPDUSizeFromHeader(header []byte, isClient bool) int {
 		} else {
 			overSize = 6 + (n-1)/8 + 1
 		}
-		return min(getMaxPDUSize(), overSize)
+		return min(o.maxPDUSize(), overSize)
 	}
 	return 6 + int(header[5])
 }
@@ -185,13 +234,17 @@ func GetPDUSizeFromHeader(header []byte, isClient bool) int {
 // This function only works properly for 1 to 1 communications.
 // Please use GetRTUSizeFromHeader2 for multi slave/server on the same serial port.
 func GetRTUSizeFromHeader(header []byte, isClient bool) int {
+	return defaultOverSize().rtuSizeFromHeader(header, isClient)
+}
+
+func (o OverSize) rtuSizeFromHeader(header []byte, isClient bool) int {
 	if len(header) < 3 {
 		return 3
 	}
 	if header[0] == 0 {
-		return GetPDUSizeFromHeader(header[1:], false) + 3
+		return o.pduSizeFromHeader(header[1:], false) + 3
 	}
-	return GetPDUSizeFromHeader(header[1:], isClient) + 3
+	return o.pduSizeFromHeader(header[1:], isClient) + 3
 }
 
 // GetRTUSizeFromHeader2 returns the expected sized of a RTU packet with the given
@@ -204,15 +257,24 @@ func GetRTUSizeFromHeader(header []byte, isClient bool) int {
 // If there are multiple slave/server on the same serial port, lastPacket is used to
 // disambiguate between requests and replies when necessary.
 func GetRTUSizeFromHeader2(header []byte, isClient bool, slaveID byte, lastPacket RTU) int {
+	return defaultOverSize().rtuSizeFromHeader2(header, isClient, slaveID, lastPacket)
+}
+
+func (o OverSize) rtuSizeFromHeader2(header []byte, isClient bool, slaveID byte, lastPacket RTU) int {
+	return o.rtuSizeFromHeaderOwn(header, isClient, len(header) > 0 && header[0] == slaveID, lastPacket)
+}
+
+// rtuSizeFromHeaderOwn is GetRTUSizeFromHeader2, where own is true if the
+// slave ID of header is one of the server.
+func (o OverSize) rtuSizeFromHeaderOwn(header []byte, isClient, own bool, lastPacket RTU) int {
 	if len(header) < 3 {
 		return 3
 	}
-	packetId := header[0]
-	if isClient || packetId == slaveID {
-		return GetRTUSizeFromHeader(header, isClient)
+	if isClient || own {
+		return o.rtuSizeFromHeader(header, isClient)
 	}
 
-	return GetRTUBidirectionalSizeFromHeader2(header, lastPacket)
+	return o.rtuBidirectionalSizeFromHeader2(header, lastPacket)
 }
 
 // GetRTUBidirectionalSizeFromHeader is like GetRTUSizeFromHeader, except for any direction
@@ -221,8 +283,12 @@ func GetRTUSizeFromHeader2(header []byte, isClient bool, slaveID byte, lastPacke
 // There is currently a slight possibly that a long pack happens to crc correctly to a shorter packet,
 // please use GetRTUBidirectionalSizeFromHeader2 for increased safety
 func GetRTUBidirectionalSizeFromHeader(header []byte) int {
-	s := GetRTUSizeFromHeader(header, false)
-	l := GetRTUSizeFromHeader(header, true)
+	return defaultOverSize().rtuBidirectionalSizeFromHeader(header)
+}
+
+func (o OverSize) rtuBidirectionalSizeFromHeader(header []byte) int {
+	s := o.rtuSizeFromHeader(header, false)
+	l := o.rtuSizeFromHeader(header, true)
 	if s == l {
 		return s
 	}
@@ -242,26 +308,30 @@ func GetRTUBidirectionalSizeFromHeader(header []byte) int {
 }
 
 func GetRTUBidirectionalSizeFromHeader2(header []byte, lastPacket RTU) int {
+	return defaultOverSize().rtuBidirectionalSizeFromHeader2(header, lastPacket)
+}
+
+func (o OverSize) rtuBidirectionalSizeFromHeader2(header []byte, lastPacket RTU) int {
 	if len(lastPacket) == 0 {
-		return getRTUBidirectionalSizeFromHeaderWithPrefer(header, false)
+		return o.rtuBidirectionalSizeFromHeaderWithPrefer(header, false)
 	}
 
-	reqLen := GetRTUSizeFromHeader(lastPacket, false)
+	reqLen := o.rtuSizeFromHeader(lastPacket, false)
 	if len(lastPacket) == reqLen {
-		return getRTUBidirectionalSizeFromHeaderWithPrefer(header, false)
+		return o.rtuBidirectionalSizeFromHeaderWithPrefer(header, false)
 	}
-	return getRTUBidirectionalSizeFromHeaderWithPrefer(header, true)
+	return o.rtuBidirectionalSizeFromHeaderWithPrefer(header, true)
 }
 
-func getRTUBidirectionalSizeFromHeaderWithPrefer(header []byte, isClient bool) int {
-	size := GetRTUSizeFromHeader(header, isClient)
+func (o OverSize) rtuBidirectionalSizeFromHeaderWithPrefer(header []byte, isClient bool) int {
+	size := o.rtuSizeFromHeader(header, isClient)
 	if size > len(header) {
 		return size
 	}
 	if crc.Validate(header[:size]) {
 		return size
 	}
-	size2 := GetRTUSizeFromHeader(header, !isClient)
+	size2 := o.rtuSizeFromHeader(header, !isClient)
 	if size2 > len(header) {
 		return size2
 	}
@@ -52,10 +52,10 @@ func FuzzReaderDrop(f *testing.F) {
 				Option:   o,
 			},
 			isClient:      isClient,
-			slaveID:       slaveID,
 			bidirectional: bidirectional,
 			option:        o,
 		}
+		r.slaveIDs.Store(newSlaveIDSet(slaveID))
 
 		finalData := crc.Sum([]byte{slaveID, 0x01, 0x00, 0x13, 0x00, 0x25}) // request data
 		if isClient {
@@ -0,0 +1,435 @@
+package modbusone
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xiegeo/modbusone/crc"
+)
+
+// Link types used in pcapng captures.
+const (
+	// LinkTypeEthernet is used for TCP captures, with synthesized Ethernet, IPv4,
+	// and TCP headers.
+	LinkTypeEthernet uint16 = 1
+	// LinkTypeModbusRTU is used for RTU frames (including crc), it is the first
+	// user link type (DLT_USER0), configure Wireshark to decode it as mbrtu.
+	LinkTypeModbusRTU uint16 = 147
+)
+
+// pcapng block types and options.
+const (
+	pcapngSectionHeader      = 0x0A0D0D0A
+	pcapngInterfaceDesc      = 0x00000001
+	pcapngSimplePacket       = 0x00000003
+	pcapngEnhancedPacket     = 0x00000006
+	pcapngByteOrderMagic     = 0x1A2B3C4D
+	pcapngOptEnd             = 0
+	pcapngOptIfName          = 2
+	pcapngOptIfTsresol       = 9
+	pcapngOptEpbFlags        = 2
+	pcapngFlagInbound        = 1
+	pcapngFlagOutbound       = 2
+	pcapngDirectionFlagsMask = 3
+)
+
+// PcapngWriter writes frames with timestamps and directions to a pcapng capture,
+// readable by Wireshark and PcapngReader. It is safe for concurrent use.
+type PcapngWriter struct {
+	lock       sync.Mutex
+	w          io.Writer
+	interfaces uint32
+}
+
+// NewPcapngWriter starts a pcapng capture on w.
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+	p := &PcapngWriter{w: w}
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body, pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:], 1) // major version
+	binary.LittleEndian.PutUint16(body[6:], 0) // minor version
+	binary.LittleEndian.PutUint64(body[8:], ^uint64(0))
+	return p, p.writeBlock(pcapngSectionHeader, body)
+}
+
+// writeBlock writes a block with body, which must be padded to 32 bits.
+func (p *PcapngWriter) writeBlock(blockType uint32, body []byte) error {
+	b := make([]byte, 0, len(body)+12)
+	b = binary.LittleEndian.AppendUint32(b, blockType)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(body)+12))
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(body)+12))
+	_, err := p.w.Write(b)
+	return err
+}
+
+// appendOption appends a pcapng option with padding.
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return appendPadding(b, len(value))
+}
+
+func appendPadding(b []byte, n int) []byte {
+	for ; n%4 != 0; n++ {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// AddInterface adds an interface of linkType with name to the capture, and
+// returns its id to be used by WritePacket.
+func (p *PcapngWriter) AddInterface(linkType uint16, name string) (uint32, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	body := binary.LittleEndian.AppendUint16(nil, linkType)
+	body = binary.LittleEndian.AppendUint16(body, 0) // reserved
+	body = binary.LittleEndian.AppendUint32(body, 0) // no snap length limit
+	body = appendOption(body, pcapngOptIfName, []byte(name))
+	body = appendOption(body, pcapngOptIfTsresol, []byte{6}) // microseconds
+	body = appendOption(body, pcapngOptEnd, nil)
+	if err := p.writeBlock(pcapngInterfaceDesc, body); err != nil {
+		return 0, err
+	}
+	p.interfaces++
+	return p.interfaces - 1, nil
+}
+
+// WritePacket writes a packet of interface id, captured at t. Inbound is true
+// for data read, and false for data written.
+func (p *PcapngWriter) WritePacket(id uint32, t time.Time, inbound bool, data []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if id >= p.interfaces {
+		return errors.New("pcapng interface is not added")
+	}
+	ts := uint64(t.UnixMicro())
+	body := binary.LittleEndian.AppendUint32(nil, id)
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = append(body, data...)
+	body = appendPadding(body, len(data))
+	flags := uint32(pcapngFlagOutbound)
+	if inbound {
+		flags = pcapngFlagInbound
+	}
+	body = appendOption(body, pcapngOptEpbFlags, binary.LittleEndian.AppendUint32(nil, flags))
+	body = appendOption(body, pcapngOptEnd, nil)
+	return p.writeBlock(pcapngEnhancedPacket, body)
+}
+
+// captureSerial captures the frames of a SerialContext.
+type captureSerial struct {
+	SerialContext
+	w        *PcapngWriter
+	id       uint32
+	overSize instanceOverSize
+
+	lock        sync.Mutex // protects fields below
+	pending     []byte     // bytes read that are not yet a complete frame
+	pendingTime time.Time  // time of the first pending byte
+	lastReadAt  time.Time
+	lastFrame   RTU // last frame read or written, to tell requests from replies
+}
+
+// capturePacketSerial is a captureSerial over a PacketReader.
+type capturePacketSerial struct {
+	*captureSerial
+}
+
+// PacketReaderFace satisfies PacketReader.
+func (capturePacketSerial) PacketReaderFace() {}
+
+// NewCaptureSerialContext returns a SerialContext that writes every frame read
+// from and written to sc to w as a LinkTypeModbusRTU interface with name.
+//
+// Reads are split into frames by the sizes in their headers, in either
+// direction, and each frame is written to the capture once it is complete.
+// Bytes that do not make a frame are written when the next read is after
+// PacketCutoffDuration, on Write, or on Close. If sc is a PacketReader, so is
+// the returned SerialContext, with each read as a frame.
+func NewCaptureSerialContext(sc SerialContext, w *PcapngWriter, name string) (SerialContext, error) {
+	id, err := w.AddInterface(LinkTypeModbusRTU, name)
+	if err != nil {
+		return nil, err
+	}
+	c := &captureSerial{SerialContext: sc, w: w, id: id}
+	if o, ok := sc.(OptionContext); ok {
+		c.overSize.set(o.GetOption().OverSize)
+	}
+	if _, ok := sc.(PacketReader); ok {
+		return capturePacketSerial{c}, nil
+	}
+	return c, nil
+}
+
+func (c *captureSerial) Read(b []byte) (int, error) {
+	n, err := c.SerialContext.Read(b)
+	if n <= 0 {
+		return n, err
+	}
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keepErr := func(werr error) {
+		if werr != nil && err == nil {
+			err = werr
+		}
+	}
+	_, isPacketReader := c.SerialContext.(PacketReader)
+	if !isPacketReader && now.Sub(c.lastReadAt) > GetPacketCutoffDurationFromSerialContext(c.SerialContext, n) {
+		keepErr(c.flushLocked())
+	}
+	if len(c.pending) == 0 {
+		c.pendingTime = now
+	}
+	c.pending = append(c.pending, b[:n]...)
+	c.lastReadAt = now
+	if isPacketReader {
+		keepErr(c.flushLocked())
+		return n, err
+	}
+	// frames can follow each other within PacketCutoffDuration, write each
+	// one as soon as it is complete.
+	o := c.overSize.get()
+	for len(c.pending) >= smallestRTUSize {
+		size := o.rtuBidirectionalSizeFromHeader2(c.pending, c.lastFrame)
+		if size > len(c.pending) || !crc.Validate(c.pending[:size]) {
+			break
+		}
+		keepErr(c.writeFrameLocked(size))
+		c.pendingTime = now
+	}
+	return n, err
+}
+
+// flushLocked writes all pending bytes as an inbound frame.
+func (c *captureSerial) flushLocked() error {
+	return c.writeFrameLocked(len(c.pending))
+}
+
+// writeFrameLocked writes the first size pending bytes as an inbound frame.
+func (c *captureSerial) writeFrameLocked(size int) error {
+	if size == 0 {
+		return nil
+	}
+	err := c.w.WritePacket(c.id, c.pendingTime, true, c.pending[:size])
+	c.lastFrame = append(c.lastFrame[:0], c.pending[:size]...)
+	c.pending = c.pending[:copy(c.pending, c.pending[size:])]
+	return err
+}
+
+func (c *captureSerial) Write(b []byte) (int, error) {
+	now := time.Now()
+	// lock while writing, so that a reply read during the write is captured
+	// after the request.
+	c.lock.Lock()
+	err := c.flushLocked()
+	n, werr := c.SerialContext.Write(b)
+	if n > 0 {
+		if cerr := c.w.WritePacket(c.id, now, false, b[:n]); err == nil {
+			err = cerr
+		}
+		c.lastFrame = append(c.lastFrame[:0], b[:n]...)
+	}
+	c.lock.Unlock()
+	if werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// Close writes pending frames and closes the underlying SerialContext.
+func (c *captureSerial) Close() error {
+	c.lock.Lock()
+	err := c.flushLocked()
+	c.lock.Unlock()
+	if cerr := c.SerialContext.Close(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// PacketCutoffDuration implements SerialContextV2.
+func (c *captureSerial) PacketCutoffDuration(n int) time.Duration {
+	return GetPacketCutoffDurationFromSerialContext(c.SerialContext, n)
+}
+
+// GetOption implements OptionContext.
+func (c *captureSerial) GetOption() Option {
+	if o, ok := c.SerialContext.(OptionContext); ok {
+		return o.GetOption()
+	}
+	return Option{}
+}
+
+// captureConn captures a TCP connection as Ethernet frames.
+type captureConn struct {
+	net.Conn
+	w  *PcapngWriter
+	id uint32
+
+	lock                  sync.Mutex // protects fields below
+	localIP, remoteIP     net.IP
+	localPort, remotePort uint16
+	localSeq, remoteSeq   uint32
+	ipID                  uint16
+}
+
+// NewCaptureConn returns a net.Conn that writes all data read from and written
+// to conn to w, as TCP segments in synthesized Ethernet frames on an interface
+// with name. Addresses that are not IPv4 are replaced by 127.0.0.1 and 127.0.0.2,
+// and unknown ports by 502.
+func NewCaptureConn(conn net.Conn, w *PcapngWriter, name string) (net.Conn, error) {
+	id, err := w.AddInterface(LinkTypeEthernet, name)
+	if err != nil {
+		return nil, err
+	}
+	c := &captureConn{Conn: conn, w: w, id: id, localSeq: 1, remoteSeq: 1}
+	c.localIP, c.localPort = captureAddr(conn.LocalAddr(), net.IPv4(127, 0, 0, 1))
+	c.remoteIP, c.remotePort = captureAddr(conn.RemoteAddr(), net.IPv4(127, 0, 0, 2))
+	return c, nil
+}
+
+func captureAddr(addr net.Addr, defaultIP net.IP) (net.IP, uint16) {
+	ip, port := defaultIP.To4(), uint16(TCPPort)
+	if a, ok := addr.(*net.TCPAddr); ok {
+		if ip4 := a.IP.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if a.Port != 0 {
+			port = uint16(a.Port)
+		}
+	}
+	return ip, port
+}
+
+func (c *captureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if cerr := c.capture(true, b[:n]); err == nil {
+			err = cerr
+		}
+	}
+	return n, err
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		if cerr := c.capture(false, b[:n]); err == nil {
+			err = cerr
+		}
+	}
+	return n, err
+}
+
+// capture writes payload as a TCP segment.
+func (c *captureConn) capture(inbound bool, payload []byte) error {
+	now := time.Now()
+	c.lock.Lock()
+	c.ipID++
+	var frame []byte
+	if inbound {
+		frame = tcpFrame(c.remoteIP, c.localIP, c.remotePort, c.localPort, c.remoteSeq, c.localSeq, c.ipID, payload)
+		c.remoteSeq += uint32(len(payload))
+	} else {
+		frame = tcpFrame(c.localIP, c.remoteIP, c.localPort, c.remotePort, c.localSeq, c.remoteSeq, c.ipID, payload)
+		c.localSeq += uint32(len(payload))
+	}
+	c.lock.Unlock()
+	return c.w.WritePacket(c.id, now, inbound, frame)
+}
+
+// tcpFrame synthesizes an Ethernet frame of an IPv4 TCP segment with payload.
+func tcpFrame(srcIP, dstIP net.IP, srcPort, dstPort uint16, seq, ack uint32, ipID uint16, payload []byte) []byte {
+	const ethLen, ipLen, tcpLen = 14, 20, 20
+	b := make([]byte, ethLen+ipLen+tcpLen, ethLen+ipLen+tcpLen+len(payload))
+	// Ethernet, with locally administered MAC addresses derived from IPs.
+	copy(b[0:], []byte{0x02, 0, dstIP[0], dstIP[1], dstIP[2], dstIP[3]})
+	copy(b[6:], []byte{0x02, 0, srcIP[0], srcIP[1], srcIP[2], srcIP[3]})
+	binary.BigEndian.PutUint16(b[12:], 0x0800)
+	// IPv4
+	ip := b[ethLen : ethLen+ipLen]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(ipLen+tcpLen+len(payload)))
+	binary.BigEndian.PutUint16(ip[4:], ipID)
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64                                 // TTL
+	ip[9] = 6                                  // TCP
+	copy(ip[12:], srcIP)
+	copy(ip[16:], dstIP)
+	binary.BigEndian.PutUint16(ip[10:], internetChecksum(0, ip))
+	// TCP
+	tcp := b[ethLen+ipLen:]
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = tcpLen / 4 << 4
+	tcp[13] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(tcp[14:], 0xFFFF)
+	b = append(b, payload...)
+	tcp = b[ethLen+ipLen:]
+	pseudo := make([]byte, 0, 12)
+	pseudo = append(pseudo, srcIP...)
+	pseudo = append(pseudo, dstIP...)
+	pseudo = append(pseudo, 0, 6)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:], internetChecksum(internetSum(0, pseudo), tcp))
+	return b
+}
+
+// internetSum adds b to the ones' complement sum.
+func internetSum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// internetChecksum returns the checksum of b with a starting sum.
+func internetChecksum(sum uint32, b []byte) uint16 {
+	sum = internetSum(sum, b)
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
+
+// captureListener wraps accepted connections with captureConn.
+type captureListener struct {
+	net.Listener
+	w    *PcapngWriter
+	name string
+}
+
+// NewCaptureListener returns a net.Listener, such as for NewTCPServer, that
+// captures accepted connections with NewCaptureConn, on interfaces named
+// name followed by the remote address.
+func NewCaptureListener(l net.Listener, w *PcapngWriter, name string) net.Listener {
+	return &captureListener{Listener: l, w: w, name: name}
+}
+
+func (l *captureListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCaptureConn(conn, l.w, l.name+" "+conn.RemoteAddr().String())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,287 @@
+package modbusone
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// CapturedFrame is a Modbus frame read from a pcapng capture.
+type CapturedFrame struct {
+	_ struct{} // enforces keyed literals
+
+	Time      time.Time
+	Interface string // name of the capture interface
+	LinkType  uint16
+	Inbound   bool // true if read by the capturing side, false if written
+	SlaveID   byte
+	PDU       PDU // nil if the frame is not valid
+	RTU       RTU // the full frame for LinkTypeModbusRTU, nil for TCP
+	Err       error
+}
+
+// PcapngReader reads Modbus frames from pcapng captures, such as those written
+// by PcapngWriter. RTU frames are read from LinkTypeModbusRTU interfaces, and
+// Modbus TCP ADUs are reassembled from TCP over IPv4 in LinkTypeEthernet
+// interfaces.
+type PcapngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterface
+	pending    []CapturedFrame
+	streams    map[tcpStream][]byte
+}
+
+type pcapngInterface struct {
+	linkType uint16
+	name     string
+	tsUnit   time.Duration // duration of a timestamp unit, 0 for units smaller than 1ns
+	tsPerSec uint64
+}
+
+// tcpStream identifies one direction of a TCP connection.
+type tcpStream struct {
+	iface            uint32
+	src, dst         [4]byte
+	srcPort, dstPort uint16
+}
+
+// NewPcapngReader creates a PcapngReader.
+func NewPcapngReader(r io.Reader) *PcapngReader {
+	return &PcapngReader{r: r, streams: make(map[tcpStream][]byte)}
+}
+
+// Next returns the next frame, or io.EOF at the end of the capture.
+func (p *PcapngReader) Next() (CapturedFrame, error) {
+	for len(p.pending) == 0 {
+		if err := p.readBlock(); err != nil {
+			return CapturedFrame{}, err
+		}
+	}
+	f := p.pending[0]
+	p.pending = p.pending[1:]
+	return f, nil
+}
+
+// readBlock reads one block, adding any frames found to pending.
+func (p *PcapngReader) readBlock() error {
+	var head [12]byte
+	if _, err := io.ReadFull(p.r, head[:8]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(head[:]) == pcapngSectionHeader {
+		if _, err := io.ReadFull(p.r, head[8:12]); err != nil {
+			return unexpectedEOF(err)
+		}
+		switch binary.LittleEndian.Uint32(head[8:]) {
+		case pcapngByteOrderMagic:
+			p.order = binary.LittleEndian
+		case bits32Swap(pcapngByteOrderMagic):
+			p.order = binary.BigEndian
+		default:
+			return errors.New("pcapng byte-order magic not valid")
+		}
+		p.interfaces = p.interfaces[:0]
+		length := p.order.Uint32(head[4:])
+		if length < 28 {
+			return fmt.Errorf("pcapng section header length %v too short", length)
+		}
+		_, err := io.CopyN(io.Discard, p.r, int64(length)-12)
+		return unexpectedEOF(err)
+	}
+	if p.order == nil {
+		return errors.New("pcapng section header block not found")
+	}
+	blockType := p.order.Uint32(head[:])
+	length := p.order.Uint32(head[4:])
+	if length < 12 || length%4 != 0 || length > 1<<24 {
+		return fmt.Errorf("pcapng block length %v not valid", length)
+	}
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(p.r, body); err != nil {
+		return unexpectedEOF(err)
+	}
+	body = body[:len(body)-4] // trailing block length
+	switch blockType {
+	case pcapngInterfaceDesc:
+		return p.readInterface(body)
+	case pcapngEnhancedPacket:
+		return p.readEnhancedPacket(body)
+	case pcapngSimplePacket:
+		if len(p.interfaces) == 0 || len(body) < 4 {
+			return errors.New("pcapng simple packet block not valid")
+		}
+		size := min(int(p.order.Uint32(body)), len(body)-4)
+		p.addFrame(0, time.Time{}, true, body[4:4+size])
+	}
+	return nil // ignore other blocks
+}
+
+func (p *PcapngReader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return errors.New("pcapng interface description block too short")
+	}
+	iface := pcapngInterface{linkType: p.order.Uint16(body), tsUnit: time.Microsecond}
+	err := p.readOptions(body[8:], func(code uint16, value []byte) {
+		switch code {
+		case pcapngOptIfName:
+			iface.name = string(value)
+		case pcapngOptIfTsresol:
+			if len(value) == 1 {
+				iface.tsUnit, iface.tsPerSec = tsResolution(value[0])
+			}
+		}
+	})
+	if iface.tsPerSec == 0 {
+		iface.tsPerSec = uint64(time.Second / iface.tsUnit)
+	}
+	p.interfaces = append(p.interfaces, iface)
+	return err
+}
+
+// tsResolution returns the unit and units per second of if_tsresol.
+func tsResolution(v byte) (time.Duration, uint64) {
+	var perSec float64
+	if v&0x80 == 0 {
+		perSec = math.Pow10(int(v))
+	} else {
+		perSec = math.Pow(2, float64(v&0x7F))
+	}
+	if perSec > 1e9 {
+		return 0, uint64(min(perSec, 1<<63))
+	}
+	return time.Duration(1e9 / perSec), uint64(perSec)
+}
+
+func (p *PcapngReader) readEnhancedPacket(body []byte) error {
+	if len(body) < 20 {
+		return errors.New("pcapng enhanced packet block too short")
+	}
+	id := p.order.Uint32(body)
+	if int(id) >= len(p.interfaces) {
+		return fmt.Errorf("pcapng interface %v not defined", id)
+	}
+	iface := p.interfaces[id]
+	ts := uint64(p.order.Uint32(body[4:]))<<32 | uint64(p.order.Uint32(body[8:]))
+	size := int(p.order.Uint32(body[12:]))
+	if 20+size > len(body) {
+		return errors.New("pcapng enhanced packet length not valid")
+	}
+	data := body[20 : 20+size]
+	inbound := true
+	optStart := 20 + (size+3)/4*4
+	if optStart <= len(body) {
+		_ = p.readOptions(body[optStart:], func(code uint16, value []byte) {
+			if code == pcapngOptEpbFlags && len(value) == 4 {
+				inbound = p.order.Uint32(value)&pcapngDirectionFlagsMask != pcapngFlagOutbound
+			}
+		})
+	}
+	sec := ts / iface.tsPerSec
+	nsec := time.Duration(ts % iface.tsPerSec)
+	if iface.tsUnit != 0 {
+		nsec *= iface.tsUnit
+	} else {
+		nsec = nsec * time.Second / time.Duration(iface.tsPerSec)
+	}
+	p.addFrame(id, time.Unix(int64(sec), int64(nsec)), inbound, data)
+	return nil
+}
+
+// readOptions calls f for each option in b.
+func (p *PcapngReader) readOptions(b []byte, f func(code uint16, value []byte)) error {
+	for len(b) >= 4 {
+		code, l := p.order.Uint16(b), int(p.order.Uint16(b[2:]))
+		if code == pcapngOptEnd {
+			return nil
+		}
+		if 4+l > len(b) {
+			return errors.New("pcapng option length not valid")
+		}
+		f(code, b[4:4+l])
+		b = b[min(len(b), 4+(l+3)/4*4):]
+	}
+	return nil
+}
+
+// addFrame decodes Modbus frames from data and adds them to pending.
+func (p *PcapngReader) addFrame(id uint32, t time.Time, inbound bool, data []byte) {
+	iface := p.interfaces[id]
+	f := CapturedFrame{Time: t, Interface: iface.name, LinkType: iface.linkType, Inbound: inbound}
+	switch iface.linkType {
+	case LinkTypeModbusRTU:
+		f.RTU = append(RTU(nil), data...)
+		if len(data) > 0 {
+			f.SlaveID = data[0]
+		}
+		f.PDU, f.Err = f.RTU.GetPDU()
+		p.pending = append(p.pending, f)
+	case LinkTypeEthernet:
+		stream, payload, ok := parseTCPFrame(id, data)
+		if !ok || len(payload) == 0 {
+			return
+		}
+		buf := append(p.streams[stream], payload...)
+		for len(buf) >= MBAPHeaderLength {
+			l := int(buf[4])<<8 | int(buf[5])
+			if l < 2 || buf[2] != 0 || buf[3] != 0 {
+				// not Modbus TCP, drop the data seen so far
+				f.Err = errors.New("unexpected MBAP header")
+				p.pending = append(p.pending, f)
+				buf = nil
+				break
+			}
+			if len(buf) < TCPHeaderLength+l {
+				break
+			}
+			adu := buf[:TCPHeaderLength+l]
+			f.SlaveID = adu[TCPHeaderLength]
+			f.PDU = append(PDU(nil), adu[MBAPHeaderLength:]...)
+			p.pending = append(p.pending, f)
+			buf = buf[len(adu):]
+		}
+		p.streams[stream] = append(p.streams[stream][:0], buf...)
+	}
+}
+
+// parseTCPFrame returns the stream and payload of an Ethernet frame of TCP
+// over IPv4.
+func parseTCPFrame(id uint32, b []byte) (tcpStream, []byte, bool) {
+	var s tcpStream
+	if len(b) < 14 || binary.BigEndian.Uint16(b[12:]) != 0x0800 {
+		return s, nil, false
+	}
+	ip := b[14:]
+	if len(ip) < 20 || ip[0]>>4 != 4 || ip[9] != 6 {
+		return s, nil, false
+	}
+	ihl := int(ip[0]&0x0F) * 4
+	total := int(binary.BigEndian.Uint16(ip[2:]))
+	if ihl < 20 || total < ihl || total > len(ip) {
+		return s, nil, false
+	}
+	tcp := ip[ihl:total]
+	if len(tcp) < 20 || int(tcp[12]>>4)*4 > len(tcp) {
+		return s, nil, false
+	}
+	s.iface = id
+	copy(s.src[:], ip[12:16])
+	copy(s.dst[:], ip[16:20])
+	s.srcPort = binary.BigEndian.Uint16(tcp)
+	s.dstPort = binary.BigEndian.Uint16(tcp[2:])
+	return s, tcp[int(tcp[12]>>4)*4:], true
+}
+
+func bits32Swap(v uint32) uint32 {
+	return v>>24 | v>>8&0xFF00 | v<<8&0xFF0000 | v<<24
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package modbusone_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+// readAllFrames reads all frames of a capture.
+func readAllFrames(t *testing.T, r io.Reader) []CapturedFrame {
+	pr := NewPcapngReader(r)
+	var frames []CapturedFrame
+	for {
+		f, err := pr.Next()
+		if errors.Is(err, io.EOF) {
+			return frames
+		}
+		require.NoError(t, err)
+		frames = append(frames, f)
+	}
+}
+
+func TestPcapngSerial(t *testing.T) {
+	slaveID := byte(0x11)
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+
+	var capture bytes.Buffer
+	pw, err := NewPcapngWriter(&capture)
+	require.NoError(t, err)
+	cc, err := NewCaptureSerialContext(newMockSerial(t, "c", r2, w1, w1), pw, "client")
+	require.NoError(t, err)
+	client := NewRTUClient(cc, slaveID)
+	server := NewRTUServer(newMockSerial(t, "s", r1, w2, w2), slaveID)
+	defer server.Close()
+	h := testTracerHandler()
+	go client.Serve(h)
+	go server.Serve(h)
+
+	start := time.Now()
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(5, 2)
+	require.NoError(t, err)
+	require.NoError(t, client.DoTransaction(read))
+	bad, err := FcReadHoldingRegisters.MakeRequestHeader(100, 1)
+	require.NoError(t, err)
+	require.Error(t, client.DoTransaction(bad))
+	client.Close()
+
+	frames := readAllFrames(t, &capture)
+	require.Len(t, frames, 4)
+	for _, f := range frames {
+		require.Equal(t, "client", f.Interface)
+		require.Equal(t, LinkTypeModbusRTU, f.LinkType)
+		require.Equal(t, slaveID, f.SlaveID)
+		require.NoError(t, f.Err)
+		require.WithinDuration(t, start, f.Time, time.Second)
+	}
+	require.False(t, frames[0].Inbound)
+	require.Equal(t, read, frames[0].PDU)
+	require.Equal(t, MakeRTU(slaveID, read), frames[0].RTU)
+	require.True(t, frames[1].Inbound)
+	require.Equal(t, PDU{byte(FcReadHoldingRegisters), 4, 0, 0, 0, 0}, frames[1].PDU)
+	require.Equal(t, bad, frames[2].PDU)
+	require.Equal(t, ExceptionReplyPacket(bad, EcIllegalDataAddress), frames[3].PDU)
+}
+
+func TestPcapngSerialMonitor(t *testing.T) {
+	slaveID := byte(0x11)
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(5, 2)
+	require.NoError(t, err)
+	reply := PDU{byte(FcReadHoldingRegisters), 4, 0, 1, 0, 2}
+	line := append(MakeRTU(slaveID, read), MakeRTU(slaveID, reply)...)
+
+	var capture bytes.Buffer
+	pw, err := NewPcapngWriter(&capture)
+	require.NoError(t, err)
+	sc, err := NewCaptureSerialContext(newMockSerial(t, "m", bytes.NewReader(line), io.Discard), pw, "monitor")
+	require.NoError(t, err)
+	b := make([]byte, MaxRTUSize)
+	for err == nil {
+		_, err = sc.Read(b)
+	}
+	require.ErrorIs(t, err, io.EOF)
+
+	frames := readAllFrames(t, bytes.NewReader(capture.Bytes()))
+	require.Len(t, frames, 2, "back to back frames are split, and written before Close")
+	require.Equal(t, read, frames[0].PDU)
+	require.Equal(t, reply, frames[1].PDU)
+	for _, f := range frames {
+		require.True(t, f.Inbound)
+		require.NoError(t, f.Err)
+	}
+	require.NoError(t, sc.Close())
+}
+
+func TestPcapngTCP(t *testing.T) {
+	var capture bytes.Buffer
+	pw, err := NewPcapngWriter(&capture)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := NewTCPServer(listener)
+	defer server.Close()
+	h := testTracerHandler()
+	go server.Serve(h)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	cconn, err := NewCaptureConn(conn, pw, "client")
+	require.NoError(t, err)
+	client := NewTCPClient(cconn, 1)
+	defer client.Close()
+	go client.Serve(h)
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(5, 2)
+	require.NoError(t, err)
+	require.NoError(t, client.DoTransaction(read))
+
+	frames := readAllFrames(t, bytes.NewReader(capture.Bytes()))
+	require.Len(t, frames, 2)
+	require.False(t, frames[0].Inbound)
+	require.Equal(t, read, frames[0].PDU)
+	require.Equal(t, LinkTypeEthernet, frames[0].LinkType)
+	require.True(t, frames[1].Inbound)
+	require.Equal(t, PDU{byte(FcReadHoldingRegisters), 4, 0, 0, 0, 0}, frames[1].PDU)
+	require.Nil(t, frames[1].RTU)
+}
+
+func TestPcapngReaderBigEndian(t *testing.T) {
+	be := binary.BigEndian
+	block := func(blockType uint32, body []byte) []byte {
+		b := be.AppendUint32(nil, blockType)
+		b = be.AppendUint32(b, uint32(len(body)+12))
+		b = append(b, body...)
+		return be.AppendUint32(b, uint32(len(body)+12))
+	}
+	var capture []byte
+	shb := be.AppendUint32(nil, 0x1A2B3C4D)
+	shb = append(shb, 0, 1, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	capture = append(capture, block(0x0A0D0D0A, shb)...)
+	idb := be.AppendUint16(nil, LinkTypeModbusRTU)
+	idb = append(idb, 0, 0, 0, 0, 0, 0)
+	capture = append(capture, block(1, idb)...)
+	rtu := MakeRTU(1, PDU{byte(FcReadCoils), 0, 0, 0, 1})
+	epb := be.AppendUint32(nil, 0)
+	epb = be.AppendUint32(epb, 0)
+	epb = be.AppendUint32(epb, 2000000) // 2 seconds in microseconds
+	epb = be.AppendUint32(epb, uint32(len(rtu)))
+	epb = be.AppendUint32(epb, uint32(len(rtu)))
+	epb = append(epb, rtu...)
+	epb = append(epb, make([]byte, 4-len(rtu)%4)...)
+	capture = append(capture, block(6, epb)...)
+
+	frames := readAllFrames(t, bytes.NewReader(capture))
+	require.Len(t, frames, 1)
+	require.Equal(t, rtu, frames[0].RTU)
+	require.Equal(t, time.Unix(2, 0), frames[0].Time)
+	require.True(t, frames[0].Inbound, "inbound if not known")
+}
@@ -0,0 +1,327 @@
+package modbusone
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/xiegeo/modbusone/crc"
+)
+
+var functionCodeNames = map[FunctionCode]string{
+	FcReadCoils:              "ReadCoils",
+	FcReadDiscreteInputs:     "ReadDiscreteInputs",
+	FcReadHoldingRegisters:   "ReadHoldingRegisters",
+	FcReadInputRegisters:     "ReadInputRegisters",
+	FcWriteSingleCoil:        "WriteSingleCoil",
+	FcWriteSingleRegister:    "WriteSingleRegister",
+	FcWriteMultipleCoils:     "WriteMultipleCoils",
+	FcWriteMultipleRegisters: "WriteMultipleRegisters",
+}
+
+// Name returns the name of the function code, such as "ReadCoils", or
+// "FunctionCode(0x41)" if it is not supported. The error flag is ignored.
+func (f FunctionCode) Name() string {
+	_, f = f.SeparateError()
+	if name, ok := functionCodeNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("FunctionCode(0x%02X)", byte(f))
+}
+
+var exceptionCodeNames = map[ExceptionCode]string{
+	EcOK:                                 "OK",
+	EcInternal:                           "Internal",
+	EcIllegalFunction:                    "IllegalFunction",
+	EcIllegalDataAddress:                 "IllegalDataAddress",
+	EcIllegalDataValue:                   "IllegalDataValue",
+	EcServerDeviceFailure:                "ServerDeviceFailure",
+	EcAcknowledge:                        "Acknowledge",
+	EcServerDeviceBusy:                   "ServerDeviceBusy",
+	EcMemoryParityError:                  "MemoryParityError",
+	EcGatewayPathUnavailable:             "GatewayPathUnavailable",
+	EcGatewayTargetDeviceFailedToRespond: "GatewayTargetDeviceFailedToRespond",
+}
+
+// Name returns the name of the exception code, such as "IllegalDataAddress",
+// or "ExceptionCode(0x07)" if it is not defined.
+func (e ExceptionCode) Name() string {
+	if name, ok := exceptionCodeNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("ExceptionCode(0x%02X)", byte(e))
+}
+
+// pduDirection is whether a PDU is formatted as a request or a reply.
+type pduDirection int8
+
+const (
+	guessDirection pduDirection = iota
+	asRequest
+	asReply
+)
+
+// pduField is a decoded field of a PDU.
+type pduField struct {
+	key, value string
+}
+
+// pduDescription is a decoded PDU or RTU, for formatting.
+type pduDescription struct {
+	title  string
+	fields []pduField
+}
+
+func (d *pduDescription) add(key string, value interface{}) {
+	d.fields = append(d.fields, pduField{key: key, value: fmt.Sprint(value)})
+}
+
+// compact returns the one line form, such as
+// "ReadHoldingRegisters request address=5 quantity=2".
+func (d *pduDescription) compact() string {
+	var sb strings.Builder
+	sb.WriteString(d.title)
+	for _, f := range d.fields {
+		fmt.Fprintf(&sb, " %s=%s", f.key, f.value)
+	}
+	return sb.String()
+}
+
+// verbose returns the multi-line form, with one field per line.
+func (d *pduDescription) verbose() string {
+	var sb strings.Builder
+	sb.WriteString(d.title)
+	for _, f := range d.fields {
+		fmt.Fprintf(&sb, "\n  %s: %s", f.key, f.value)
+	}
+	return sb.String()
+}
+
+// describePDU decodes p. With guessDirection, read PDUs of 5 bytes are
+// requests, even bool reads that are also valid replies with a byte count of
+// 3, and write PDUs of 5 bytes are replies. Single writes have the same form
+// in both directions.
+func describePDU(p PDU, dir pduDirection) pduDescription {
+	var d pduDescription
+	if len(p) == 0 {
+		d.title = "empty"
+		return d
+	}
+	isErr, fc := p.GetFunctionCode().SeparateError()
+	if isErr {
+		d.title = fc.Name() + " exception"
+		if len(p) != 2 {
+			d.add("error", fmt.Sprintf("exception reply of %v bytes, expected 2", len(p)))
+			d.add("data", hexBytes(p[1:]))
+			return d
+		}
+		ec := ExceptionCode(p[1])
+		d.add("exception", fmt.Sprintf("%s(0x%02X)", ec.Name(), byte(ec)))
+		return d
+	}
+	if !fc.Valid() {
+		d.title = fc.Name()
+		d.add("data", hexBytes(p[1:]))
+		return d
+	}
+	if dir == guessDirection {
+		dir = asRequest
+		switch {
+		case fc.IsReadToServer() && len(p) != 5:
+			dir = asReply
+		case fc.IsWriteToServer() && !fc.IsSingle() && len(p) == 5:
+			dir = asReply
+		}
+	}
+	if dir == asRequest {
+		d.title = fc.Name() + " request"
+	} else {
+		d.title = fc.Name() + " reply"
+	}
+	malformed := func(expected string) pduDescription {
+		d.add("error", fmt.Sprintf("%v bytes, expected %s", len(p), expected))
+		d.add("data", hexBytes(p[1:]))
+		return d
+	}
+
+	switch {
+	case fc.IsSingle():
+		if len(p) != 5 {
+			return malformed("5")
+		}
+		d.add("address", p.GetAddress())
+		if fc.IsBool() {
+			switch binary.BigEndian.Uint16(p[3:]) {
+			case 0xFF00:
+				d.add("value", true)
+			case 0:
+				d.add("value", false)
+			default:
+				d.add("value", fmt.Sprintf("invalid(0x%04X)", binary.BigEndian.Uint16(p[3:])))
+			}
+		} else {
+			d.add("value", binary.BigEndian.Uint16(p[3:]))
+		}
+	case dir == asReply && fc.IsReadToServer():
+		if len(p) < 2 || int(p[1]) != len(p)-2 {
+			return malformed("2 + byte count")
+		}
+		d.add("byte_count", p[1])
+		d.add("values", formatValues(fc, p[2:], uint16(len(p)-2)*8))
+	case dir == asReply || fc.IsReadToServer():
+		// read requests and write replies
+		if len(p) != 5 {
+			return malformed("5")
+		}
+		d.add("address", p.GetAddress())
+		count, _ := p.GetRequestCount()
+		d.add("quantity", count)
+	default:
+		// write multiple requests
+		if len(p) < 6 {
+			return malformed("6 + byte count")
+		}
+		count, _ := p.GetRequestCount()
+		d.add("address", p.GetAddress())
+		d.add("quantity", count)
+		d.add("byte_count", p[5])
+		if int(p[5]) != len(p)-6 {
+			return malformed(fmt.Sprintf("%v", 6+int(p[5])))
+		}
+		d.add("values", formatValues(fc, p[6:], count))
+	}
+	return d
+}
+
+// formatValues formats data of fc as a list of registers or up to count bools.
+func formatValues(fc FunctionCode, data []byte, count uint16) string {
+	if fc.IsUint16() {
+		if len(data)%2 != 0 {
+			return "odd bytes " + hex.EncodeToString(data)
+		}
+		values := make([]uint16, len(data)/2)
+		for i := range values {
+			values[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+		return fmt.Sprint(values)
+	}
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i := 0; i < int(count) && i/8 < len(data); i++ {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteByte('0' + data[i/8]>>(i%8)&1)
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
+
+// describeRTU decodes r, adding the slave ID and CRC check to its PDU.
+func describeRTU(r RTU, dir pduDirection) pduDescription {
+	if len(r) < smallestRTUSize {
+		d := pduDescription{title: "short RTU"}
+		d.add("data", hexBytes(r))
+		return d
+	}
+	d := describePDU(r.fastGetPDU(), dir)
+	d.fields = append([]pduField{{key: "slave_id", value: fmt.Sprint(r[0])}}, d.fields...)
+	c := hexBytes(r[len(r)-2:])
+	if crc.Validate(r) {
+		d.add("crc", fmt.Sprintf("ok(%v)", c))
+	} else {
+		d.add("crc", fmt.Sprintf("bad(%v)", c))
+	}
+	return d
+}
+
+// formatDescription implements fmt.Formatter for PDU, RTU and RTUHeader.
+// %v and %s use the compact form, %+v the verbose form,
+// and other verbs format b as []byte, so %x is still hex.
+func formatDescription(s fmt.State, verb rune, b []byte, describe func() pduDescription) {
+	switch {
+	case verb == 'v' && s.Flag('+'):
+		d := describe()
+		fmt.Fprint(s, d.verbose())
+	case verb == 'v' && !s.Flag('#'), verb == 's':
+		d := describe()
+		fmt.Fprint(s, d.compact())
+	default:
+		fmt.Fprintf(s, fmt.FormatString(s, verb), b)
+	}
+}
+
+// Format implements fmt.Formatter. %v and %s print a one line description,
+// such as "ReadHoldingRegisters request address=5 quantity=2", and %+v prints
+// one field per line. Other verbs, such as %x, format the bytes.
+//
+// Whether p is a request or a reply is guessed from its size, where a size
+// valid for both is a request. Use FormatRequest or FormatReply if it is known.
+func (p PDU) Format(s fmt.State, verb rune) {
+	formatDescription(s, verb, p, func() pduDescription { return describePDU(p, guessDirection) })
+}
+
+// String returns the one line description of p.
+func (p PDU) String() string {
+	d := describePDU(p, guessDirection)
+	return d.compact()
+}
+
+// FormatRequest describes p as a request, in the one line or verbose form.
+func (p PDU) FormatRequest(verbose bool) string {
+	return formatPDU(describePDU(p, asRequest), verbose)
+}
+
+// FormatReply describes p as a reply, in the one line or verbose form.
+func (p PDU) FormatReply(verbose bool) string {
+	return formatPDU(describePDU(p, asReply), verbose)
+}
+
+func formatPDU(d pduDescription, verbose bool) string {
+	if verbose {
+		return d.verbose()
+	}
+	return d.compact()
+}
+
+// Format implements fmt.Formatter, as PDU.Format with the slave ID and CRC
+// validity added.
+func (r RTU) Format(s fmt.State, verb rune) {
+	formatDescription(s, verb, r, func() pduDescription { return describeRTU(r, guessDirection) })
+}
+
+// String returns the one line description of r.
+func (r RTU) String() string {
+	d := describeRTU(r, guessDirection)
+	return d.compact()
+}
+
+// FormatRequest describes r as a request, in the one line or verbose form.
+func (r RTU) FormatRequest(verbose bool) string {
+	return formatPDU(describeRTU(r, asRequest), verbose)
+}
+
+// FormatReply describes r as a reply, in the one line or verbose form.
+func (r RTU) FormatReply(verbose bool) string {
+	return formatPDU(describeRTU(r, asReply), verbose)
+}
+
+// describe decodes the PDU of h as a request, with the slave ID added.
+func (h RTUHeader) describe() pduDescription {
+	d := describePDU(h.PDU, asRequest)
+	d.fields = append([]pduField{{key: "slave_id", value: fmt.Sprint(h.SlaveID)}}, d.fields...)
+	return d
+}
+
+// Format implements fmt.Formatter, as PDU.Format with the slave ID added.
+// The PDU is formatted as a request.
+func (h RTUHeader) Format(s fmt.State, verb rune) {
+	formatDescription(s, verb, append([]byte{h.SlaveID}, h.PDU...), h.describe)
+}
+
+// String returns the one line description of h.
+func (h RTUHeader) String() string {
+	d := h.describe()
+	return d.compact()
+}
@@ -0,0 +1,60 @@
+package modbusone_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestPDUFormat(t *testing.T) {
+	header := func(fc FunctionCode, address, quantity uint16) PDU {
+		p, err := fc.MakeRequestHeader(address, quantity)
+		require.NoError(t, err)
+		return p
+	}
+	regs, err := RegistersToData([]uint16{5, 6})
+	require.NoError(t, err)
+	bools, err := BoolsToData([]bool{true, false, true}, FcWriteMultipleCoils)
+	require.NoError(t, err)
+	tests := []struct {
+		name string
+		p    PDU
+		want string
+	}{
+		{"read request", header(FcReadHoldingRegisters, 5, 2), "ReadHoldingRegisters request address=5 quantity=2"},
+		{"read reply", header(FcReadHoldingRegisters, 5, 2).MakeReadReply(regs), "ReadHoldingRegisters reply byte_count=4 values=[5 6]"},
+		{"bool reply", header(FcReadCoils, 0, 3).MakeReadReply(bools), "ReadCoils reply byte_count=1 values=[1 0 1 0 0 0 0 0]"},
+		{"write request", header(FcWriteMultipleCoils, 1, 3).MakeWriteRequest(bools), "WriteMultipleCoils request address=1 quantity=3 byte_count=1 values=[1 0 1]"},
+		{"write reply", header(FcWriteMultipleRegisters, 1, 2).MakeWriteRequest(regs).MakeWriteReply(), "WriteMultipleRegisters reply address=1 quantity=2"},
+		{"single coil", PDU{5, 0, 7, 0xFF, 0}, "WriteSingleCoil request address=7 value=true"},
+		{"exception", ExceptionReplyPacket(header(FcReadCoils, 0, 1), EcIllegalDataAddress), "ReadCoils exception exception=IllegalDataAddress(0x02)"},
+		{"unknown", PDU{0x41, 1}, "FunctionCode(0x41) data=01"},
+		{"malformed", PDU{3, 0, 1}, "ReadHoldingRegisters reply error=3 bytes, expected 2 + byte count data=0001"},
+		{"empty", PDU{}, "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.p.String())
+			require.Equal(t, tt.want, fmt.Sprintf("%v", tt.p))
+		})
+	}
+
+	p := header(FcReadCoils, 0x300, 8)
+	require.Equal(t, "ReadCoils request address=768 quantity=8", p.String(), "a request if it can be one")
+	require.Equal(t, "ReadCoils reply byte_count=3 values=[0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 1 0 0 0 0]", p.FormatReply(false))
+	require.Equal(t, "ReadCoils request address=768 quantity=8", p.FormatRequest(false))
+	require.Equal(t, "ReadCoils request\n  address: 768\n  quantity: 8", p.FormatRequest(true))
+	require.Equal(t, "0103000008", fmt.Sprintf("%x", p), "hex is not changed")
+	require.Equal(t, "01 03 00 00 08", fmt.Sprintf("% x", p))
+
+	rtu := MakeRTU(17, header(FcReadHoldingRegisters, 5, 2))
+	require.Equal(t, "ReadHoldingRegisters request slave_id=17 address=5 quantity=2 crc=ok(d69a)", rtu.String())
+	require.Equal(t, "ReadHoldingRegisters request\n  slave_id: 17\n  address: 5\n  quantity: 2\n  crc: ok(d69a)",
+		fmt.Sprintf("%+v", rtu))
+	rtu[len(rtu)-1]++
+	require.Contains(t, rtu.String(), "crc=bad")
+	require.Equal(t, "ReadHoldingRegisters request slave_id=17 address=5 quantity=2",
+		RTUHeader{SlaveID: 17, PDU: header(FcReadHoldingRegisters, 5, 2)}.String())
+}
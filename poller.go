@@ -0,0 +1,203 @@
+package modbusone
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PollGroup is a range of values to be read periodically from a server.
+type PollGroup struct {
+	_ struct{} // enforces keyed literals
+
+	SlaveID  byte
+	Table    Table
+	Address  uint16
+	Quantity uint16
+	Interval time.Duration // time between the start of each poll
+	// Priority decides which group goes first when several are due, highest first.
+	// Groups of lower priority are delayed as long as higher priority groups are due.
+	Priority int
+	// MaxPerPacket limits the values per request, 0 for Table.ReadFunctionCode().MaxPerPacket().
+	MaxPerPacket uint16
+	// OnResult, if not nil, is called after each poll of this group.
+	OnResult func(PollResult)
+}
+
+// PollResult reports the outcome of one poll of a PollGroup. The values read
+// are delivered to the handler of the client as usual.
+type PollResult struct {
+	ID       int // as returned by Poller.Add
+	Group    PollGroup
+	Start    time.Time
+	Duration time.Duration
+	// Requests is the number of requests completed successfully.
+	Requests int
+	// Err is nil if all requests of the group completed successfully.
+	Err error
+}
+
+// Poller schedules periodic reads of PollGroups on a client. Only one
+// transaction is started at a time, so the groups never overlap on a serial bus.
+// Groups can be added and removed while the Poller is running.
+type Poller struct {
+	client RTUTransactionStarter
+	lock   sync.Mutex
+	groups map[int]*pollEntry
+	lastID int
+	wake   chan struct{}
+	logger instanceLogger
+}
+
+type pollEntry struct {
+	id    int
+	group PollGroup
+	reqs  []PDU
+	next  time.Time
+}
+
+// NewPoller creates a Poller that starts transactions on client, such as an
+// RTUClient or TCPClient.
+func NewPoller(client RTUTransactionStarter) *Poller {
+	return &Poller{
+		client: client,
+		groups: make(map[int]*pollEntry),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Add schedules a PollGroup to be polled as soon as possible, and every
+// Interval after. It returns the id used to Remove the group.
+func (p *Poller) Add(g PollGroup) (int, error) {
+	if !g.Table.Valid() {
+		return 0, fmt.Errorf("can not poll %v", g.Table)
+	}
+	if g.Interval <= 0 {
+		return 0, fmt.Errorf("poll interval %v must be positive", g.Interval)
+	}
+	if g.Quantity == 0 {
+		return 0, fmt.Errorf("poll quantity is required")
+	}
+	fc := g.Table.ReadFunctionCode()
+	if g.MaxPerPacket == 0 {
+		g.MaxPerPacket = fc.MaxPerPacket()
+	}
+	reqs, err := MakePDURequestHeadersSized(fc, g.Address, g.Quantity, g.MaxPerPacket, nil)
+	if err != nil {
+		return 0, err
+	}
+	p.lock.Lock()
+	p.lastID++
+	id := p.lastID
+	p.groups[id] = &pollEntry{id: id, group: g, reqs: reqs, next: time.Now()}
+	p.lock.Unlock()
+	p.signal()
+	return id, nil
+}
+
+// Remove stops polling the group of id, a poll already in progress is completed
+// without calling OnResult. It returns false if there is no such group.
+func (p *Poller) Remove(id int) bool {
+	p.lock.Lock()
+	_, ok := p.groups[id]
+	delete(p.groups, id)
+	p.lock.Unlock()
+	return ok
+}
+
+func (p *Poller) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// nextDue returns the entry to poll now, or the duration to wait for one.
+func (p *Poller) nextDue(now time.Time) (*pollEntry, time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var due *pollEntry
+	wait := time.Duration(-1) // wait for Add
+	for _, e := range p.groups {
+		if e.next.After(now) {
+			if d := e.next.Sub(now); wait < 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		if due == nil || e.group.Priority > due.group.Priority ||
+			(e.group.Priority == due.group.Priority && (e.next.Before(due.next) ||
+				(e.next.Equal(due.next) && e.id < due.id))) {
+			due = e
+		}
+	}
+	return due, wait
+}
+
+// Run polls the groups until ctx is done, it then returns ctx.Err() without
+// waiting for the transaction in progress. Run must not be called concurrently.
+func (p *Poller) Run(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		e, wait := p.nextDue(time.Now())
+		if e == nil {
+			var timeout <-chan time.Time
+			if wait >= 0 {
+				timeout = time.After(wait)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-p.wake:
+			case <-timeout:
+			}
+			continue
+		}
+		p.poll(ctx, e)
+	}
+}
+
+func (p *Poller) poll(ctx context.Context, e *pollEntry) {
+	start := time.Now()
+	var n int
+	var err error
+	errChan := make(chan error, 1) // the client must not block if ctx is done first
+	for n < len(e.reqs) {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		p.client.StartTransactionToServer(e.group.SlaveID, e.reqs[n], errChan)
+		select {
+		case err = <-errChan:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			p.logger.logErr(levelWarn, "Poller request failed", err,
+				append(requestLogArgs(e.group.SlaveID, e.reqs[n]), "group", e.id, "request", n)...)
+			break
+		}
+		n++
+	}
+
+	p.lock.Lock()
+	_, ok := p.groups[e.id]
+	if ok {
+		e.next = start.Add(e.group.Interval)
+	}
+	p.lock.Unlock()
+	if !ok || e.group.OnResult == nil {
+		return
+	}
+	e.group.OnResult(PollResult{
+		ID:       e.id,
+		Group:    e.group,
+		Start:    start,
+		Duration: time.Since(start),
+		Requests: n,
+		Err:      err,
+	})
+}
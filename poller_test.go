@@ -0,0 +1,136 @@
+package modbusone_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+// recordingStarter records transactions, and fails those to slaveID 9.
+type recordingStarter struct {
+	lock    sync.Mutex
+	active  int
+	overlap bool
+	reqs    []RTUHeader
+}
+
+func (s *recordingStarter) StartTransactionToServer(slaveID byte, req PDU, errChan chan error) {
+	s.lock.Lock()
+	s.active++
+	if s.active > 1 {
+		s.overlap = true
+	}
+	s.reqs = append(s.reqs, RTUHeader{SlaveID: slaveID, PDU: req})
+	s.lock.Unlock()
+	go func() {
+		time.Sleep(time.Millisecond)
+		s.lock.Lock()
+		s.active--
+		s.lock.Unlock()
+		if slaveID == 9 {
+			errChan <- ErrServerTimeOut
+			return
+		}
+		errChan <- nil
+	}()
+}
+
+func (s *recordingStarter) count(slaveID byte) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	n := 0
+	for _, r := range s.reqs {
+		if r.SlaveID == slaveID {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPoller(t *testing.T) {
+	starter := &recordingStarter{}
+	p := NewPoller(starter)
+
+	results := make(chan PollResult, 100)
+	onResult := func(r PollResult) { results <- r }
+	_, err := p.Add(PollGroup{SlaveID: 1, Table: TableHoldingRegisters, Address: 0, Quantity: 200,
+		Interval: time.Second, OnResult: onResult})
+	require.NoError(t, err)
+	_, err = p.Add(PollGroup{SlaveID: 2, Table: TableCoils, Address: 0, Quantity: 10,
+		Interval: time.Second, Priority: 1, OnResult: onResult})
+	require.NoError(t, err)
+	_, err = p.Add(PollGroup{SlaveID: 1, Table: TableNone, Quantity: 1, Interval: time.Second})
+	require.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+
+	// higher priority first
+	r := <-results
+	require.Equal(t, byte(2), r.Group.SlaveID)
+	require.NoError(t, r.Err)
+	require.Equal(t, 1, r.Requests)
+	r = <-results
+	require.Equal(t, byte(1), r.Group.SlaveID)
+	require.Equal(t, 2, r.Requests, "split into max sized requests")
+
+	// added at runtime
+	id, err := p.Add(PollGroup{SlaveID: 9, Table: TableInputRegisters, Address: 0, Quantity: 1,
+		Interval: time.Millisecond * 10, OnResult: onResult})
+	require.NoError(t, err)
+	r = <-results
+	require.Equal(t, id, r.ID)
+	require.True(t, errors.Is(r.Err, ErrServerTimeOut), r.Err)
+	require.Equal(t, 0, r.Requests)
+	<-results // polled again after Interval
+	require.True(t, p.Remove(id))
+	require.False(t, p.Remove(id))
+	n := starter.count(9)
+	time.Sleep(time.Millisecond * 50)
+	require.LessOrEqual(t, starter.count(9), n+1, "no polls after remove")
+	require.Equal(t, 2, starter.count(1), "not due again yet")
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	require.False(t, starter.overlap, "transactions must not overlap")
+}
+
+// stuckStarter starts transactions that never finish.
+type stuckStarter struct {
+	started chan struct{}
+}
+
+func (s *stuckStarter) StartTransactionToServer(slaveID byte, req PDU, errChan chan error) {
+	s.started <- struct{}{}
+}
+
+func TestPollerCancelInTransaction(t *testing.T) {
+	starter := &stuckStarter{started: make(chan struct{}, 1)}
+	p := NewPoller(starter)
+	results := make(chan PollResult, 1)
+	_, err := p.Add(PollGroup{SlaveID: 1, Table: TableHoldingRegisters, Quantity: 1,
+		Interval: time.Second, OnResult: func(r PollResult) { results <- r }})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+	<-starter.started
+	cancel()
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("Run is blocked by the transaction in progress")
+	}
+	r := <-results
+	require.ErrorIs(t, r.Err, context.Canceled)
+	require.Equal(t, 0, r.Requests)
+}
@@ -0,0 +1,231 @@
+package modbusone
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var _ ProtocolHandler = &RangeRouter{} // Asserts RangeRouter implements ProtocolHandler.
+
+// RangeRouter implements ProtocolHandler by routing requests to the handlers
+// mounted on address ranges of each Table. It allows a device to be assembled
+// from modules, each handling its own part of the address space.
+//
+// Requests spanning several mounts are split into one request per mount, with
+// the results recombined. Mounted handlers receive the original (absolute)
+// addresses. Requests that touch any unmapped address return EcIllegalDataAddress.
+//
+// Writes spanning several mounts are checked and split before any handler is
+// called, but are not atomic: if a handler returns an error, the handlers
+// before it have already written their part.
+//
+// The zero value is an empty router ready to use. RangeRouter is safe for
+// concurrent use.
+type RangeRouter struct {
+	lock   sync.RWMutex
+	mounts [TableHoldingRegisters + 1][]rangeMount // sorted by start
+}
+
+type rangeMount struct {
+	start, end uint16 // inclusive
+	handler    ProtocolHandler
+}
+
+// Mount adds handler to serve addresses from start to end inclusive of Table t.
+// It returns an error if the range is invalid or overlaps an existing mount.
+func (r *RangeRouter) Mount(t Table, start, end uint16, handler ProtocolHandler) error {
+	if !t.Valid() {
+		return fmt.Errorf("can not mount on %v", t)
+	}
+	if start > end {
+		return fmt.Errorf("mount range start %v is after end %v", start, end)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ms := r.mounts[t]
+	i := sort.Search(len(ms), func(i int) bool { return ms[i].start > end })
+	if i > 0 && ms[i-1].end >= start {
+		return fmt.Errorf("mount %v %v-%v overlaps with %v-%v", t, start, end, ms[i-1].start, ms[i-1].end)
+	}
+	ms = append(ms, rangeMount{})
+	copy(ms[i+1:], ms[i:])
+	ms[i] = rangeMount{start: start, end: end, handler: handler}
+	r.mounts[t] = ms
+	return nil
+}
+
+// Unmount removes the mount that starts at start of Table t, and returns
+// false if there is none.
+func (r *RangeRouter) Unmount(t Table, start uint16) bool {
+	if !t.Valid() {
+		return false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ms := r.mounts[t]
+	for i, m := range ms {
+		if m.start == start {
+			r.mounts[t] = append(ms[:i:i], ms[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// routeSpan is the part of a request served by one handler.
+type routeSpan struct {
+	handler  ProtocolHandler
+	address  uint16
+	quantity uint16
+}
+
+// route splits the address range into spans of mounted handlers.
+func (r *RangeRouter) route(t Table, address, quantity uint16) ([]routeSpan, error) {
+	if !t.Valid() {
+		return nil, ErrFcNotSupported
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	ms := r.mounts[t]
+	next := int(address)
+	last := int(address) + int(quantity) - 1
+	i := sort.Search(len(ms), func(i int) bool { return int(ms[i].end) >= next })
+	var spans []routeSpan
+	for ; next <= last; i++ {
+		if i >= len(ms) || int(ms[i].start) > next {
+			return nil, fmt.Errorf("%w %v address %v is not mounted", EcIllegalDataAddress, t, next)
+		}
+		end := min(int(ms[i].end), last)
+		spans = append(spans, routeSpan{
+			handler:  ms[i].handler,
+			address:  uint16(next),
+			quantity: uint16(end - next + 1),
+		})
+		next = end + 1
+	}
+	return spans, nil
+}
+
+func (r *RangeRouter) routeRequest(req PDU) ([]routeSpan, error) {
+	if err := req.ValidateRequest(); err != nil {
+		return nil, err
+	}
+	count, err := req.GetRequestCount()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, EcIllegalDataValue
+	}
+	return r.route(req.GetFunctionCode().Table(), req.GetAddress(), count)
+}
+
+// OnRead implements ProtocolHandler.
+func (r *RangeRouter) OnRead(req PDU) ([]byte, error) {
+	spans, err := r.routeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(spans) == 1 {
+		return spans[0].handler.OnRead(req)
+	}
+	fc := req.GetFunctionCode()
+	var bools []bool
+	var data []byte
+	for _, s := range spans {
+		sub, err := fc.MakeRequestHeader(s.address, s.quantity)
+		if err != nil {
+			return nil, err
+		}
+		d, err := s.handler.OnRead(sub)
+		if err != nil {
+			return nil, err
+		}
+		if fc.IsBool() {
+			bs, err := DataToBools(d, s.quantity, fc)
+			if err != nil {
+				debugf("RangeRouter handler for %v returned invalid bools: %v", s.address, err)
+				return nil, EcServerDeviceFailure
+			}
+			bools = append(bools, bs...)
+			continue
+		}
+		if len(d) != int(s.quantity)*2 {
+			debugf("RangeRouter handler for %v returned %v bytes for %v registers", s.address, len(d), s.quantity)
+			return nil, EcServerDeviceFailure
+		}
+		data = append(data, d...)
+	}
+	if fc.IsBool() {
+		return BoolsToData(bools, fc)
+	}
+	return data, nil
+}
+
+// OnWrite implements ProtocolHandler.
+func (r *RangeRouter) OnWrite(req PDU, data []byte) error {
+	spans, err := r.routeRequest(req)
+	if err != nil {
+		return err
+	}
+	if len(spans) == 1 {
+		return spans[0].handler.OnWrite(req, data)
+	}
+	fc := req.GetFunctionCode()
+	count, _ := req.GetRequestCount() // error checked by routeRequest
+	var bools []bool
+	if fc.IsBool() {
+		bools, err = DataToBools(data, count, fc)
+		if err != nil {
+			return err
+		}
+	} else if len(data) != int(count)*2 {
+		return EcIllegalDataValue
+	}
+	// make all sub requests first, so that no handler writes if any fails
+	subs := make([]PDU, len(spans))
+	subData := make([][]byte, len(spans))
+	offset := 0
+	for i, s := range spans {
+		var d []byte
+		if fc.IsBool() {
+			d, err = BoolsToData(bools[offset:offset+int(s.quantity)], fc)
+			if err != nil {
+				return err
+			}
+		} else {
+			d = data[offset*2 : (offset+int(s.quantity))*2]
+		}
+		offset += int(s.quantity)
+		sub, err := fc.MakeRequestHeader(s.address, s.quantity)
+		if err != nil {
+			return err
+		}
+		if fc.IsWriteToServer() {
+			sub = sub.MakeWriteRequest(d)
+		}
+		subs[i], subData[i] = sub, d
+	}
+	for i, s := range spans {
+		err = s.handler.OnWrite(subs[i], subData[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnError implements ProtocolHandler by calling the handler mounted on the
+// starting address of req.
+func (r *RangeRouter) OnError(req PDU, errRep PDU) {
+	if len(req) < 3 {
+		return
+	}
+	spans, err := r.route(req.GetFunctionCode().Table(), req.GetAddress(), 1)
+	if err != nil {
+		debugf("RangeRouter OnError: %v", err)
+		return
+	}
+	spans[0].handler.OnError(req, errRep)
+}
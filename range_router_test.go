@@ -0,0 +1,103 @@
+package modbusone_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestRangeRouter(t *testing.T) {
+	a := NewMemoryHandler(100)
+	b := NewMemoryHandler(2000)
+	var r RangeRouter
+	require.NoError(t, r.Mount(TableHoldingRegisters, 0, 99, a))
+	require.NoError(t, r.Mount(TableHoldingRegisters, 100, 199, b))
+	require.NoError(t, r.Mount(TableCoils, 0, 4, a))
+	require.NoError(t, r.Mount(TableCoils, 5, 20, b))
+	require.Error(t, r.Mount(TableHoldingRegisters, 150, 1000, b), "overlapping mount")
+	require.Error(t, r.Mount(TableHoldingRegisters, 20, 10, b), "invalid range")
+
+	require.NoError(t, a.WriteRegisters(TableHoldingRegisters, 98, []uint16{1, 2}))
+	require.NoError(t, b.WriteRegisters(TableHoldingRegisters, 100, []uint16{3, 4}))
+
+	t.Run("read across mounts", func(t *testing.T) {
+		req, err := FcReadHoldingRegisters.MakeRequestHeader(98, 4)
+		require.NoError(t, err)
+		data, err := r.OnRead(req)
+		require.NoError(t, err)
+		values, err := DataToRegisters(data)
+		require.NoError(t, err)
+		require.Equal(t, []uint16{1, 2, 3, 4}, values)
+	})
+
+	t.Run("write across mounts", func(t *testing.T) {
+		req, err := FcWriteMultipleRegisters.MakeRequestHeader(99, 3)
+		require.NoError(t, err)
+		data, err := RegistersToData([]uint16{7, 8, 9})
+		require.NoError(t, err)
+		require.NoError(t, r.OnWrite(req.MakeWriteRequest(data), data))
+		values, err := a.ReadRegisters(TableHoldingRegisters, 99, 1)
+		require.NoError(t, err)
+		require.Equal(t, []uint16{7}, values)
+		values, err = b.ReadRegisters(TableHoldingRegisters, 100, 2)
+		require.NoError(t, err)
+		require.Equal(t, []uint16{8, 9}, values)
+	})
+
+	t.Run("bools across mounts", func(t *testing.T) {
+		vs := []bool{true, false, true, true, false, false, true, true, false, true}
+		req, err := FcWriteMultipleCoils.MakeRequestHeader(0, uint16(len(vs)))
+		require.NoError(t, err)
+		data, err := BoolsToData(vs, FcWriteMultipleCoils)
+		require.NoError(t, err)
+		require.NoError(t, r.OnWrite(req.MakeWriteRequest(data), data))
+		got, err := a.ReadBools(TableCoils, 0, 5)
+		require.NoError(t, err)
+		require.Equal(t, vs[:5], got)
+		got, err = b.ReadBools(TableCoils, 5, 5)
+		require.NoError(t, err)
+		require.Equal(t, vs[5:], got)
+
+		req, err = FcReadCoils.MakeRequestHeader(2, 6)
+		require.NoError(t, err)
+		data, err = r.OnRead(req)
+		require.NoError(t, err)
+		got, err = DataToBools(data, 6, FcReadCoils)
+		require.NoError(t, err)
+		require.Equal(t, vs[2:8], got)
+	})
+
+	t.Run("unmapped", func(t *testing.T) {
+		for _, req := range []PDU{
+			{byte(FcReadHoldingRegisters), 0, 199, 0, 2},
+			{byte(FcReadHoldingRegisters), 1, 0, 0, 1},
+			{byte(FcReadInputRegisters), 0, 0, 0, 1},
+			{byte(FcReadCoils), 0, 20, 0, 2},
+		} {
+			_, err := r.OnRead(req)
+			require.True(t, errors.Is(err, EcIllegalDataAddress), "%x: %v", req, err)
+			require.Equal(t, EcIllegalDataAddress, ToExceptionCode(err))
+		}
+	})
+
+	t.Run("write across unmapped", func(t *testing.T) {
+		req, err := FcWriteMultipleRegisters.MakeRequestHeader(99, 102)
+		require.NoError(t, err)
+		data, err := RegistersToData(make([]uint16, 102))
+		require.NoError(t, err)
+		err = r.OnWrite(req.MakeWriteRequest(data), data)
+		require.True(t, errors.Is(err, EcIllegalDataAddress), err)
+		values, err := a.ReadRegisters(TableHoldingRegisters, 99, 1)
+		require.NoError(t, err)
+		require.Equal(t, []uint16{7}, values, "no mount is written")
+	})
+
+	t.Run("unmount", func(t *testing.T) {
+		require.True(t, r.Unmount(TableHoldingRegisters, 100))
+		require.False(t, r.Unmount(TableHoldingRegisters, 100))
+		_, err := r.OnRead(PDU{byte(FcReadHoldingRegisters), 0, 100, 0, 1})
+		require.True(t, errors.Is(err, EcIllegalDataAddress), err)
+	})
+}
@@ -0,0 +1,65 @@
+package modbusone
+
+import (
+	"errors"
+	"time"
+)
+
+// RetryPolicy configures an RTUClient to resend failed transactions, before
+// reporting the error to the caller. The zero value does not retry.
+type RetryPolicy struct {
+	_ struct{} // enforces keyed literals
+
+	// MaxAttempts is the max number of times a transaction is sent, 0 or 1 for no retries.
+	MaxAttempts int
+	// Retryable returns true for errors worth retrying, nil for DefaultRetryable.
+	Retryable func(err error) bool
+	// Backoff returns the delay before the given attempt (starting from 2),
+	// nil for no delay. See ExponentialBackoff.
+	Backoff func(attempt int) time.Duration
+	// RetryWrites allows writes to server to be retried. It is off by default,
+	// since a write could have been applied even if the reply is lost, and
+	// resending a write is not safe for all devices.
+	RetryWrites bool
+}
+
+// DefaultRetryable returns true for errors that are likely to be caused by a
+// bad line or a busy server: ErrServerTimeOut, ErrorCrc, and EcServerDeviceBusy
+// replies.
+func DefaultRetryable(err error) bool {
+	return errors.Is(err, ErrServerTimeOut) || errors.Is(err, ErrorCrc) || errors.Is(err, EcServerDeviceBusy)
+}
+
+// ExponentialBackoff returns a RetryPolicy.Backoff that starts at base before the
+// second attempt, and doubles for each following attempt, up to limit.
+func ExponentialBackoff(base, limit time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 2; i < attempt && d < limit; i++ {
+			d *= 2
+		}
+		return min(d, limit)
+	}
+}
+
+// shouldRetry returns true if the transaction of rtu should be sent again,
+// after attempt number of attempts have failed with err.
+func (p *RetryPolicy) shouldRetry(rtu RTU, attempt int, err error) bool {
+	if attempt >= p.MaxAttempts || rtu.IsMulticast() {
+		return false
+	}
+	if !p.RetryWrites && rtu.fastGetPDU().GetFunctionCode().IsWriteToServer() {
+		return false
+	}
+	if p.Retryable == nil {
+		return DefaultRetryable(err)
+	}
+	return p.Retryable(err)
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	if p.Backoff == nil {
+		return 0
+	}
+	return p.Backoff(attempt)
+}
@@ -0,0 +1,97 @@
+package modbusone_test
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+// dropWriter drops the first n writes.
+type dropWriter struct {
+	io.Writer
+	n int32
+}
+
+func (w *dropWriter) Write(p []byte) (int, error) {
+	if atomic.AddInt32(&w.n, -1) >= 0 {
+		return len(p), nil
+	}
+	return w.Writer.Write(p)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	slaveID := byte(0x11)
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	dw := &dropWriter{Writer: w2}
+
+	cc := newMockSerial(t, "c", r2, w1, w1)
+	sc := newMockSerial(t, "s", r1, dw, w2)
+	client := NewRTUClient(cc, slaveID)
+	client.SetServerProcessingTime(time.Second / 20)
+	defer client.Close()
+	server := NewRTUServer(sc, slaveID)
+	defer server.Close()
+
+	reads := int32(0)
+	h := &SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			atomic.AddInt32(&reads, 1)
+			return make([]uint16, quantity), nil
+		},
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			return nil
+		},
+	}
+	go client.Serve(h)
+	go server.Serve(h)
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	write, err := FcWriteSingleRegister.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+
+	// no retry by default
+	atomic.StoreInt32(&dw.n, 1)
+	err = client.DoTransaction(read)
+	require.True(t, errors.Is(err, ErrServerTimeOut), err)
+
+	client.SetRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     ExponentialBackoff(time.Millisecond, time.Millisecond*4),
+	})
+	atomic.StoreInt32(&dw.n, 2)
+	atomic.StoreInt32(&reads, 0)
+	require.NoError(t, client.DoTransaction(read))
+	require.Equal(t, int32(3), atomic.LoadInt32(&reads), "server should see all 3 attempts")
+	require.Equal(t, int64(2), atomic.LoadInt64(&cc.Stats().Retries))
+
+	atomic.StoreInt32(&dw.n, 3)
+	err = client.DoTransaction(read)
+	require.True(t, errors.Is(err, ErrServerTimeOut), "give up after MaxAttempts: %v", err)
+	require.Equal(t, int64(4), atomic.LoadInt64(&cc.Stats().Retries))
+
+	// writes are not retried unless opted in
+	atomic.StoreInt32(&dw.n, 1)
+	err = client.DoTransaction(write)
+	require.True(t, errors.Is(err, ErrServerTimeOut), err)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, RetryWrites: true})
+	atomic.StoreInt32(&dw.n, 1)
+	require.NoError(t, client.DoTransaction(write))
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff(time.Millisecond, time.Millisecond*5)
+	var got []time.Duration
+	for attempt := 2; attempt < 7; attempt++ {
+		got = append(got, b(attempt))
+	}
+	require.Equal(t, []time.Duration{
+		time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond,
+	}, got)
+}
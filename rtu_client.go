@@ -1,9 +1,11 @@
 package modbusone
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -11,11 +13,17 @@ import (
 // RTUClient implements Client/Master side logic for RTU over a SerialContext to
 // be used by a ProtocolHandler.
 type RTUClient struct {
-	com                  SerialContext
-	packetReader         PacketReader
-	SlaveID              byte
-	serverProcessingTime time.Duration
-	actions              chan rtuAction
+	com          SerialContext
+	packetReader PacketReader
+	SlaveID      byte
+	profiles     slaveProfiles
+	actions      chan rtuAction
+
+	configLock  sync.Mutex // protects settings that can be changed while serving
+	retryPolicy RetryPolicy
+	logger      instanceLogger
+	metrics     atomic.Pointer[Metrics]
+	tracer      instanceTracer
 }
 
 // Client interface can both start and serve transactions.
@@ -36,36 +44,144 @@ func NewRTUClient(com SerialContext, slaveID byte) *RTUClient {
 		pr = NewRTUPacketReader(com, true)
 	}
 	r := RTUClient{
-		com:                  com,
-		packetReader:         pr,
-		SlaveID:              slaveID,
-		serverProcessingTime: time.Second,
-		actions:              make(chan rtuAction),
+		com:          com,
+		packetReader: pr,
+		SlaveID:      slaveID,
+		profiles:     newSlaveProfiles(),
+		actions:      make(chan rtuAction),
+	}
+	if o, ok := com.(OptionContext); ok {
+		r.profiles.overSize.set(o.GetOption().OverSize)
 	}
 	return &r
 }
 
+// SetOverSize sets the OverSize of the client and its packet reader, nil for
+// the global settings. Over sized packets are only used with slaves with a
+// SlaveProfile.MaxPDUSize larger than MaxPDUSize.
+func (c *RTUClient) SetOverSize(o *OverSize) {
+	c.profiles.overSize.set(o)
+	if pr, ok := c.packetReader.(*rtuPacketReader); ok {
+		pr.setOverSize(o)
+	}
+}
+
 // SetServerProcessingTime sets the time to wait for a server response, the total
 // wait time also includes the time needed for data transmission.
+// It is the default for slaves without a ServerProcessingTime in their SlaveProfile.
 func (c *RTUClient) SetServerProcessingTime(t time.Duration) {
-	c.serverProcessingTime = t
+	c.profiles.setServerProcessingTime(t)
+}
+
+// SetSlaveProfile sets the timing and size settings used for transactions with slaveID.
+func (c *RTUClient) SetSlaveProfile(slaveID byte, p SlaveProfile) {
+	c.profiles.set(slaveID, p)
+}
+
+// GetSlaveProfile returns the settings used for slaveID, with defaults filled in.
+func (c *RTUClient) GetSlaveProfile(slaveID byte) SlaveProfile {
+	return c.profiles.get(slaveID, c.com)
 }
 
 // GetTransactionTimeOut returns the total time to wait for a transaction
-// (server response) to time out, given the expected length of RTU packets.
-// This function is also used internally to calculate timeout.
+// (server response) to time out, given the expected length of RTU packets,
+// with the default SlaveID.
 func (c *RTUClient) GetTransactionTimeOut(reqLen, ansLen int) time.Duration {
-	l := reqLen + ansLen
-	return c.com.BytesDelay(l) + c.serverProcessingTime
+	return c.GetSlaveTransactionTimeOut(c.SlaveID, reqLen, ansLen)
+}
+
+// GetSlaveTransactionTimeOut is GetTransactionTimeOut for slaveID.
+// This function is also used internally to calculate timeout.
+func (c *RTUClient) GetSlaveTransactionTimeOut(slaveID byte, reqLen, ansLen int) time.Duration {
+	return c.profiles.transactionTimeOut(slaveID, c.com, reqLen, ansLen)
+}
+
+// MakePDURequestHeaders is like the MakePDURequestHeaders function, but splits
+// quantity to fit the MaxPDUSize of slaveID.
+func (c *RTUClient) MakePDURequestHeaders(slaveID byte, fc FunctionCode, address, quantity uint16, appendTO []PDU) ([]PDU, error) {
+	return c.profiles.makePDURequestHeaders(slaveID, c.com, fc, address, quantity, appendTO)
+}
+
+// SetAdaptiveTimeout sets how the processing time of each slave is learned from
+// observed reply latency. Previously learned latencies are cleared.
+func (c *RTUClient) SetAdaptiveTimeout(a AdaptiveTimeout) {
+	c.profiles.setAdaptive(a)
+}
+
+// GetLearnedLatency returns the observed server processing time of slaveID at the
+// AdaptiveTimeout.Percentile, and the number of samples it is based on.
+// Transmission time is not included. Timeouts are counted as samples of the time waited.
+func (c *RTUClient) GetLearnedLatency(slaveID byte) (latency time.Duration, samples int) {
+	return c.profiles.learnedLatency(slaveID)
+}
+
+// SetMetrics sets where transactions are reported, nil to stop reporting.
+// Each attempt of a retried transaction is reported.
+func (c *RTUClient) SetMetrics(m *Metrics) {
+	c.metrics.Store(m)
+}
+
+// SetTracer sets the Tracer of transactions, nil to stop tracing.
+func (c *RTUClient) SetTracer(t Tracer) {
+	c.tracer.set(t)
+}
+
+// SetRetryPolicy sets how failed transactions are retried, retry counts are
+// recorded in Stats.Retries. It takes effect from the next transaction.
+func (c *RTUClient) SetRetryPolicy(p RetryPolicy) {
+	c.configLock.Lock()
+	c.retryPolicy = p
+	c.configLock.Unlock()
+}
+
+func (c *RTUClient) getRetryPolicy() RetryPolicy {
+	c.configLock.Lock()
+	defer c.configLock.Unlock()
+	return c.retryPolicy
 }
 
 type rtuAction struct {
 	t       clientActionType
+	ctx     context.Context //nolint:containedctx // passed to Tracer only
 	data    RTU
+	buf     *[]byte // pooled buffer of data, nil if data is not pooled
 	err     error
 	errChan chan<- error
 }
 
+// rtuBufferPool holds buffers of MaxRTUSize, for frames read by RTUClient
+// without garbage. Frames written are not pooled, as they are given to the
+// SerialContext and Tracer.
+var rtuBufferPool = sync.Pool{New: func() any {
+	b := make([]byte, MaxRTUSize)
+	return &b
+}}
+
+// newStartAction makes a clientStart action of req to slaveID.
+func newStartAction(slaveID byte, req PDU, errChan chan<- error) rtuAction {
+	return rtuAction{t: clientStart, data: MakeRTU(slaveID, req), errChan: errChan}
+}
+
+// newRTUBuffer returns a buffer for frames of size, from the pool if size is
+// not over sized.
+func newRTUBuffer(size int) *[]byte {
+	if size <= MaxRTUSize {
+		return rtuBufferPool.Get().(*[]byte)
+	}
+	b := make([]byte, size)
+	return &b
+}
+
+// release returns the buffer of data to the pool, data must not be used after.
+func (a *rtuAction) release() {
+	if a.buf != nil {
+		if len(*a.buf) == MaxRTUSize {
+			rtuBufferPool.Put(a.buf)
+		}
+		a.buf = nil
+	}
+}
+
 // ErrServerTimeOut is the time out error for StartTransaction.
 var ErrServerTimeOut = errors.New("server timed out")
 
@@ -132,17 +248,19 @@ func (c *RTUClient) ServeRTU(handler RTUProtocolHandler) error {
 		// data is always new(ish), to dump data out that is received during an
 		// unexpected time.
 		for {
-			rb := make([]byte, MaxRTUSize)
-			n, err := c.packetReader.Read(rb)
+			rb := newRTUBuffer(c.profiles.overSize.get().maxRTUSize())
+			n, err := c.packetReader.Read(*rb)
 			if err != nil {
-				debugf("RTUClient read err:%v\n", err)
+				c.logger.logErr(levelError, "RTUClient read error", err)
 				c.actions <- rtuAction{t: clientError, err: err}
 				c.Close()
 				break
 			}
-			r := RTU(rb[:n])
-			debugf("RTUClient read packet:%v\n", hex.EncodeToString(r))
-			c.actions <- rtuAction{t: clientRead, data: r}
+			r := RTU((*rb)[:n])
+			if c.logger.enabled() {
+				c.logger.debug("RTUClient read packet", "bytes", rtuLog(r, false))
+			}
+			c.actions <- rtuAction{t: clientRead, data: r, buf: rb}
 		}
 	}()
 
@@ -151,7 +269,8 @@ func (c *RTUClient) ServeRTU(handler RTUProtocolHandler) error {
 		switch act.t {
 		default:
 			atomic.AddInt64(&c.com.Stats().OtherDrops, 1)
-			debugf("RTUClient drop unexpected: %v", act)
+			c.logger.debug("RTUClient drop unexpected", "action", act.t, "bytes", hexBytes(act.data))
+			act.release()
 			continue
 		case clientError:
 			return act.err
@@ -159,97 +278,165 @@ func (c *RTUClient) ServeRTU(handler RTUProtocolHandler) error {
 		}
 		ap := act.data.fastGetPDU()
 		afc := ap.GetFunctionCode()
+		tracer := c.tracer.get()
+		if act.ctx == nil {
+			act.ctx = context.Background()
+		}
+		act.ctx = tracer.TransactionStart(act.ctx, newTraceInfo(false, "rtu", act.data[0], ap))
 		if afc.IsWriteToServer() {
+			tracer.HandlerInvoked(act.ctx, "OnRead")
 			data, err := handler.OnRead(act.data.fastGetHeader())
 			if err != nil {
+				tracer.TransactionEnd(act.ctx, err)
 				act.errChan <- err
 				continue
 			}
 			act.data = MakeRTU(act.data[0], ap.MakeWriteRequest(data))
 		}
-		time.Sleep(c.com.MinDelay())
-		_, err := c.com.Write(act.data)
-		if err != nil {
-			act.errChan <- err
-			return err
-		}
-		if act.data[0] == 0 {
-			time.Sleep(c.com.BytesDelay(len(act.data)))
-			act.errChan <- nil // always success
-			continue           // do not wait for read on multicast
+		retryPolicy := c.getRetryPolicy()
+		for attempt := 1; ; attempt++ {
+			err, ioErr := c.transact(tracer, handler, act)
+			if ioErr != nil {
+				tracer.TransactionEnd(act.ctx, ioErr)
+				act.errChan <- ioErr
+				return ioErr
+			}
+			if err == nil || !retryPolicy.shouldRetry(act.data, attempt, err) {
+				if err != nil {
+					c.logger.logErr(levelWarn, "RTUClient transaction failed", err, requestLogArgs(act.data[0], act.data.fastGetPDU())...)
+				}
+				tracer.TransactionEnd(act.ctx, err)
+				act.errChan <- err // success if nil
+				break
+			}
+			atomic.AddInt64(&c.com.Stats().Retries, 1)
+			c.logger.logErr(levelInfo, "RTUClient retry", err, append(requestLogArgs(act.data[0], act.data.fastGetPDU()), "attempt", attempt)...)
+			time.Sleep(retryPolicy.backoff(attempt + 1))
 		}
+	}
+}
 
-		timeOutChan := time.After(c.GetTransactionTimeOut(len(act.data), MaxRTUSize))
+// transact sends one attempt of act and waits for the reply.
+// err is the result of the transaction, while ioErr is set to end the client.
+func (c *RTUClient) transact(tracer Tracer, handler RTUProtocolHandler, act rtuAction) (err, ioErr error) {
+	afc := act.data.fastGetPDU().GetFunctionCode()
+	profile := c.GetSlaveProfile(act.data[0])
+	// over sized for the slave only
+	overSize := OverSize{Support: profile.MaxPDUSize > MaxPDUSize, MaxRTU: profile.MaxPDUSize + 3}
+	if pr, ok := c.packetReader.(*rtuPacketReader); ok {
+		if n := overSizedReplySize(act.data.fastGetPDU()); n > 0 && overSize.Support {
+			pr.expectReply(n + 3)
+		} else {
+			pr.expectReply(0)
+		}
+	}
+	time.Sleep(profile.InterFrameDelay)
+	sentAt := time.Now()
+	if c.logger.enabled() {
+		c.logger.debug("RTUClient write packet", "bytes", rtuLog(act.data, true))
+	}
+	_, ioErr = c.com.Write(act.data)
+	if ioErr != nil {
+		c.logger.logErr(levelError, "RTUClient write error", ioErr, "slave_id", act.data[0])
+		return nil, ioErr
+	}
+	tracer.FrameSent(act.ctx, act.data)
+	defer func() {
+		if ioErr == nil {
+			c.metrics.Load().observeClient(act.data[0], afc, time.Since(sentAt), err)
+		}
+	}()
+	if act.data[0] == 0 {
+		time.Sleep(c.com.BytesDelay(len(act.data)))
+		return nil, nil // always success, do not wait for read on multicast
+	}
 
-	READ_LOOP:
-		for {
-		SELECT:
-			select {
-			case <-timeOutChan:
-				act.errChan <- ErrServerTimeOut
-				break READ_LOOP
-			case react := <-c.actions:
-				switch react.t {
-				default:
-					err := fmt.Errorf("unexpected action:%s", react.t)
-					act.errChan <- err
-					return err
-				case clientError:
-					return react.err
-				case clientRead:
-					// test for read error
-					if react.err != nil {
-						return react.err
-					}
-				}
-				if react.data[0] != act.data[0] {
-					atomic.AddInt64(&c.com.Stats().IDDrops, 1)
-					debugf("RTUClient unexpected slaveId:%v in %v\n", act.data[0], hex.EncodeToString(react.data))
-					break SELECT
-				}
-				rp, err := react.data.GetPDU()
-				if err != nil {
-					if errors.Is(err, ErrorCrc) {
-						atomic.AddInt64(&c.com.Stats().CrcErrors, 1)
-					} else {
-						atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
-					}
-					act.errChan <- err
-					break READ_LOOP
-				}
-				hasErr, fc := rp.GetFunctionCode().SeparateError()
-				if hasErr && fc == afc {
-					atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
-					handler.OnError(act.data.fastGetHeader(), react.data.fastGetHeader())
-					ec := ExceptionCode(rp[1])
-					act.errChan <- fmt.Errorf("server reply with exception:%v %w", hex.EncodeToString(rp), ec)
-					break READ_LOOP
-				}
-				if !IsRequestReply(act.data.fastGetPDU(), rp) {
-					atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
-					act.errChan <- fmt.Errorf("unexpected reply:%v", hex.EncodeToString(rp))
-					break READ_LOOP
-				}
-				if afc.IsReadToServer() {
-					// read from server, write here
-					bs, err := rp.GetReplyValues()
-					if err != nil {
-						atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
-						act.errChan <- err
-						break READ_LOOP
-					}
-					err = handler.OnWrite(act.data.fastGetHeader(), bs)
-					if err != nil {
-						atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
-					}
-					act.errChan <- err // success if nil
-					break READ_LOOP
-				}
-				act.errChan <- nil // success
-				break READ_LOOP
+	timeOutChan := time.After(c.GetSlaveTransactionTimeOut(act.data[0], len(act.data), profile.MaxPDUSize+3))
+	var react rtuAction
+	for {
+		select {
+		case <-timeOutChan:
+			// the reply took at least as long as waited
+			c.profiles.observe(act.data[0], time.Since(sentAt)-c.com.BytesDelay(len(act.data)))
+			return ErrServerTimeOut, nil
+		case react = <-c.actions:
+		}
+		switch react.t {
+		default:
+			return nil, fmt.Errorf("unexpected action:%s", react.t)
+		case clientError:
+			return nil, react.err
+		case clientRead:
+			// test for read error
+			if react.err != nil {
+				return nil, react.err
 			}
 		}
+		if react.data[0] != act.data[0] {
+			atomic.AddInt64(&c.com.Stats().IDDrops, 1)
+			c.logger.log(levelWarn, "RTUClient unexpected slaveId", "slave_id", act.data[0], "bytes", hexBytes(react.data))
+			react.release()
+			continue
+		}
+		break
+	}
+	defer react.release()
+	c.profiles.observe(act.data[0], time.Since(sentAt)-c.com.BytesDelay(len(act.data)+len(react.data)))
+	tracer.FrameReceived(act.ctx, frameCopy(tracer, react.data))
+	rp, err := react.data.GetPDU()
+	if err != nil {
+		if errors.Is(err, ErrorCrc) {
+			atomic.AddInt64(&c.com.Stats().CrcErrors, 1)
+		} else {
+			atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
+		}
+		return err, nil
+	}
+	hasErr, fc := rp.GetFunctionCode().SeparateError()
+	if hasErr && fc == afc {
+		atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
+		tracer.HandlerInvoked(act.ctx, "OnError")
+		handler.OnError(act.data.fastGetHeader(), react.data.getHeaderCopy())
+		ec := ExceptionCode(rp[1])
+		return fmt.Errorf("server reply with exception:%v %w", hex.EncodeToString(rp), ec), nil
+	}
+	if !overSize.isRequestReply(&c.logger, act.data.fastGetPDU(), rp) {
+		atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
+		return fmt.Errorf("unexpected reply:%v", hex.EncodeToString(rp)), nil
+	}
+	if afc.IsReadToServer() {
+		// read from server, write here
+		bs, err := rp.getReplyValues(overSize.Support)
+		if err != nil {
+			atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
+			return err, nil
+		}
+		tracer.HandlerInvoked(act.ctx, "OnWrite")
+		err = handler.OnWrite(act.data.fastGetHeader(), append([]byte(nil), bs...))
+		if err != nil {
+			atomic.AddInt64(&c.com.Stats().OtherErrors, 1)
+		}
+		return err, nil // success if nil
 	}
+	return nil, nil // success
+}
+
+// overSizedReplySize returns the PDU size of the reply to an over sized read
+// request, when the number of bytes in the reply can not be encoded, or 0.
+func overSizedReplySize(p PDU) int {
+	fc := p.GetFunctionCode()
+	count, err := p.GetRequestCount()
+	if !fc.IsReadToServer() || err != nil {
+		return 0
+	}
+	n := (int(count) + 7) / 8
+	if fc.IsUint16() {
+		n = int(count) * 2
+	}
+	if n <= 0xFF {
+		return 0
+	}
+	return n + 2 // function code, number of bytes, and values
 }
 
 // Close closes the client and closes the connection.
@@ -267,6 +454,27 @@ func (c *RTUClient) DoTransaction(req PDU) error {
 	return <-errChan
 }
 
+// DoTransactionContext is DoTransaction with a custom slaveID, where ctx is the
+// parent context given to the Tracer. If ctx is done before the transaction
+// completes, it returns ctx.Err() without waiting, while the transaction
+// continues.
+func (c *RTUClient) DoTransactionContext(ctx context.Context, slaveID byte, req PDU) error {
+	errChan := make(chan error, 1)
+	act := newStartAction(slaveID, req, errChan)
+	act.ctx = ctx
+	select {
+	case c.actions <- act:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // DoRTUTransaction starts a blocking transaction by wrapping StartTransactionToServer.
 //
 // RTU is currently required to be valid, but is not sent as is for write to servers,
@@ -286,7 +494,7 @@ func DoRTUTransaction(c Client, header RTUHeader) error {
 // For read from server, the PDU is sent as is (after been warped up in RTU)
 // For write to server, the data part given will be ignored, and filled in by data from handler.
 func (c *RTUClient) StartTransactionToServer(slaveID byte, req PDU, errChan chan error) {
-	c.actions <- rtuAction{t: clientStart, data: MakeRTU(slaveID, req), errChan: errChan}
+	c.actions <- newStartAction(slaveID, req, errChan)
 }
 
 // RTUTransactionStarter is an interface implemented by RTUClient.
@@ -322,6 +530,12 @@ func MakePDURequestHeaders(fc FunctionCode, address, quantity uint16, appendTO [
 //
 // You can use FunctionCode.MaxPerPacketSized to calculate one with the wanted byte length.
 func MakePDURequestHeadersSized(fc FunctionCode, address, quantity uint16, maxPerPacket uint16, appendTO []PDU) ([]PDU, error) {
+	return makePDURequestHeadersSized(fc, address, quantity, maxPerPacket, false, appendTO)
+}
+
+// makePDURequestHeadersSized is MakePDURequestHeadersSized, which allows
+// maxPerPacket to be over sized with overSize.
+func makePDURequestHeadersSized(fc FunctionCode, address, quantity uint16, maxPerPacket uint16, overSize bool, appendTO []PDU) ([]PDU, error) {
 	if uint(address)+uint(quantity) > uint(fc.MaxRange()) {
 		return nil, fmt.Errorf("quantity is out of range")
 	}
@@ -330,7 +544,7 @@ func MakePDURequestHeadersSized(fc FunctionCode, address, quantity uint16, maxPe
 		if quantity < maxPerPacket {
 			q = quantity
 		}
-		pdu, err := fc.MakeRequestHeader(address, q)
+		pdu, err := fc.makeRequestHeader(address, q, overSize)
 		if err != nil {
 			return nil, err
 		}
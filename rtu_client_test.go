@@ -0,0 +1,56 @@
+package modbusone_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+// keepingHandler keeps every req and data given to OnWrite.
+type keepingHandler struct {
+	reqs []PDU
+	data [][]byte
+}
+
+func (h *keepingHandler) OnWrite(req PDU, data []byte) error {
+	h.reqs = append(h.reqs, req)
+	h.data = append(h.data, data)
+	return nil
+}
+
+func (h *keepingHandler) OnRead(req PDU) ([]byte, error) { return nil, EcIllegalFunction }
+
+func (h *keepingHandler) OnError(req PDU, errRep PDU) {}
+
+func TestRTUClientHandlerKeepsData(t *testing.T) {
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	server := NewRTUServer(newMockSerial(t, "s", r1, w2, w2), 1)
+	client := NewRTUClient(newMockSerial(t, "c", r2, w1, w1), 1)
+	client.SetServerProcessingTime(50 * time.Millisecond)
+	defer client.Close()
+	sh := NewMemoryHandler(10)
+	kh := &keepingHandler{}
+	go server.Serve(sh)
+	go client.Serve(kh)
+
+	reads := []struct {
+		address uint16
+		value   uint16
+	}{{1, 0x1111}, {2, 0x2222}, {3, 0x3333}}
+	for _, r := range reads {
+		require.NoError(t, sh.WriteRegisters(TableHoldingRegisters, r.address, []uint16{r.value}))
+		req, err := FcReadHoldingRegisters.MakeRequestHeader(r.address, 1)
+		require.NoError(t, err)
+		require.NoError(t, client.DoTransaction(req))
+	}
+
+	require.Len(t, kh.data, len(reads))
+	for i, r := range reads {
+		require.Equal(t, r.address, kh.reqs[i].GetAddress(), "req %v is not reused", i)
+		require.Equal(t, []byte{byte(r.value >> 8), byte(r.value)}, kh.data[i], "data %v is not reused", i)
+	}
+}
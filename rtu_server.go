@@ -1,7 +1,7 @@
 package modbusone
 
 import (
-	"encoding/hex"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -12,19 +12,45 @@ import (
 )
 
 // RTUServer implements Server/Slave side logic for RTU over a SerialContext to
-// be used by a ProtocolHandler.
+// be used by a ProtocolHandler, or a RTUProtocolHandler for many slave IDs.
 type RTUServer struct {
 	com          SerialContext
 	packetReader PacketReader
-	SlaveID      byte
+	SlaveID      byte                       // read once when serving starts, use SetSlaveIDs after
+	slaveIDs     atomic.Pointer[slaveIDSet] // nil to listen on SlaveID, until served
+	listenOnly   atomic.Bool
+	logger       instanceLogger
+	metrics      atomic.Pointer[Metrics]
+	tracer       instanceTracer
+	overSize     instanceOverSize
 }
 
 // NewRTUServer creates a RTU server on SerialContext listening on slaveID.
 func NewRTUServer(com SerialContext, slaveID byte) *RTUServer {
+	return newRTUServer(com, slaveID, nil)
+}
+
+// NewMultiIDRTUServer creates a RTU server on SerialContext listening on all
+// slaveIDs, such as to simulate many devices on one serial port. Use ServeRTU
+// with a MultiIDHandler to serve each slave ID with its own ProtocolHandler.
+// SlaveID is set to the first of slaveIDs, but is not used.
+func NewMultiIDRTUServer(com SerialContext, slaveIDs ...byte) *RTUServer {
+	var slaveID byte
+	if len(slaveIDs) > 0 {
+		slaveID = slaveIDs[0]
+	}
+	return newRTUServer(com, slaveID, newSlaveIDSet(slaveIDs...))
+}
+
+func newRTUServer(com SerialContext, slaveID byte, slaveIDs *slaveIDSet) *RTUServer {
 	pr, ok := com.(PacketReader)
 	if !ok {
 		if v3, ok := com.(SerialContextV3); ok {
-			pr = NewRTUPacketReader2(v3, false, slaveID)
+			if slaveIDs != nil {
+				pr = newRTUPacketReaderIDs(v3, false, slaveIDs)
+			} else {
+				pr = NewRTUPacketReader2(v3, false, slaveID)
+			}
 		} else {
 			pr = NewRTUPacketReader(com, false)
 		}
@@ -34,46 +60,176 @@ func NewRTUServer(com SerialContext, slaveID byte) *RTUServer {
 		packetReader: pr,
 		SlaveID:      slaveID,
 	}
+	r.slaveIDs.Store(slaveIDs)
+	if o, ok := com.(OptionContext); ok {
+		r.overSize.set(o.GetOption().OverSize)
+	}
 	return &r
 }
 
+// SetOverSize sets the OverSize of the server and its packet reader, nil for
+// the global settings. It should be set before Serve.
+func (s *RTUServer) SetOverSize(o *OverSize) {
+	s.overSize.set(o)
+	if pr, ok := s.packetReader.(*rtuPacketReader); ok {
+		pr.setOverSize(o)
+	}
+}
+
+// SetMetrics sets where requests are reported, nil to stop reporting.
+func (s *RTUServer) SetMetrics(m *Metrics) {
+	s.metrics.Store(m)
+}
+
+// SetTracer sets the Tracer of requests, nil to stop tracing.
+func (s *RTUServer) SetTracer(t Tracer) {
+	s.tracer.set(t)
+}
+
+// SetSlaveIDs sets the slave IDs the server listens on, such as from a
+// configuration register while serving. SlaveID is not used after. An error is
+// returned, and the slave IDs are not changed, if slaveIDs is empty, or any of
+// slaveIDs is 0 for broadcast or is more than 247.
+//
+// The packet reader of the server is updated, unless the SerialContext is a
+// PacketReader not made by this package, which may still read packets for the
+// slave IDs it was made with.
+func (s *RTUServer) SetSlaveIDs(slaveIDs ...byte) error {
+	if len(slaveIDs) == 0 {
+		return errors.New("no slaveIDs to listen on")
+	}
+	for _, id := range slaveIDs {
+		if id == 0 {
+			return errors.New("slaveID 0 is for broadcast")
+		}
+		if _, err := Uint64ToSlaveID(uint64(id)); err != nil {
+			return err
+		}
+	}
+	set := newSlaveIDSet(slaveIDs...)
+	if pr, ok := s.packetReader.(*rtuPacketReader); ok {
+		pr.slaveIDs.Store(set)
+	}
+	s.slaveIDs.Store(set)
+	return nil
+}
+
+// SlaveIDs returns the slave IDs the server listens on.
+func (s *RTUServer) SlaveIDs() []byte {
+	set := s.slaveIDs.Load()
+	if set == nil {
+		return []byte{s.SlaveID}
+	}
+	return set.ids()
+}
+
+// isOwnID returns true if the server listens on slaveID, it is only used while
+// serving, when the slave IDs are set.
+func (s *RTUServer) isOwnID(slaveID byte) bool {
+	return s.slaveIDs.Load().has(slaveID)
+}
+
+// SetListenOnly sets if the server is in listen only mode, where it never
+// writes to the SerialContext. Requests to the server are dropped, and counted
+// in Stats().OtherDrops, while broadcasts are still handled.
+func (s *RTUServer) SetListenOnly(listenOnly bool) {
+	s.listenOnly.Store(listenOnly)
+}
+
+// IsListenOnly returns true if the server is in listen only mode.
+func (s *RTUServer) IsListenOnly() bool {
+	return s.listenOnly.Load()
+}
+
 // Serve runs the server and only returns after unrecoverable error, such as
-// SerialContext is closed.
+// SerialContext is closed. The handler serves all slave IDs of the server, and
+// broadcast writes once. Broadcast reads are passed to OnRead, but not replied.
 func (s *RTUServer) Serve(handler ProtocolHandler) error {
+	return s.ServeRTU(anyIDHandler{handler})
+}
+
+// anyIDHandler is a RTUProtocolHandler that serves all slave IDs with the
+// same ProtocolHandler.
+type anyIDHandler struct {
+	h ProtocolHandler
+}
+
+func (a anyIDHandler) OnWrite(req RTUHeader, data []byte) error {
+	return a.h.OnWrite(req.PDU, data)
+}
+
+func (a anyIDHandler) OnRead(req RTUHeader) ([]byte, error) {
+	return a.h.OnRead(req.PDU)
+}
+
+func (a anyIDHandler) OnError(req RTUHeader, errRep RTUHeader) {
+	a.h.OnError(req.PDU, errRep.PDU)
+}
+
+// ServeRTU is Serve, with requests to each slave ID handled by handler with
+// the slave ID, such as by a MultiIDHandler. Broadcast writes are handled once
+// for each slave ID of the server, and broadcast reads are not handled.
+func (s *RTUServer) ServeRTU(handler RTUProtocolHandler) error {
 	defer s.Close()
+	// SlaveID is read once here, so it is not read while SetSlaveIDs can be called.
+	s.slaveIDs.CompareAndSwap(nil, newSlaveIDSet(s.SlaveID))
 
 	delay := s.com.MinDelay()
+	overSize := s.overSize.get()
+	rb := make([]byte, overSize.maxRTUSize())
 
-	var rb []byte
-	if IsOverSizeSupported() {
-		rb = make([]byte, OverSizeMaxRTU)
-	} else {
-		rb = make([]byte, MaxRTUSize)
-	}
+	// buffer of read reply PDUs, reused for every reply
+	pb := make([]byte, 0, len(rb))
 
 	var p PDU
+	var tracer Tracer
+	var ctx context.Context
 
 	var ioErr error // make continue do io error checking
-	wp := func(pdu PDU, slaveId byte) {
-		if slaveId == 0 {
+	// reply writes pdu unless it is a broadcast, and ends the transaction with result.
+	reply := func(pdu PDU, slaveId byte, result error) {
+		if slaveId == 0 || s.listenOnly.Load() {
+			tracer.TransactionEnd(ctx, result)
 			return
 		}
 		time.Sleep(delay)
-		_, ioErr = s.com.Write(MakeRTU(slaveId, pdu))
+		rtu := MakeRTU(slaveId, pdu)
+		_, ioErr = s.com.Write(rtu)
+		if ioErr != nil {
+			s.logger.logErr(levelError, "RTUServer write error", ioErr, "slave_id", slaveId)
+			tracer.TransactionEnd(ctx, ioErr)
+			return
+		}
+		tracer.FrameSent(ctx, rtu)
+		tracer.TransactionEnd(ctx, result)
+	}
+	wp := func(pdu PDU, slaveId byte) {
+		reply(pdu, slaveId, nil)
 	}
 	wec := func(err error, slaveId byte) {
-		wp(ExceptionReplyPacket(p, ToExceptionCode(err)), slaveId)
+		ec := ToExceptionCode(err)
+		reply(ExceptionReplyPacket(p, ec), slaveId, ec)
+	}
+	var handlerStart time.Time
+	observe := func(err error, slaveId byte) {
+		if err != nil {
+			err = ToExceptionCode(err)
+		}
+		s.metrics.Load().observeServer(slaveId, p.GetFunctionCode(), time.Since(handlerStart), err)
 	}
 
 	for ioErr == nil {
 		var n int
-		debugf("RTUServer wait for read\n")
+		s.logger.debug("RTUServer wait for read")
 		n, ioErr = s.packetReader.Read(rb)
 		if ioErr != nil {
+			s.logger.logErr(levelError, "RTUServer read error", ioErr)
 			return ioErr
 		}
 		r := RTU(rb[:n])
-		debugf("RTUServer read packet:%v\n", hex.EncodeToString(r))
+		if s.logger.enabled() {
+			s.logger.debug("RTUServer read packet", "bytes", rtuLog(r, true))
+		}
 		var err error
 		p, err = r.GetPDU()
 		if err != nil {
@@ -82,43 +238,64 @@ func (s *RTUServer) Serve(handler ProtocolHandler) error {
 			} else {
 				atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
 			}
-			debugf("RTUServer drop read packet:%v\n", err)
+			s.logger.logErr(levelWarn, "RTUServer drop read packet", err, "bytes", hexBytes(r))
 			continue
 		}
-		if r[0] != 0 && r[0] != s.SlaveID {
+		if r[0] != 0 && !s.isOwnID(r[0]) {
 			atomic.AddInt64(&s.com.Stats().IDDrops, 1)
-			debugf("RTUServer drop packet to other id:%v\n", r[0])
+			s.logger.debug("RTUServer drop packet to other id", "slave_id", r[0])
+			continue
+		}
+		if r[0] != 0 && s.listenOnly.Load() {
+			atomic.AddInt64(&s.com.Stats().OtherDrops, 1)
+			s.logger.debug("RTUServer drop packet in listen only mode", "slave_id", r[0])
 			continue
 		}
-		err = p.ValidateRequest()
+		tracer = s.tracer.get()
+		ctx = tracer.TransactionStart(context.Background(), newTraceInfo(true, "rtu", r[0], p))
+		tracer.FrameReceived(ctx, frameCopy(tracer, r))
+		err = p.validateServerRequest(overSize.Support)
 		if err != nil {
 			atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
-			debugf("RTUServer auto return for error:%v\n", err)
+			s.logger.logErr(levelWarn, "RTUServer auto return for error", err, "slave_id", r[0], "fc", p.GetFunctionCode())
+			handlerStart = time.Now()
+			observe(err, r[0])
 			wec(err, r[0])
 			continue
 		}
+		p = append(PDU(nil), p...) // handlers may keep req and data, while rb is reused
 		fc := p.GetFunctionCode()
+		handlerStart = time.Now()
 		if fc.IsReadToServer() {
-			data, err := handler.OnRead(p)
+			if _, ok := handler.(anyIDHandler); !ok && r[0] == 0 {
+				tracer.TransactionEnd(ctx, nil) // broadcast reads are not handled
+				continue
+			}
+			tracer.HandlerInvoked(ctx, "OnRead")
+			data, err := handler.OnRead(RTUHeader{SlaveID: r[0], PDU: p})
+			observe(err, r[0])
 			if err != nil {
 				atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
-				debugf("RTUServer handler.OnOutput error:%v\n", err)
+				s.logger.logErr(levelWarn, "RTUServer handler.OnOutput error", err, requestLogArgs(r[0], p)...)
 				wec(err, r[0])
 				continue
 			}
-			wp(p.MakeReadReply(data), r[0])
+			wp(p.AppendReadReply(pb[:0], data), r[0])
 		} else if fc.IsWriteToServer() {
-			data, err := p.GetRequestValues()
+			data, err := p.getRequestValues(overSize.Support)
 			if err != nil {
+				observe(err, r[0])
 				atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
-				debugf("RTUServer p.GetRequestValues error:%v\n", err)
+				s.logger.logErr(levelWarn, "RTUServer p.GetRequestValues error", err, requestLogArgs(r[0], p)...)
 				wec(err, r[0])
 				continue
 			}
-			err = handler.OnWrite(p, data)
+			tracer.HandlerInvoked(ctx, "OnWrite")
+			err = s.onWrite(handler, r[0], p, data)
+			observe(err, r[0])
 			if err != nil {
 				atomic.AddInt64(&s.com.Stats().OtherErrors, 1)
-				debugf("RTUServer handler.OnInput error:%v\n", err)
+				s.logger.logErr(levelWarn, "RTUServer handler.OnInput error", err, requestLogArgs(r[0], p)...)
 				wec(err, r[0])
 				continue
 			}
@@ -128,6 +305,22 @@ func (s *RTUServer) Serve(handler ProtocolHandler) error {
 	return ioErr
 }
 
+// onWrite handles a write to slaveID, or to all slave IDs of the server for a
+// broadcast, where the first error is returned. Broadcasts to an anyIDHandler
+// are handled once, as the slave ID is not given to its ProtocolHandler.
+func (s *RTUServer) onWrite(handler RTUProtocolHandler, slaveID byte, p PDU, data []byte) error {
+	if _, ok := handler.(anyIDHandler); ok || slaveID != 0 {
+		return handler.OnWrite(RTUHeader{SlaveID: slaveID, PDU: p}, data)
+	}
+	var err error
+	for _, id := range s.SlaveIDs() {
+		if werr := handler.OnWrite(RTUHeader{SlaveID: id, PDU: p}, data); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
 // Close closes the server and closes the connect.
 func (s *RTUServer) Close() error {
 	return s.com.Close()
@@ -146,22 +339,31 @@ func Uint64ToSlaveID(n uint64) (byte, error) {
 var debugOutput atomic.Value
 
 // SetDebugOut to print debug messages, set to nil to turn off debug output.
+// Clients, servers, and packet readers with a logger set by SetLogger log there instead.
 func SetDebugOut(w io.Writer) {
 	debugOutput.Store(&w) // nil can not be store to atomic.Value directly, but a pointer to nil can.
 }
 
 const monkey = false
 
+// debugWriter returns the debug output, or nil if it is off.
+func debugWriter() io.Writer {
+	debugWriterP, _ := debugOutput.Load().(*io.Writer)
+	if debugWriterP == nil {
+		// SetDebugOut is never called
+		return nil
+	}
+	return *debugWriterP
+}
+
 func debugf(format string, a ...interface{}) {
 	if monkey && rand.Float32() < 0.5 { //nolint:gosec // Monkey testing's random doesn't need secure random numbers.
 		runtime.Gosched()
 	}
-	debugWriterP, _ := debugOutput.Load().(*io.Writer)
-	if debugWriterP == nil || *debugWriterP == nil {
-		// SetDebugOut is never called, or last set to nil
+	debugWriter := debugWriter()
+	if debugWriter == nil {
 		return
 	}
-	debugWriter := *debugWriterP
 	fmt.Fprintf(debugWriter, "[%s]", time.Now().Format("06-01-02 15:04:05.000000"))
 	fmt.Fprintf(debugWriter, format, a...)
 	lf := len(format)
@@ -0,0 +1,156 @@
+package modbusone_test
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestRTUServerListenOnly(t *testing.T) {
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	sc := newMockSerial(t, "s", r1, w2, w2)
+	server := NewRTUServer(sc, 1)
+	client := NewRTUClient(newMockSerial(t, "c", r2, w1, w1), 1)
+	client.SetServerProcessingTime(50 * time.Millisecond)
+	defer client.Close()
+	sh := NewMemoryHandler(10)
+	ch := NewMemoryHandler(10)
+	go server.Serve(sh)
+	go client.ServeRTU(MultiIDHandler{0: ch, 1: ch, 5: ch})
+	require.NoError(t, sh.WriteRegisters(TableHoldingRegisters, 0, []uint16{7}))
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	require.NoError(t, client.DoTransaction(read))
+
+	server.SetListenOnly(true)
+	require.True(t, server.IsListenOnly())
+	drops := atomic.LoadInt64(&sc.Stats().OtherDrops)
+	require.ErrorIs(t, client.DoTransaction(read), ErrServerTimeOut)
+	require.Equal(t, drops+1, atomic.LoadInt64(&sc.Stats().OtherDrops))
+
+	write, err := FcWriteSingleRegister.MakeRequestHeader(3, 1)
+	require.NoError(t, err)
+	require.NoError(t, ch.WriteRegisters(TableHoldingRegisters, 3, []uint16{33}))
+	require.NoError(t, DoRTUTransaction(client, RTUHeader{SlaveID: 0, PDU: write}))
+	require.Eventually(t, func() bool {
+		got, _ := sh.ReadRegisters(TableHoldingRegisters, 3, 1)
+		return got[0] == 33
+	}, time.Second, time.Millisecond, "broadcasts are handled in listen only mode")
+
+	server.SetListenOnly(false)
+	require.NoError(t, client.DoTransaction(read))
+}
+
+func TestRTUServerSetSlaveIDs(t *testing.T) {
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	server := NewRTUServer(newMockSerial(t, "s", r1, w2, w2), 1)
+	client := NewRTUClient(newMockSerial(t, "c", r2, w1, w1), 1)
+	client.SetServerProcessingTime(50 * time.Millisecond)
+	defer client.Close()
+	ch := NewMemoryHandler(10)
+	go server.Serve(NewMemoryHandler(10))
+	go client.ServeRTU(MultiIDHandler{1: ch, 5: ch, 6: ch})
+	require.Equal(t, []byte{1}, server.SlaveIDs())
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	require.NoError(t, DoRTUTransaction(client, RTUHeader{SlaveID: 1, PDU: read}))
+
+	require.Error(t, server.SetSlaveIDs(), "listens on nothing")
+	require.Error(t, server.SetSlaveIDs(5, 0), "0 is for broadcast")
+	require.Error(t, server.SetSlaveIDs(5, 248), "248 is reserved")
+	require.Equal(t, []byte{1}, server.SlaveIDs(), "not changed on error")
+	require.NoError(t, server.SetSlaveIDs(6, 5))
+	require.Equal(t, []byte{5, 6}, server.SlaveIDs())
+	require.ErrorIs(t, DoRTUTransaction(client, RTUHeader{SlaveID: 1, PDU: read}), ErrServerTimeOut)
+	require.NoError(t, DoRTUTransaction(client, RTUHeader{SlaveID: 5, PDU: read}))
+	require.NoError(t, DoRTUTransaction(client, RTUHeader{SlaveID: 6, PDU: read}))
+}
+
+func TestRTUServerSetSlaveIDsBroadcast(t *testing.T) {
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	server := NewRTUServer(newMockSerial(t, "s", r1, w2, w2), 1)
+	client := NewRTUClient(newMockSerial(t, "c", r2, w1, w1), 1)
+	client.SetServerProcessingTime(50 * time.Millisecond)
+	defer client.Close()
+	require.NoError(t, server.SetSlaveIDs(5, 6))
+	var writes int32
+	go server.Serve(&SimpleHandler{
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			atomic.AddInt32(&writes, 1)
+			return nil
+		},
+	})
+	ch := NewMemoryHandler(10)
+	go client.ServeRTU(MultiIDHandler{0: ch, 5: ch})
+
+	write, err := FcWriteSingleRegister.MakeRequestHeader(3, 1)
+	require.NoError(t, err)
+	require.NoError(t, DoRTUTransaction(client, RTUHeader{SlaveID: 0, PDU: write}))
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&writes) > 0
+	}, time.Second, time.Millisecond)
+	require.NoError(t, DoRTUTransaction(client, RTUHeader{SlaveID: 5, PDU: write}))
+	require.Equal(t, int32(2), atomic.LoadInt32(&writes), "broadcast is written once")
+}
+
+func TestRTUServerBroadcastRead(t *testing.T) {
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	sc := newMockSerial(t, "s", r1, w2, w2)
+	server := NewRTUServer(sc, 1)
+	client := NewRTUClient(newMockSerial(t, "c", r2, w1, w1), 1)
+	client.SetServerProcessingTime(50 * time.Millisecond)
+	defer client.Close()
+	var reads int32
+	go server.Serve(&SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			atomic.AddInt32(&reads, 1)
+			return make([]uint16, quantity), nil
+		},
+	})
+	go client.ServeRTU(MultiIDHandler{0: NewMemoryHandler(10)})
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(0, 1)
+	require.NoError(t, err)
+	require.NoError(t, DoRTUTransaction(client, RTUHeader{SlaveID: 0, PDU: read}))
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&reads) == 1
+	}, time.Second, time.Millisecond, "Serve passes broadcast reads to OnRead")
+	require.Zero(t, atomic.LoadInt64(&sc.Stats().OtherErrors))
+}
+
+func TestRTUServerHandlerKeepsData(t *testing.T) {
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	server := NewRTUServer(newMockSerial(t, "s", r1, w2, w2), 1)
+	client := NewRTUClient(newMockSerial(t, "c", r2, w1, w1), 1)
+	client.SetServerProcessingTime(50 * time.Millisecond)
+	defer client.Close()
+	kh := &keepingHandler{}
+	ch := NewMemoryHandler(10)
+	go server.Serve(kh)
+	go client.Serve(ch)
+
+	values := []uint16{0x1111, 0x2222, 0x3333}
+	for i, v := range values {
+		require.NoError(t, ch.WriteRegisters(TableHoldingRegisters, uint16(i), []uint16{v}))
+		req, err := FcWriteSingleRegister.MakeRequestHeader(uint16(i), 1)
+		require.NoError(t, err)
+		require.NoError(t, client.DoTransaction(req))
+	}
+
+	require.Len(t, kh.data, len(values))
+	for i, v := range values {
+		require.Equal(t, uint16(i), kh.reqs[i].GetAddress(), "req %v is not reused", i)
+		require.Equal(t, []byte{byte(v >> 8), byte(v)}, kh.data[i], "data %v is not reused", i)
+	}
+}
@@ -59,6 +59,10 @@ type Option struct {
 	ReturnShortPackets bool // unused
 	TwoWire            bool // all slave/servers read and write on 2 wires (sees each others responses)
 	SleepBufferBytes   int  // some reader return on first bytes read, use this to save some CPU
+
+	// OverSize of the packet readers, clients, and servers using the
+	// SerialContext, nil for the global settings.
+	OverSize *OverSize
 }
 
 // Stats records statistics on a SerialContext(V3), must be aligned to 64 bits on 32 bit systems.
@@ -73,6 +77,7 @@ type Stats struct {
 	FormateWarnings  int64
 	IDDrops          int64
 	OtherDrops       int64
+	Retries          int64 // transactions resent by RetryPolicy, not included in TotalDrops
 }
 
 // Clone makes a copy of Stats without race conditions
@@ -86,6 +91,7 @@ func (s *Stats) Clone() *Stats {
 		FormateWarnings:  atomic.LoadInt64(&s.FormateWarnings),
 		IDDrops:          atomic.LoadInt64(&s.IDDrops),
 		OtherDrops:       atomic.LoadInt64(&s.OtherDrops),
+		Retries:          atomic.LoadInt64(&s.Retries),
 	}
 }
 
@@ -99,6 +105,7 @@ func (s *Stats) Reset() {
 	atomic.StoreInt64(&s.FormateWarnings, 0)
 	atomic.StoreInt64(&s.IDDrops, 0)
 	atomic.StoreInt64(&s.OtherDrops, 0)
+	atomic.StoreInt64(&s.Retries, 0)
 }
 
 // TotalDrops adds up all the errors for the total number of read packets dropped.
@@ -136,7 +143,9 @@ func (s *serial) Read(b []byte) (int, error) {
 }
 
 func (s *serial) Write(b []byte) (int, error) {
-	debugf("SerialPort Write:%x\n", b)
+	if debugWriter() != nil {
+		debugf("SerialPort Write:%x\n", b)
+	}
 	n, err := s.conn.Write(b)
 	return n, err
 }
@@ -0,0 +1,386 @@
+package modbusone
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Serial recordings store every Read and Write of a SerialContext, with the
+// bytes as they were returned by each call, so that packet framing can be
+// reproduced by replaying them with SerialReplay.
+//
+// The file format is big endian. It starts with a 20 byte header:
+//
+//	offset size  field
+//	0      4     magic "MBSR"
+//	4      2     version, 1
+//	6      2     flags, bit 0 is Option.TwoWire
+//	8      4     baud rate
+//	12     8     Option.CPUHiccup in nanoseconds, 0 for DefaultCPUHiccup
+//
+// It is followed by records until the end of the file, one for each call that
+// read or wrote at least one byte:
+//
+//	offset size  field
+//	0      8     time of the call in nanoseconds since the Unix epoch
+//	8      1     direction, 'r' for read and 'w' for write
+//	9      2     n, the number of bytes
+//	11     n     the bytes
+const (
+	serialRecordMagic      = "MBSR"
+	serialRecordVersion    = 1
+	serialRecordHeaderSize = 20
+	serialRecordFlagTwo    = 1
+	serialRecordRead       = 'r'
+	serialRecordWrite      = 'w'
+)
+
+// SerialRecord is a Read or Write call in a serial recording.
+type SerialRecord struct {
+	_ struct{} // enforces keyed literals
+
+	Time  time.Time
+	Write bool // true if written, false if read
+	Data  []byte
+}
+
+// serialRecorder records the calls of a SerialContext.
+type serialRecorder struct {
+	SerialContext
+
+	lock sync.Mutex // protects w, and orders records
+	w    io.Writer
+}
+
+// NewSerialRecorder returns a SerialContext that records every Read and Write
+// of sc to w, in the format of serial recordings. The baud rate and the Option
+// of sc are saved for the replay.
+func NewSerialRecorder(sc SerialContext, w io.Writer, baudRate int64) (SerialContext, error) {
+	var option Option
+	if o, ok := sc.(OptionContext); ok {
+		option = o.GetOption()
+	}
+	header := make([]byte, serialRecordHeaderSize)
+	copy(header, serialRecordMagic)
+	binary.BigEndian.PutUint16(header[4:], serialRecordVersion)
+	if option.TwoWire {
+		binary.BigEndian.PutUint16(header[6:], serialRecordFlagTwo)
+	}
+	binary.BigEndian.PutUint32(header[8:], uint32(baudRate))
+	binary.BigEndian.PutUint64(header[12:], uint64(option.CPUHiccup))
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &serialRecorder{SerialContext: sc, w: w}, nil
+}
+
+// recordLocked writes a record of data.
+func (s *serialRecorder) recordLocked(t time.Time, direction byte, data []byte) error {
+	for len(data) > 0 {
+		n := min(len(data), 0xFFFF)
+		b := make([]byte, 11, 11+n)
+		binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+		b[8] = direction
+		binary.BigEndian.PutUint16(b[9:], uint16(n))
+		if _, err := s.w.Write(append(b, data[:n]...)); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (s *serialRecorder) Read(b []byte) (int, error) {
+	n, err := s.SerialContext.Read(b)
+	if n > 0 {
+		s.lock.Lock()
+		if rerr := s.recordLocked(time.Now(), serialRecordRead, b[:n]); err == nil {
+			err = rerr
+		}
+		s.lock.Unlock()
+	}
+	return n, err
+}
+
+// Write holds the lock while writing, so replies read after the write are
+// recorded after it.
+func (s *serialRecorder) Write(b []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	n, err := s.SerialContext.Write(b)
+	if n > 0 {
+		if rerr := s.recordLocked(now, serialRecordWrite, b[:n]); err == nil {
+			err = rerr
+		}
+	}
+	return n, err
+}
+
+// PacketCutoffDuration implements SerialContextV2.
+func (s *serialRecorder) PacketCutoffDuration(n int) time.Duration {
+	return GetPacketCutoffDurationFromSerialContext(s.SerialContext, n)
+}
+
+// GetOption implements OptionContext.
+func (s *serialRecorder) GetOption() Option {
+	if o, ok := s.SerialContext.(OptionContext); ok {
+		return o.GetOption()
+	}
+	return Option{}
+}
+
+// SerialRecordReader reads a serial recording.
+type SerialRecordReader struct {
+	r        io.Reader
+	baudRate int64
+	option   Option
+}
+
+// NewSerialRecordReader reads the header of a serial recording from r.
+func NewSerialRecordReader(r io.Reader) (*SerialRecordReader, error) {
+	header := make([]byte, serialRecordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("serial recording header: %w", unexpectedEOF(err))
+	}
+	if string(header[:4]) != serialRecordMagic {
+		return nil, fmt.Errorf("not a serial recording, magic is %q", header[:4])
+	}
+	if v := binary.BigEndian.Uint16(header[4:]); v != serialRecordVersion {
+		return nil, fmt.Errorf("serial recording version %v is not supported", v)
+	}
+	return &SerialRecordReader{
+		r:        r,
+		baudRate: int64(binary.BigEndian.Uint32(header[8:])),
+		option: Option{
+			TwoWire:   binary.BigEndian.Uint16(header[6:])&serialRecordFlagTwo != 0,
+			CPUHiccup: time.Duration(binary.BigEndian.Uint64(header[12:])),
+		},
+	}, nil
+}
+
+// BaudRate returns the baud rate of the recording.
+func (r *SerialRecordReader) BaudRate() int64 {
+	return r.baudRate
+}
+
+// Option returns the Option of the recorded SerialContext.
+func (r *SerialRecordReader) Option() Option {
+	return r.option
+}
+
+// Next returns the next record, or io.EOF at the end of the recording.
+func (r *SerialRecordReader) Next() (SerialRecord, error) {
+	var head [11]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return SerialRecord{}, io.EOF
+		}
+		return SerialRecord{}, unexpectedEOF(err)
+	}
+	direction := head[8]
+	if direction != serialRecordRead && direction != serialRecordWrite {
+		return SerialRecord{}, fmt.Errorf("serial record direction %q is unknown", direction)
+	}
+	data := make([]byte, binary.BigEndian.Uint16(head[9:]))
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return SerialRecord{}, unexpectedEOF(err)
+	}
+	return SerialRecord{
+		Time:  time.Unix(0, int64(binary.BigEndian.Uint64(head[:]))),
+		Write: direction == serialRecordWrite,
+		Data:  data,
+	}, nil
+}
+
+// SerialReplay is a SerialContext that replays a serial recording to the
+// RTUClient or RTUServer using it. Reads return the recorded reads, in the same
+// pieces and with the original timing. Recorded writes are not replayed, but
+// wait for the user to write, so the timing of later reads is kept relative to
+// the writes. Writes are saved in Writes.
+type SerialReplay struct {
+	stats    Stats // first for alignment
+	records  []SerialRecord
+	baudRate int64
+	option   Option
+
+	lock       sync.Mutex // protects fields below
+	started    bool
+	next       int         // index of the next record to replay
+	partial    []byte      // remaining bytes of a read record that did not fit
+	anchor     time.Time   // when the recorded time anchorTime was replayed
+	anchorTime time.Time   // the recorded time of the last write, or of the first record
+	pending    []time.Time // times of writes not yet matched to recorded writes
+	writes     []SerialRecord
+	notify     chan struct{} // signals writes
+	closed     chan struct{}
+	once       sync.Once
+}
+
+var _ SerialContextV3 = &SerialReplay{}
+
+// NewSerialReplay reads a serial recording from r, to be replayed from the
+// first Read. The timing of the first record is at the first Read.
+func NewSerialReplay(r io.Reader) (*SerialReplay, error) {
+	rr, err := NewSerialRecordReader(r)
+	if err != nil {
+		return nil, err
+	}
+	s := &SerialReplay{
+		baudRate: rr.BaudRate(),
+		option:   rr.Option(),
+		notify:   make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+	for {
+		record, err := rr.Next()
+		if errors.Is(err, io.EOF) {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.records = append(s.records, record)
+	}
+}
+
+// Done returns true when all records have been replayed.
+func (s *SerialReplay) Done() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.next >= len(s.records) && len(s.partial) == 0
+}
+
+// Writes returns the writes to the replay.
+func (s *SerialReplay) Writes() []SerialRecord {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]SerialRecord(nil), s.writes...)
+}
+
+// Read blocks until the next recorded read is due, and returns its bytes that
+// fit in b. It returns io.EOF after the last record, or when closed.
+func (s *SerialReplay) Read(b []byte) (int, error) {
+	for {
+		select {
+		case <-s.closed:
+			return 0, io.EOF
+		default:
+		}
+		n, wait, err := s.read(b)
+		if n > 0 || err != nil {
+			return n, err
+		}
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-s.closed:
+		case <-s.notify:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// read replays records that are due, and returns the bytes read, or the time
+// to wait for the next record, 0 to wait for a write.
+func (s *SerialReplay) read(b []byte) (int, time.Duration, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.partial) > 0 {
+		n := copy(b, s.partial)
+		s.partial = s.partial[n:]
+		return n, 0, nil
+	}
+	now := time.Now()
+	if !s.started && len(s.records) > 0 {
+		s.started = true
+		s.anchor, s.anchorTime = now, s.records[0].Time
+	}
+	for s.next < len(s.records) {
+		record := s.records[s.next]
+		if record.Write {
+			if len(s.pending) == 0 {
+				return 0, 0, nil
+			}
+			s.anchor, s.anchorTime = s.pending[0], record.Time
+			s.pending = s.pending[1:]
+			s.next++
+			continue
+		}
+		due := s.anchor.Add(record.Time.Sub(s.anchorTime))
+		if wait := due.Sub(now); wait > 0 {
+			return 0, wait, nil
+		}
+		s.next++
+		n := copy(b, record.Data)
+		s.partial = record.Data[n:]
+		return n, 0, nil
+	}
+	return 0, 0, io.EOF
+}
+
+// Write saves b as a write, and allows the next recorded write to be replayed,
+// so the following reads are timed from now.
+func (s *SerialReplay) Write(b []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	now := time.Now()
+	s.lock.Lock()
+	s.writes = append(s.writes, SerialRecord{Time: now, Write: true, Data: append([]byte(nil), b...)})
+	s.pending = append(s.pending, now)
+	s.lock.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+// Close ends pending reads with io.EOF.
+func (s *SerialReplay) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+// MinDelay implements SerialContext.
+func (s *SerialReplay) MinDelay() time.Duration {
+	return MinDelay(s.baudRate)
+}
+
+// BytesDelay implements SerialContext.
+func (s *SerialReplay) BytesDelay(n int) time.Duration {
+	return BytesDelay(s.baudRate, n)
+}
+
+// Stats implements SerialContext.
+func (s *SerialReplay) Stats() *Stats {
+	return &s.stats
+}
+
+// PacketCutoffDuration implements SerialContextV2.
+func (s *SerialReplay) PacketCutoffDuration(n int) time.Duration {
+	hiccup := s.option.CPUHiccup
+	if hiccup == 0 {
+		hiccup = DefaultCPUHiccup
+	}
+	return PacketCutoffDuration(s.baudRate, n, hiccup)
+}
+
+// GetOption implements OptionContext.
+func (s *SerialReplay) GetOption() Option {
+	return s.option
+}
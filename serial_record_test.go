@@ -0,0 +1,134 @@
+package modbusone_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+// readAllRecords reads all records of a serial recording.
+func readAllRecords(t *testing.T, r io.Reader) (*SerialRecordReader, []SerialRecord) {
+	rr, err := NewSerialRecordReader(r)
+	require.NoError(t, err)
+	var records []SerialRecord
+	for {
+		record, err := rr.Next()
+		if errors.Is(err, io.EOF) {
+			return rr, records
+		}
+		require.NoError(t, err)
+		records = append(records, record)
+	}
+}
+
+// joinRecords returns the bytes of records in one direction.
+func joinRecords(records []SerialRecord, write bool) []byte {
+	var b []byte
+	for _, r := range records {
+		if r.Write == write {
+			b = append(b, r.Data...)
+		}
+	}
+	return b
+}
+
+func TestSerialRecordReplayServer(t *testing.T) {
+	slaveID := byte(0x11)
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+
+	var recording bytes.Buffer
+	client := NewRTUClient(newMockSerial(t, "c", r2, w1, w1), slaveID)
+	sc, err := NewSerialRecorder(newMockSerial(t, "s", r1, w2, w2), &recording, 19200)
+	require.NoError(t, err)
+	server := NewRTUServer(sc, slaveID)
+	h := testTracerHandler()
+	go client.Serve(h)
+	served := make(chan error)
+	go func() { served <- server.Serve(h) }()
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(5, 2)
+	require.NoError(t, err)
+	require.NoError(t, client.DoTransaction(read))
+	bad, err := FcReadHoldingRegisters.MakeRequestHeader(100, 1)
+	require.NoError(t, err)
+	require.Error(t, client.DoTransaction(bad))
+	client.Close()
+	server.Close()
+	<-served // all records are written
+
+	rr, records := readAllRecords(t, bytes.NewReader(recording.Bytes()))
+	require.Equal(t, int64(19200), rr.BaudRate())
+	require.False(t, records[0].Write)
+	requests := append(MakeRTU(slaveID, read), MakeRTU(slaveID, bad)...)
+	require.Equal(t, []byte(requests), joinRecords(records, false))
+	replies := joinRecords(records, true)
+	require.Equal(t, []byte(MakeRTU(slaveID, ExceptionReplyPacket(bad, EcIllegalDataAddress))), replies[len(replies)-5:])
+
+	replay, err := NewSerialReplay(bytes.NewReader(recording.Bytes()))
+	require.NoError(t, err)
+	server = NewRTUServer(replay, slaveID)
+	require.Equal(t, io.EOF, server.Serve(h))
+	require.True(t, replay.Done())
+	require.Equal(t, replies, joinRecords(replay.Writes(), true))
+}
+
+// appendRecord appends a record in the documented file format.
+func appendRecord(b []byte, t time.Time, direction byte, data ...byte) []byte {
+	b = binary.BigEndian.AppendUint64(b, uint64(t.UnixNano()))
+	b = append(b, direction)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+func TestSerialReplayTiming(t *testing.T) {
+	b := []byte("MBSR")
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint16(b, 1) // TwoWire
+	b = binary.BigEndian.AppendUint32(b, 9600)
+	b = binary.BigEndian.AppendUint64(b, uint64(time.Millisecond))
+	start := time.Unix(1700000000, 0)
+	b = appendRecord(b, start, 'r', 1, 2, 3)
+	b = appendRecord(b, start.Add(50*time.Millisecond), 'r', 4, 5)
+	b = appendRecord(b, start.Add(time.Second), 'w', 6)
+	b = appendRecord(b, start.Add(time.Second+30*time.Millisecond), 'r', 7)
+
+	replay, err := NewSerialReplay(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, Option{TwoWire: true, CPUHiccup: time.Millisecond}, replay.GetOption())
+	require.Equal(t, BytesDelay(9600, 3), replay.BytesDelay(3))
+
+	p := make([]byte, 2)
+	began := time.Now()
+	n, err := replay.Read(p)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2}, p[:n], "reads are limited by the buffer")
+	n, err = replay.Read(p)
+	require.NoError(t, err)
+	require.Equal(t, []byte{3}, p[:n], "records are not joined")
+	n, err = replay.Read(p)
+	require.NoError(t, err)
+	require.Equal(t, []byte{4, 5}, p[:n])
+	require.GreaterOrEqual(t, time.Since(began), 50*time.Millisecond)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		replay.Write([]byte{6})
+	}()
+	n, err = replay.Read(p)
+	require.NoError(t, err)
+	require.Equal(t, []byte{7}, p[:n])
+	require.Len(t, replay.Writes(), 1)
+	require.GreaterOrEqual(t, time.Since(replay.Writes()[0].Time), 30*time.Millisecond, "timed from the write")
+	require.Less(t, time.Since(began), time.Second, "not timed from the start")
+
+	require.True(t, replay.Done())
+	_, err = replay.Read(p)
+	require.Equal(t, io.EOF, err)
+}
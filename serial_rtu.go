@@ -3,6 +3,7 @@ package modbusone
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/xiegeo/modbusone/crc"
 )
@@ -14,16 +15,15 @@ const MaxRTUSize = 256
 const MaxPDUSize = 253
 
 // OverSizeSupport ignores max packet size and encoded number of bytes to support
-// over sized implementations encountered in the wild. This setting only applies
-// to the server end, since client is always reserved in what it requests.
+// over sized implementations encountered in the wild.
 // Also change OverSizeMaxRTU properly.
+//
+// OverSizeSupport and OverSizeMaxRTU are the defaults of all clients, servers,
+// and packet readers. Use OverSize to configure them one by one.
 var OverSizeSupport = false
 
 func IsOverSizeSupported() bool {
-	OverSizeLock.RLock()
-	o := OverSizeSupport
-	OverSizeLock.RUnlock()
-	return o
+	return defaultOverSize().Support
 }
 
 // OverSizeMaxRTU overrides MaxRTUSize when OverSizeSupport is true.
@@ -38,18 +38,65 @@ func GetMaxRTUSize() int {
 }
 
 func GetMaxPDUSize() int {
+	return defaultOverSize().maxPDUSize()
+}
+
+// OverSize configures over sized packets of a client, server, or packet reader,
+// instead of the defaults of OverSizeSupport and OverSizeMaxRTU.
+//
+// Servers accept over sized requests. Clients read over sized replies from
+// slaves with a SlaveProfile.MaxPDUSize larger than MaxPDUSize.
+type OverSize struct {
+	_ struct{} // enforces keyed literals
+
+	// Support ignores max packet size and encoded number of bytes, as OverSizeSupport.
+	Support bool
+	// MaxRTU overrides MaxRTUSize when Support is true, as OverSizeMaxRTU.
+	// The max PDU size, MaxRTU-3, also applies to Modbus TCP.
+	MaxRTU int
+}
+
+// defaultOverSize returns the OverSize of the global settings.
+func defaultOverSize() OverSize {
 	OverSizeLock.RLock()
 	defer OverSizeLock.RUnlock()
-	return getMaxPDUSize()
+	return OverSize{Support: OverSizeSupport, MaxRTU: OverSizeMaxRTU}
 }
 
-func getMaxPDUSize() int {
-	if OverSizeSupport {
-		return max(MaxPDUSize, OverSizeMaxRTU-3)
+func (o OverSize) maxPDUSize() int {
+	if o.Support {
+		return max(MaxPDUSize, o.MaxRTU-3)
 	}
 	return MaxPDUSize
 }
 
+func (o OverSize) maxRTUSize() int {
+	return o.maxPDUSize() + 3
+}
+
+// instanceOverSize is the OverSize of a client, server, or packet reader.
+// The zero value uses the global settings.
+type instanceOverSize struct {
+	o atomic.Pointer[OverSize]
+}
+
+// set sets the OverSize, nil to use the global settings.
+func (i *instanceOverSize) set(o *OverSize) {
+	if o == nil {
+		i.o.Store(nil)
+		return
+	}
+	c := *o
+	i.o.Store(&c)
+}
+
+func (i *instanceOverSize) get() OverSize {
+	if o := i.o.Load(); o != nil {
+		return *o
+	}
+	return defaultOverSize()
+}
+
 // A prefix of the RTU
 type RTUHeader struct {
 	SlaveID byte
@@ -63,7 +110,17 @@ type RTU []byte
 
 // MakeRTU makes a RTU with slaveID and PDU.
 func MakeRTU(slaveID byte, p PDU) RTU {
-	return RTU(crc.Sum(append([]byte{slaveID}, p...)))
+	return AppendRTU(make([]byte, 0, len(p)+3), slaveID, p)
+}
+
+// AppendRTU is MakeRTU, with the RTU appended to dst.
+func AppendRTU(dst []byte, slaveID byte, p PDU) RTU {
+	start := len(dst)
+	dst = append(append(dst, slaveID), p...)
+	end := len(dst)
+	dst = append(dst, 0, 0)
+	crc.Sum(dst[start:end]) // fills in the crc
+	return dst
 }
 
 func (r RTU) fastGetHeader() RTUHeader {
@@ -73,6 +130,14 @@ func (r RTU) fastGetHeader() RTUHeader {
 	}
 }
 
+// getHeaderCopy is fastGetHeader with the PDU copied out of r, for handlers
+// that keep req after r is reused.
+func (r RTU) getHeaderCopy() RTUHeader {
+	h := r.fastGetHeader()
+	h.PDU = append(PDU(nil), h.PDU...)
+	return h
+}
+
 // GetSlaveID returns the SlaveID inside, or 255 if the RTU is empty.
 func (r RTU) GetSlaveID() byte {
 	if len(r) == 0 {
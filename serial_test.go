@@ -18,6 +18,7 @@ func TestStats_Clone(t *testing.T) {
 		FormateWarnings:  6, // Keeping typo for compatibility
 		IDDrops:          7,
 		OtherDrops:       8,
+		Retries:          9,
 	}
 
 	cloned := orig.Clone()
@@ -52,6 +53,9 @@ func TestStats_Clone(t *testing.T) {
 	if cloned.OtherDrops != 8 {
 		t.Errorf("OtherDrops mismatch: got %d, want 8", cloned.OtherDrops)
 	}
+	if cloned.Retries != 9 {
+		t.Errorf("Retries mismatch: got %d, want 9", cloned.Retries)
+	}
 }
 
 func TestStats_Reset(t *testing.T) {
@@ -64,13 +68,15 @@ func TestStats_Reset(t *testing.T) {
 		FormateWarnings:  60,
 		IDDrops:          70,
 		OtherDrops:       80,
+		Retries:          90,
 	}
 
 	s.Reset()
 
 	// Exhaustively verify every field was cleared to 0
 	if s.ReadPackets != 0 || s.CrcErrors != 0 || s.RemoteErrors != 0 || s.OtherErrors != 0 ||
-		s.LongReadWarnings != 0 || s.FormateWarnings != 0 || s.IDDrops != 0 || s.OtherDrops != 0 {
+		s.LongReadWarnings != 0 || s.FormateWarnings != 0 || s.IDDrops != 0 || s.OtherDrops != 0 ||
+		s.Retries != 0 {
 		t.Errorf("Reset failed to zero out all fields. Got: %+v", s)
 	}
 }
@@ -85,6 +91,7 @@ func TestStats_TotalDrops(t *testing.T) {
 		FormateWarnings:  5,
 		IDDrops:          6,
 		OtherDrops:       7,
+		Retries:          8, // Not a drop
 	}
 
 	expected := int64(1 + 2 + 3 + 4 + 5 + 6 + 7)
@@ -0,0 +1,27 @@
+package modbusone
+
+// slaveIDSet is a set of slave IDs.
+type slaveIDSet [256 / 8]byte
+
+func newSlaveIDSet(ids ...byte) *slaveIDSet {
+	var s slaveIDSet
+	for _, id := range ids {
+		s[id/8] |= 1 << (id % 8)
+	}
+	return &s
+}
+
+func (s *slaveIDSet) has(id byte) bool {
+	return s[id/8]&(1<<(id%8)) != 0
+}
+
+// ids returns the slave IDs in the set, in increasing order.
+func (s *slaveIDSet) ids() []byte {
+	var ids []byte
+	for i := 0; i < 256; i++ {
+		if s.has(byte(i)) {
+			ids = append(ids, byte(i))
+		}
+	}
+	return ids
+}
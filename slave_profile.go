@@ -0,0 +1,125 @@
+package modbusone
+
+import (
+	"sync"
+	"time"
+)
+
+// SlaveProfile holds client settings for one slave/server, for buses that mix
+// fast and slow devices. Zero values use the defaults of the client.
+type SlaveProfile struct {
+	_ struct{} // enforces keyed literals
+
+	// ServerProcessingTime is the time to wait for a server response, in addition
+	// to the time needed for data transmission.
+	// The default is set by SetServerProcessingTime.
+	ServerProcessingTime time.Duration
+	// InterFrameDelay is the delay before sending each request.
+	// The default is SerialContext.MinDelay().
+	InterFrameDelay time.Duration
+	// MaxPDUSize limits the size of the PDUs to and from the server, used to
+	// split requests. The default is MaxPDUSize.
+	// It can be larger than MaxPDUSize for servers of over sized packets, up to
+	// the max of the OverSize of the client.
+	MaxPDUSize int
+}
+
+// slaveProfiles holds the SlaveProfile of each slaveID of a client.
+type slaveProfiles struct {
+	lock                 sync.Mutex
+	serverProcessingTime time.Duration
+	profiles             map[byte]SlaveProfile
+	adaptive             AdaptiveTimeout
+	latencies            map[byte]*latencyWindow
+	overSize             instanceOverSize
+}
+
+func newSlaveProfiles() slaveProfiles {
+	return slaveProfiles{serverProcessingTime: time.Second}
+}
+
+func (p *slaveProfiles) setServerProcessingTime(t time.Duration) {
+	p.lock.Lock()
+	p.serverProcessingTime = t
+	p.lock.Unlock()
+}
+
+func (p *slaveProfiles) set(slaveID byte, profile SlaveProfile) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.profiles == nil {
+		p.profiles = make(map[byte]SlaveProfile)
+	}
+	p.profiles[slaveID] = profile
+}
+
+// get returns the profile of slaveID with defaults filled in.
+func (p *slaveProfiles) get(slaveID byte, com SerialContext) SlaveProfile {
+	p.lock.Lock()
+	profile := p.profiles[slaveID]
+	if profile.ServerProcessingTime == 0 {
+		profile.ServerProcessingTime = p.serverProcessingTime
+	}
+	profile.ServerProcessingTime = p.adaptive.learned(p.latencies[slaveID], profile.ServerProcessingTime)
+	p.lock.Unlock()
+	if profile.InterFrameDelay == 0 {
+		profile.InterFrameDelay = com.MinDelay()
+	}
+	if profile.MaxPDUSize == 0 {
+		profile.MaxPDUSize = MaxPDUSize
+	}
+	profile.MaxPDUSize = min(profile.MaxPDUSize, p.overSize.get().maxPDUSize())
+	return profile
+}
+
+// transactionTimeOut returns the time to wait for a transaction with slaveID,
+// given the expected length of RTU packets.
+func (p *slaveProfiles) transactionTimeOut(slaveID byte, com SerialContext, reqLen, ansLen int) time.Duration {
+	return com.BytesDelay(reqLen+ansLen) + p.get(slaveID, com).ServerProcessingTime
+}
+
+// makePDURequestHeaders splits requests by the MaxPDUSize of slaveID.
+func (p *slaveProfiles) makePDURequestHeaders(slaveID byte, com SerialContext, fc FunctionCode, address, quantity uint16, appendTO []PDU) ([]PDU, error) {
+	size := p.get(slaveID, com).MaxPDUSize
+	if size > MaxPDUSize {
+		return makePDURequestHeadersSized(fc, address, quantity, fc.maxPerPacketSized(size), true, appendTO)
+	}
+	return MakePDURequestHeadersSized(fc, address, quantity, fc.MaxPerPacketSized(size), appendTO)
+}
+
+// setAdaptive sets the AdaptiveTimeout config, and clears learned latencies.
+func (p *slaveProfiles) setAdaptive(a AdaptiveTimeout) {
+	p.lock.Lock()
+	p.adaptive = a
+	p.latencies = nil
+	p.lock.Unlock()
+}
+
+// observe records a latency sample of slaveID, if learning is enabled.
+func (p *slaveProfiles) observe(slaveID byte, latency time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.adaptive.Window <= 0 {
+		return
+	}
+	if p.latencies == nil {
+		p.latencies = make(map[byte]*latencyWindow)
+	}
+	w := p.latencies[slaveID]
+	if w == nil {
+		w = &latencyWindow{}
+		p.latencies[slaveID] = w
+	}
+	w.add(max(0, latency), p.adaptive.Window, p.adaptive.percentile())
+}
+
+// learnedLatency returns the latency percentile and number of samples of slaveID.
+func (p *slaveProfiles) learnedLatency(slaveID byte) (time.Duration, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	w := p.latencies[slaveID]
+	if w == nil {
+		return 0, 0
+	}
+	return w.percentile, len(w.samples)
+}
@@ -0,0 +1,36 @@
+package modbusone_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+func TestSlaveProfile(t *testing.T) {
+	com := newMockSerial(t, "c", &bytes.Buffer{}, &bytes.Buffer{})
+	c := NewRTUClient(com, 1)
+	c.SetServerProcessingTime(time.Millisecond * 100)
+	c.SetSlaveProfile(2, SlaveProfile{ServerProcessingTime: time.Second * 3, MaxPDUSize: 12})
+
+	p := c.GetSlaveProfile(1)
+	require.Equal(t, time.Millisecond*100, p.ServerProcessingTime, "default")
+	require.Equal(t, com.MinDelay(), p.InterFrameDelay, "default")
+	require.Equal(t, MaxPDUSize, p.MaxPDUSize, "default")
+	p = c.GetSlaveProfile(2)
+	require.Equal(t, time.Second*3, p.ServerProcessingTime)
+	require.Equal(t, com.MinDelay(), p.InterFrameDelay, "default")
+	require.Equal(t, 12, p.MaxPDUSize)
+
+	require.Equal(t, time.Millisecond*100, c.GetTransactionTimeOut(8, MaxRTUSize))
+	require.Equal(t, time.Second*3, c.GetSlaveTransactionTimeOut(2, 8, MaxRTUSize))
+
+	pdus, err := c.MakePDURequestHeaders(1, FcReadHoldingRegisters, 0, 10, nil)
+	require.NoError(t, err)
+	require.Len(t, pdus, 1)
+	pdus, err = c.MakePDURequestHeaders(2, FcReadHoldingRegisters, 0, 10, nil)
+	require.NoError(t, err)
+	require.Len(t, pdus, 2, "split by MaxPDUSize")
+}
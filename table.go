@@ -0,0 +1,74 @@
+package modbusone
+
+import "fmt"
+
+// Table is one of the four primary data tables of the Modbus data model.
+type Table byte
+
+// The four data tables, TableNone is returned for unsupported FunctionCodes.
+const (
+	TableNone             Table = 0
+	TableDiscreteInputs   Table = 1
+	TableCoils            Table = 2
+	TableInputRegisters   Table = 3
+	TableHoldingRegisters Table = 4
+)
+
+// Table returns the data table a FunctionCode operates on, or TableNone.
+func (f FunctionCode) Table() Table {
+	switch f {
+	case FcReadDiscreteInputs:
+		return TableDiscreteInputs
+	case FcReadCoils, FcWriteSingleCoil, FcWriteMultipleCoils:
+		return TableCoils
+	case FcReadInputRegisters:
+		return TableInputRegisters
+	case FcReadHoldingRegisters, FcWriteSingleRegister, FcWriteMultipleRegisters:
+		return TableHoldingRegisters
+	}
+	return TableNone
+}
+
+// Valid returns true if the Table is one of the four data tables.
+func (t Table) Valid() bool {
+	return t >= TableDiscreteInputs && t <= TableHoldingRegisters
+}
+
+// IsBool returns true if the Table holds boolean values.
+func (t Table) IsBool() bool {
+	return t == TableDiscreteInputs || t == TableCoils
+}
+
+// IsUint16 returns true if the Table holds 16bit values.
+func (t Table) IsUint16() bool {
+	return t == TableInputRegisters || t == TableHoldingRegisters
+}
+
+// ReadFunctionCode returns the FunctionCode used to read from the Table.
+func (t Table) ReadFunctionCode() FunctionCode {
+	switch t {
+	case TableDiscreteInputs:
+		return FcReadDiscreteInputs
+	case TableCoils:
+		return FcReadCoils
+	case TableInputRegisters:
+		return FcReadInputRegisters
+	case TableHoldingRegisters:
+		return FcReadHoldingRegisters
+	}
+	return 0
+}
+
+func (t Table) String() string {
+	switch t {
+	case TableDiscreteInputs:
+		return "DiscreteInputs"
+	case TableCoils:
+		return "Coils"
+	case TableInputRegisters:
+		return "InputRegisters"
+	case TableHoldingRegisters:
+		return "HoldingRegisters"
+	}
+	return fmt.Sprintf("Table(%d)", byte(t))
+}
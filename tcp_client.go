@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // TCPClient implements Client/Master side logic for Modbus over a TCP connection to
@@ -20,6 +22,10 @@ type TCPClient struct {
 	_handlerReady sync.WaitGroup
 	exitError     error // set this before call to cancel
 	locker        sync.Mutex
+	logger        instanceLogger
+	metrics       atomic.Pointer[Metrics]
+	tracer        instanceTracer
+	overSize      instanceOverSize
 }
 
 // TCPClient is also a ServerCloser.
@@ -76,50 +82,109 @@ func (c *TCPClient) DoTransaction(req PDU) error {
 	return c.DoTransaction2(c.SlaveID, req)
 }
 
+// SetMetrics sets where transactions are reported, nil to stop reporting.
+func (c *TCPClient) SetMetrics(m *Metrics) {
+	c.metrics.Store(m)
+}
+
+// SetTracer sets the Tracer of transactions, nil to stop tracing.
+func (c *TCPClient) SetTracer(t Tracer) {
+	c.tracer.set(t)
+}
+
+// SetOverSize sets the OverSize of the client, nil for the global settings.
+func (c *TCPClient) SetOverSize(o *OverSize) {
+	c.overSize.set(o)
+}
+
 // DoTransaction2 is DoTransaction with a settable slaveID.
 func (c *TCPClient) DoTransaction2(slaveID byte, req PDU) error {
+	return c.doTransaction(context.Background(), slaveID, req)
+}
+
+// DoTransactionContext is DoTransaction2, where ctx is the parent context given
+// to the Tracer. If ctx is done before the transaction completes, it returns
+// ctx.Err() without waiting, while the transaction continues.
+func (c *TCPClient) DoTransactionContext(ctx context.Context, slaveID byte, req PDU) error {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.doTransaction(ctx, slaveID, req)
+	}()
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *TCPClient) doTransaction(ctx context.Context, slaveID byte, req PDU) (err error) {
 	c.locker.Lock() // only handle one transaction at a time for now
 	defer c.locker.Unlock()
-	bs := make([]byte, GetMaxRTUSize()+TCPHeaderLength)
+	tracer := c.tracer.get()
+	ctx = tracer.TransactionStart(ctx, newTraceInfo(false, "tcp", slaveID, req))
+	defer func() {
+		tracer.TransactionEnd(ctx, err)
+	}()
+	overSize := c.overSize.get()
+	bs := make([]byte, overSize.maxRTUSize()+TCPHeaderLength)
 	if req.GetFunctionCode().IsWriteToServer() {
+		tracer.HandlerInvoked(ctx, "OnRead")
 		data, err := c.getHandler().OnRead(req)
 		if err != nil {
 			return err
 		}
 		req = req.MakeWriteRequest(data)
 	}
-	_, err := writeTCP(c.conn, bs, req)
+	c.logger.debug("TCPClient write packet", "slave_id", slaveID, "bytes", pduLog(req, true))
+	sentAt := time.Now()
+	_, err = writeTCP(c.conn, bs, req)
 	if err != nil {
+		c.logger.logErr(levelError, "TCPClient write error", err, "slave_id", slaveID)
 		c.exitError = err
 		c.cancel()
 		return err
 	}
+	tracer.FrameSent(ctx, frameCopy(tracer, bs[:len(req)+MBAPHeaderLength]))
 	n, err := readTCP(c.conn, bs)
 	if err != nil {
+		c.logger.logErr(levelError, "TCPClient read error", err, "slave_id", slaveID)
 		c.exitError = err
 		c.cancel()
 		return err
 	}
 	rp := PDU(bs[MBAPHeaderLength:n])
+	c.logger.debug("TCPClient read packet", "slave_id", slaveID, "bytes", hexBytes(bs[:n]), "pdu", pduLog(rp, false))
+	tracer.FrameReceived(ctx, frameCopy(tracer, bs[:n]))
 	hasErr, fc := rp.GetFunctionCode().SeparateError()
+	var replyErr error
+	if hasErr && len(rp) > 1 {
+		replyErr = ExceptionCode(rp[1])
+	}
+	c.metrics.Load().observeClient(slaveID, req.GetFunctionCode(), time.Since(sentAt), replyErr)
 	if hasErr {
+		tracer.HandlerInvoked(ctx, "OnError")
 		c.getHandler().OnError(req, rp)
+		c.logger.log(levelWarn, "TCPClient server reply with exception", append(requestLogArgs(slaveID, req),
+			"error_class", "exception", "bytes", hexBytes(rp))...)
 		return fmt.Errorf("server reply with exception:%v", hex.EncodeToString(rp))
 	}
-	if !IsRequestReply(req, rp) {
+	if !overSize.isRequestReply(&c.logger, req, rp) {
 		err = errors.New("unexpected packet received")
+		c.logger.logErr(levelError, "TCPClient unexpected reply", err, append(requestLogArgs(slaveID, req), "bytes", hexBytes(rp))...)
 		c.exitError = err
 		c.cancel()
 		return err
 	}
 	if fc.IsReadToServer() {
 		// read from server, write here
-		bs, err := rp.GetReplyValues()
+		bs, err := rp.getReplyValues(overSize.Support)
 		if err != nil {
 			c.exitError = err
 			c.cancel()
 			return err
 		}
+		tracer.HandlerInvoked(ctx, "OnWrite")
 		return c.getHandler().OnWrite(req, bs)
 	}
 	return nil
@@ -1,20 +1,28 @@
 package modbusone
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	TCPHeaderLength  = 6
 	MBAPHeaderLength = TCPHeaderLength + 1
+	TCPPort          = 502 // the default Modbus TCP port
 )
 
 // TCPServer implements Server/Slave side logic for Modbus over TCP to
 // be used by a ProtocolHandler.
 type TCPServer struct {
 	listener net.Listener
+	logger   instanceLogger
+	metrics  atomic.Pointer[Metrics]
+	tracer   instanceTracer
+	overSize instanceOverSize
 }
 
 // NewTCPServer runs TCP server.
@@ -53,15 +61,26 @@ func writeTCP(w io.Writer, bs []byte, pdu PDU) (int, error) {
 	return w.Write(bs[:len(pdu)+MBAPHeaderLength])
 }
 
+// SetMetrics sets where requests are reported, nil to stop reporting.
+func (s *TCPServer) SetMetrics(m *Metrics) {
+	s.metrics.Store(m)
+}
+
+// SetTracer sets the Tracer of requests, nil to stop tracing.
+func (s *TCPServer) SetTracer(t Tracer) {
+	s.tracer.set(t)
+}
+
+// SetOverSize sets the OverSize of new connections, nil for the global settings.
+func (s *TCPServer) SetOverSize(o *OverSize) {
+	s.overSize.set(o)
+}
+
 // Serve runs the server and only returns after a connection or data error occurred.
 // The underling connection is always closed before this function returns.
 func (s *TCPServer) Serve(handler ProtocolHandler) error {
 	defer s.Close()
 
-	wec := func(conn net.Conn, bs []byte, req PDU, err error) {
-		writeTCP(conn, bs, ExceptionReplyPacket(req, ToExceptionCode(err)))
-	}
-
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
@@ -70,50 +89,78 @@ func (s *TCPServer) Serve(handler ProtocolHandler) error {
 		go func(conn net.Conn) {
 			defer conn.Close()
 
-			var rb []byte
-			if IsOverSizeSupported() {
-				rb = make([]byte, MBAPHeaderLength+OverSizeMaxRTU+TCPHeaderLength)
-			} else {
-				rb = make([]byte, MBAPHeaderLength+MaxPDUSize)
-			}
+			overSize := s.overSize.get()
+			rb := make([]byte, MBAPHeaderLength+overSize.maxPDUSize())
 
 			for {
 				n, err := readTCP(conn, rb)
 				if err != nil {
-					debugf("readTCP %v\n", err)
+					s.logger.logErr(levelDebug, "TCPServer readTCP error", err, "remote", conn.RemoteAddr())
 					return
 				}
 				p := PDU(rb[MBAPHeaderLength:n])
-				err = p.ValidateRequest()
-				if err != nil {
-					debugf("ValidateRequest %v\n", err)
-					return
+				s.logger.debug("TCPServer read packet", "remote", conn.RemoteAddr(), "bytes", hexBytes(rb[:n]), "pdu", pduLog(p, true))
+				slaveID := rb[TCPHeaderLength]
+				tracer := s.tracer.get()
+				ctx := tracer.TransactionStart(context.Background(), newTraceInfo(true, "tcp", slaveID, p))
+				tracer.FrameReceived(ctx, frameCopy(tracer, rb[:n]))
+				// reply writes pdu and ends the transaction with result.
+				reply := func(pdu PDU, result error) {
+					n, err := writeTCP(conn, rb, pdu)
+					if err != nil {
+						result = err
+					} else {
+						tracer.FrameSent(ctx, frameCopy(tracer, rb[:n]))
+					}
+					tracer.TransactionEnd(ctx, result)
+				}
+				wec := func(err error) {
+					ec := ToExceptionCode(err)
+					reply(ExceptionReplyPacket(p, ec), ec)
+				}
+				handlerStart := time.Now()
+				observe := func(err error) {
+					if err != nil {
+						err = ToExceptionCode(err)
+					}
+					s.metrics.Load().observeServer(slaveID, p.GetFunctionCode(), time.Since(handlerStart), err)
 				}
 
+				err = p.validateServerRequest(overSize.Support)
+				if err != nil {
+					s.logger.logErr(levelWarn, "TCPServer auto return for error", err, "remote", conn.RemoteAddr(), "bytes", hexBytes(rb[:n]))
+					observe(err)
+					wec(err)
+					continue
+				}
 				fc := p.GetFunctionCode()
 				if fc.IsReadToServer() {
+					tracer.HandlerInvoked(ctx, "OnRead")
 					data, err := handler.OnRead(p)
+					observe(err)
 					if err != nil {
-						debugf("TCPServer handler.OnOutput error:%v\n", err)
-						wec(conn, rb, p, err)
+						s.logger.logErr(levelWarn, "TCPServer handler.OnOutput error", err, requestLogArgs(slaveID, p)...)
+						wec(err)
 						continue
 					}
-					writeTCP(conn, rb, p.MakeReadReply(data))
+					reply(p.MakeReadReply(data), nil)
 				} else if fc.IsWriteToServer() {
-					data, err := p.GetRequestValues()
+					data, err := p.getRequestValues(overSize.Support)
 					if err != nil {
-						debugf("p:%v\n", p)
-						debugf("TCPServer p.GetRequestValues error:%v\n", err)
-						wec(conn, rb, p, err)
+						observe(err)
+						s.logger.logErr(levelWarn, "TCPServer p.GetRequestValues error", err, append(requestLogArgs(slaveID, p), "bytes", hexBytes(p))...)
+						wec(err)
 						continue
 					}
+					tracer.HandlerInvoked(ctx, "OnWrite")
 					err = handler.OnWrite(p, data)
+					observe(err)
 					if err != nil {
-						debugf("TCPServer handler.OnInput error:%v\n", err)
-						wec(conn, rb, p, err)
+						s.logger.logErr(levelWarn, "TCPServer handler.OnInput error", err, requestLogArgs(slaveID, p)...)
+						wec(err)
 						continue
 					}
-					writeTCP(conn, rb, p.MakeWriteReply())
+					reply(p.MakeWriteReply(), nil)
 				}
 			}
 		}(conn)
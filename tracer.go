@@ -0,0 +1,102 @@
+package modbusone
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Tracer observes the transactions of clients and servers, such as to bridge
+// to OpenTelemetry spans. Methods are called synchronously by the serving
+// goroutine, so they should return quickly. Frames are only valid during the
+// call.
+//
+// For a client, a transaction is: TransactionStart, HandlerInvoked("OnRead")
+// for writes to the server, FrameSent, FrameReceived, HandlerInvoked("OnWrite")
+// for reads from the server or HandlerInvoked("OnError") for exception replies,
+// then TransactionEnd. Retries call FrameSent again.
+//
+// For a server, a transaction is: TransactionStart, FrameReceived,
+// HandlerInvoked("OnRead" or "OnWrite"), FrameSent unless it is a broadcast,
+// then TransactionEnd.
+type Tracer interface {
+	// TransactionStart is called when a client starts a transaction, or when a
+	// server receives a request. The returned context is passed to the other
+	// calls for the same transaction.
+	TransactionStart(ctx context.Context, info TraceInfo) context.Context
+	// FrameSent is called after a frame (RTU or TCP ADU) is written.
+	FrameSent(ctx context.Context, frame []byte)
+	// FrameReceived is called after a frame of the transaction is read.
+	FrameReceived(ctx context.Context, frame []byte)
+	// HandlerInvoked is called before calling the handler method by name.
+	HandlerInvoked(ctx context.Context, method string)
+	// TransactionEnd is called when the transaction completes, err is nil for success.
+	// For servers, err is the error returned to the client as an exception.
+	TransactionEnd(ctx context.Context, err error)
+}
+
+// TraceInfo describes a transaction for Tracer.
+type TraceInfo struct {
+	_ struct{} // enforces keyed literals
+
+	Server       bool   // true for servers, false for clients
+	Transport    string // "rtu" or "tcp"
+	SlaveID      byte
+	FunctionCode FunctionCode
+	Address      uint16
+	Quantity     uint16 // number of values, 0 if not known
+}
+
+// newTraceInfo returns the TraceInfo of a request.
+func newTraceInfo(server bool, transport string, slaveID byte, req PDU) TraceInfo {
+	info := TraceInfo{
+		Server:       server,
+		Transport:    transport,
+		SlaveID:      slaveID,
+		FunctionCode: req.GetFunctionCode(),
+	}
+	if len(req) >= 3 {
+		info.Address = req.GetAddress()
+	}
+	info.Quantity, _ = req.GetRequestCount()
+	return info
+}
+
+// noopTracer is used when no Tracer is set.
+type noopTracer struct{}
+
+func (noopTracer) TransactionStart(ctx context.Context, _ TraceInfo) context.Context { return ctx }
+func (noopTracer) FrameSent(context.Context, []byte)                                 {}
+func (noopTracer) FrameReceived(context.Context, []byte)                             {}
+func (noopTracer) HandlerInvoked(context.Context, string)                            {}
+func (noopTracer) TransactionEnd(context.Context, error)                             {}
+
+// frameCopy returns a copy of frame for tracer, as frame is in a buffer that is
+// reused, or frame itself if tracer is the noopTracer.
+func frameCopy(tracer Tracer, frame []byte) []byte {
+	if _, ok := tracer.(noopTracer); ok {
+		return frame
+	}
+	return append([]byte(nil), frame...)
+}
+
+// instanceTracer is the Tracer of a client or server.
+type instanceTracer struct {
+	p atomic.Pointer[Tracer]
+}
+
+// set sets the Tracer, nil to stop tracing.
+func (t *instanceTracer) set(tracer Tracer) {
+	if tracer == nil {
+		t.p.Store(nil)
+		return
+	}
+	t.p.Store(&tracer)
+}
+
+// get returns the Tracer, never nil.
+func (t *instanceTracer) get() Tracer {
+	if p := t.p.Load(); p != nil {
+		return *p
+	}
+	return noopTracer{}
+}
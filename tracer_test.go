@@ -0,0 +1,160 @@
+package modbusone_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	. "github.com/xiegeo/modbusone"
+)
+
+type traceKey struct{}
+
+// recordingTracer records events, checking that the context of TransactionStart
+// is passed to the other calls.
+type recordingTracer struct {
+	t      *testing.T
+	lock   sync.Mutex
+	events []string
+	infos  []TraceInfo
+}
+
+func (r *recordingTracer) add(ctx context.Context, event string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if ctx.Value(traceKey{}) == nil {
+		r.t.Errorf("%v called without the context of TransactionStart", event)
+	}
+	r.events = append(r.events, event)
+}
+
+func (r *recordingTracer) TransactionStart(ctx context.Context, info TraceInfo) context.Context {
+	ctx = context.WithValue(ctx, traceKey{}, info)
+	r.add(ctx, "start")
+	r.lock.Lock()
+	r.infos = append(r.infos, info)
+	r.lock.Unlock()
+	return ctx
+}
+
+func (r *recordingTracer) FrameSent(ctx context.Context, frame []byte) {
+	r.add(ctx, "sent")
+}
+
+func (r *recordingTracer) FrameReceived(ctx context.Context, frame []byte) {
+	r.add(ctx, "received")
+}
+
+func (r *recordingTracer) HandlerInvoked(ctx context.Context, method string) {
+	r.add(ctx, method)
+}
+
+func (r *recordingTracer) TransactionEnd(ctx context.Context, err error) {
+	r.add(ctx, fmt.Sprint("end:", err))
+}
+
+// waitEvents waits for n events and returns them.
+func (r *recordingTracer) waitEvents(n int) []string {
+	for i := 0; i < 100; i++ {
+		r.lock.Lock()
+		if len(r.events) >= n {
+			events := r.events
+			r.events = nil
+			r.lock.Unlock()
+			return events
+		}
+		r.lock.Unlock()
+		time.Sleep(time.Millisecond * 10)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.events
+}
+
+func testTracerHandler() *SimpleHandler {
+	return &SimpleHandler{
+		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
+			if address == 100 {
+				return nil, EcIllegalDataAddress
+			}
+			return make([]uint16, quantity), nil
+		},
+		WriteHoldingRegisters: func(address uint16, values []uint16) error {
+			return nil
+		},
+	}
+}
+
+func TestRTUTracer(t *testing.T) {
+	slaveID := byte(0x11)
+	r1, w1 := io.Pipe() // pipe from client to server
+	r2, w2 := io.Pipe() // pipe from server to client
+	client := NewRTUClient(newMockSerial(t, "c", r2, w1, w1), slaveID)
+	defer client.Close()
+	server := NewRTUServer(newMockSerial(t, "s", r1, w2, w2), slaveID)
+	defer server.Close()
+	ct := &recordingTracer{t: t}
+	st := &recordingTracer{t: t}
+	client.SetTracer(ct)
+	server.SetTracer(st)
+	h := testTracerHandler()
+	go client.Serve(h)
+	go server.Serve(h)
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(5, 2)
+	require.NoError(t, err)
+	require.NoError(t, client.DoTransactionContext(context.Background(), slaveID, read))
+	require.Equal(t, []string{"start", "sent", "received", "OnWrite", "end:<nil>"}, ct.waitEvents(5))
+	require.Equal(t, []string{"start", "received", "OnRead", "sent", "end:<nil>"}, st.waitEvents(5))
+	require.Equal(t, TraceInfo{Transport: "rtu", SlaveID: slaveID, FunctionCode: FcReadHoldingRegisters,
+		Address: 5, Quantity: 2}, ct.infos[0])
+	require.True(t, st.infos[0].Server)
+
+	bad, err := FcReadHoldingRegisters.MakeRequestHeader(100, 1)
+	require.NoError(t, err)
+	err = client.DoTransaction(bad)
+	require.Error(t, err)
+	require.Equal(t, []string{"start", "sent", "received", "OnError", "end:" + err.Error()}, ct.waitEvents(5))
+	require.Equal(t, []string{"start", "received", "OnRead", "sent", "end:" + EcIllegalDataAddress.Error()}, st.waitEvents(5))
+
+	write, err := FcWriteSingleRegister.MakeRequestHeader(1, 1)
+	require.NoError(t, err)
+	require.NoError(t, client.DoTransaction(write))
+	require.Equal(t, []string{"start", "OnRead", "sent", "received", "end:<nil>"}, ct.waitEvents(5))
+	require.Equal(t, []string{"start", "received", "OnWrite", "sent", "end:<nil>"}, st.waitEvents(5))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, client.DoTransactionContext(ctx, slaveID, read), context.Canceled)
+}
+
+func TestTCPTracer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := NewTCPServer(listener)
+	defer server.Close()
+	st := &recordingTracer{t: t}
+	server.SetTracer(st)
+	h := testTracerHandler()
+	go server.Serve(h)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	client := NewTCPClient(conn, 1)
+	defer client.Close()
+	ct := &recordingTracer{t: t}
+	client.SetTracer(ct)
+	go client.Serve(h)
+
+	read, err := FcReadHoldingRegisters.MakeRequestHeader(5, 2)
+	require.NoError(t, err)
+	require.NoError(t, client.DoTransaction(read))
+	require.Equal(t, []string{"start", "sent", "received", "OnWrite", "end:<nil>"}, ct.waitEvents(5))
+	require.Equal(t, []string{"start", "received", "OnRead", "sent", "end:<nil>"}, st.waitEvents(5))
+	require.Equal(t, "tcp", ct.infos[0].Transport)
+}
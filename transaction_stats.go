@@ -0,0 +1,194 @@
+package modbusone
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TransactionCounts counts the results of transactions.
+type TransactionCounts struct {
+	_ struct{} // enforces keyed literals
+
+	Requests    int64                   // requests sent by clients or received by servers
+	Replies     int64                   // normal replies, not sent or expected for broadcasts
+	Exceptions  map[ExceptionCode]int64 // exception replies by code
+	Timeouts    int64                   // requests without replies, for clients only
+	OtherErrors int64                   // requests failed for other reasons, such as crc errors
+}
+
+// TotalExceptions adds up Exceptions of all codes.
+func (c *TransactionCounts) TotalExceptions() int64 {
+	total := int64(0)
+	for _, n := range c.Exceptions {
+		total += n
+	}
+	return total
+}
+
+func (c *TransactionCounts) clone() TransactionCounts {
+	r := *c
+	if c.Exceptions == nil {
+		return r
+	}
+	r.Exceptions = make(map[ExceptionCode]int64, len(c.Exceptions))
+	for ec, n := range c.Exceptions {
+		r.Exceptions[ec] = n
+	}
+	return r
+}
+
+// LatencyHistogram counts durations in buckets.
+type LatencyHistogram struct {
+	_ struct{} // enforces keyed literals
+
+	Buckets []time.Duration // upper bounds of buckets, in increasing order
+	Counts  []int64         // count in each bucket, not cumulative, with an extra last bucket for longer durations
+	Sum     time.Duration
+	Count   int64
+}
+
+func (h *LatencyHistogram) observe(d time.Duration) {
+	if len(h.Counts) != len(h.Buckets)+1 {
+		h.Counts = make([]int64, len(h.Buckets)+1)
+	}
+	h.Counts[sort.Search(len(h.Buckets), func(i int) bool { return d <= h.Buckets[i] })]++
+	h.Sum += d
+	h.Count++
+}
+
+func (h *LatencyHistogram) clone() LatencyHistogram {
+	r := *h
+	r.Buckets = append([]time.Duration(nil), h.Buckets...)
+	r.Counts = make([]int64, len(h.Buckets)+1)
+	copy(r.Counts, h.Counts)
+	return r
+}
+
+// TransactionStats records statistics of the transactions of clients or
+// servers, by slave ID and by FunctionCode, and a latency histogram. Client
+// latency is the round trip time, while server latency is the time used by
+// the handler.
+//
+// TransactionStats is safe for concurrent use. See Metrics.ClientStats and
+// Metrics.ServerStats.
+type TransactionStats struct {
+	lock           sync.Mutex
+	bySlave        map[byte]*TransactionCounts
+	byFunctionCode map[FunctionCode]*TransactionCounts
+	latency        LatencyHistogram
+}
+
+// NewTransactionStats creates a TransactionStats with latency histogram buckets,
+// which must be in increasing order, nil for DefaultLatencyBuckets.
+func NewTransactionStats(buckets []time.Duration) *TransactionStats {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	s := &TransactionStats{}
+	s.latency.Buckets = append([]time.Duration(nil), buckets...)
+	s.Reset()
+	return s
+}
+
+// Clone makes a copy of TransactionStats without race conditions.
+func (s *TransactionStats) Clone() *TransactionStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r := &TransactionStats{
+		bySlave:        make(map[byte]*TransactionCounts, len(s.bySlave)),
+		byFunctionCode: make(map[FunctionCode]*TransactionCounts, len(s.byFunctionCode)),
+		latency:        s.latency.clone(),
+	}
+	for id, c := range s.bySlave {
+		cc := c.clone()
+		r.bySlave[id] = &cc
+	}
+	for fc, c := range s.byFunctionCode {
+		cc := c.clone()
+		r.byFunctionCode[fc] = &cc
+	}
+	return r
+}
+
+// Reset the stats to zero, keeping the latency buckets.
+func (s *TransactionStats) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.bySlave = make(map[byte]*TransactionCounts)
+	s.byFunctionCode = make(map[FunctionCode]*TransactionCounts)
+	s.latency = LatencyHistogram{Buckets: s.latency.Buckets}
+}
+
+// BySlave returns a copy of the counts by slave ID.
+func (s *TransactionStats) BySlave() map[byte]TransactionCounts {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r := make(map[byte]TransactionCounts, len(s.bySlave))
+	for id, c := range s.bySlave {
+		r[id] = c.clone()
+	}
+	return r
+}
+
+// ByFunctionCode returns a copy of the counts by FunctionCode.
+func (s *TransactionStats) ByFunctionCode() map[FunctionCode]TransactionCounts {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r := make(map[FunctionCode]TransactionCounts, len(s.byFunctionCode))
+	for fc, c := range s.byFunctionCode {
+		r[fc] = c.clone()
+	}
+	return r
+}
+
+// Latency returns a copy of the latency histogram.
+func (s *TransactionStats) Latency() LatencyHistogram {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.latency.clone()
+}
+
+// observe records a transaction with slaveID of d duration, err is nil for a
+// normal reply, wraps an ExceptionCode for an exception reply, or ErrServerTimeOut.
+// Latency is only recorded for replies.
+func (s *TransactionStats) observe(slaveID byte, fc FunctionCode, d time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	bySlave := s.bySlave[slaveID]
+	if bySlave == nil {
+		bySlave = &TransactionCounts{}
+		s.bySlave[slaveID] = bySlave
+	}
+	byFunctionCode := s.byFunctionCode[fc]
+	if byFunctionCode == nil {
+		byFunctionCode = &TransactionCounts{}
+		s.byFunctionCode[fc] = byFunctionCode
+	}
+	var ec ExceptionCode
+	for _, c := range []*TransactionCounts{bySlave, byFunctionCode} {
+		c.Requests++
+		switch {
+		case err == nil:
+			if slaveID != 0 {
+				c.Replies++
+			}
+		case errors.Is(err, ErrServerTimeOut):
+			c.Timeouts++
+		case errors.As(err, &ec):
+			if c.Exceptions == nil {
+				c.Exceptions = make(map[ExceptionCode]int64)
+			}
+			c.Exceptions[ec]++
+		default:
+			c.OtherErrors++
+		}
+	}
+	if slaveID != 0 && (err == nil || ec != 0) {
+		s.latency.observe(d)
+	}
+}
@@ -0,0 +1,43 @@
+package modbusone
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionStats(t *testing.T) {
+	s := NewTransactionStats([]time.Duration{time.Millisecond, time.Second})
+	s.observe(1, FcReadCoils, time.Microsecond, nil)
+	s.observe(1, FcReadCoils, time.Millisecond*10, fmt.Errorf("server reply with exception: %w", EcIllegalDataAddress))
+	s.observe(2, FcReadCoils, time.Second, ErrServerTimeOut)
+	s.observe(2, FcWriteSingleCoil, time.Minute, ErrorCrc)
+	s.observe(0, FcWriteSingleCoil, time.Millisecond, nil)
+
+	require.Equal(t, map[byte]TransactionCounts{
+		0: {Requests: 1},
+		1: {Requests: 2, Replies: 1, Exceptions: map[ExceptionCode]int64{EcIllegalDataAddress: 1}},
+		2: {Requests: 2, Timeouts: 1, OtherErrors: 1},
+	}, s.BySlave())
+	byFunctionCode := s.ByFunctionCode()
+	read := byFunctionCode[FcReadCoils]
+	require.Equal(t, int64(3), read.Requests)
+	require.Equal(t, int64(1), read.TotalExceptions())
+	require.Equal(t, int64(1), byFunctionCode[FcWriteSingleCoil].OtherErrors)
+	require.Equal(t, LatencyHistogram{
+		Buckets: []time.Duration{time.Millisecond, time.Second},
+		Counts:  []int64{1, 1, 0},
+		Sum:     time.Microsecond + time.Millisecond*10,
+		Count:   2,
+	}, s.Latency(), "latency of replies only")
+
+	c := s.Clone()
+	s.Reset()
+	require.Empty(t, s.BySlave())
+	require.Equal(t, int64(0), s.Latency().Count)
+	require.Equal(t, []time.Duration{time.Millisecond, time.Second}, s.Latency().Buckets, "Reset keeps buckets")
+	require.Len(t, c.BySlave(), 3, "Clone is not changed by Reset")
+	require.Equal(t, int64(2), c.Latency().Count)
+}